@@ -0,0 +1,46 @@
+package broker
+
+import (
+	"context"
+	"time"
+)
+
+// Message is a broker independent message. The key groups related messages, e.g. the callbacks of one payment, and
+// is used for partitioning where the broker supports it.
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+	Time    time.Time
+}
+
+// Delivery is a received message. It must be acknowledged once processed, Nack rejects a message that could not be
+// processed. NATS, Redis and the in-process broker deliver a rejected message again. Kafka can only commit offsets, so
+// a rejected message is committed like an acknowledged one and not delivered again; a callback message is then
+// published again by the reaper, see callback.reaper.
+type Delivery interface {
+	Message() Message
+	Ack(ctx context.Context) error
+	Nack(ctx context.Context) error
+}
+
+// Publisher publishes messages to the topic it was created for, the Topic of the messages is ignored.
+type Publisher interface {
+	Publish(ctx context.Context, messages ...Message) error
+	Close() error
+}
+
+// Bounded is implemented by publishers that queue messages in a bounded buffer. Free reports how many messages can be
+// published without blocking, so a full buffer slows the producer down instead of failing its publishes.
+type Bounded interface {
+	Free() int
+}
+
+// Subscriber receives the messages of one topic as a member of a consumer group, so every message is delivered to a
+// single member of the group.
+type Subscriber interface {
+	Topic() string
+	Fetch(ctx context.Context) (Delivery, error)
+	Close() error
+}
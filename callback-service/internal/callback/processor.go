@@ -10,8 +10,10 @@ import (
 	"callback-service/internal/db"
 	"callback-service/internal/logging"
 	"callback-service/internal/message"
+	"callback-service/internal/spool"
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/pkg/errors"
 )
 
@@ -23,98 +25,206 @@ var (
 	maxAttemptsCounter         = metrics.GetOrCreateCounter(`callback_processor_total{result="max_attempts"}`)
 	rescheduledCounter         = metrics.GetOrCreateCounter(`callback_processor_total{result="rescheduled"}`)
 	successCounter             = metrics.GetOrCreateCounter(`callback_processor_total{result="successfully_saved"}`)
+	loadErrorCounter           = metrics.GetOrCreateCounter(`callback_processor_total{result="error_loading"}`)
+	skippedCounter             = metrics.GetOrCreateCounter(`callback_processor_total{result="skipped"}`)
+	spooledCounter             = metrics.GetOrCreateCounter(`callback_processor_total{result="spooled"}`)
 )
 
 type Processor struct {
-	repo        *db.CallbackRepository
+	repo        db.Repository
 	sender      *Sender
-	sem         chan struct{}
 	maxAttempts int
 	retryDelay  time.Duration
+	retry       db.RetryPolicy
+	spool       *spool.Spool
 	logger      *slog.Logger
 }
 
-func NewCallbackProcessor(repo *db.CallbackRepository, sender *Sender, cfg config.CallbackProcessor, logger *slog.Logger) *Processor {
+// NewCallbackProcessor creates the processor. Outcomes that can not be stored because the database is unavailable are
+// spooled to outcomes, without a spool they are lost and the callback is sent again.
+func NewCallbackProcessor(repo db.Repository, sender *Sender, cfg config.CallbackProcessor, retry db.RetryPolicy,
+	outcomes *spool.Spool, logger *slog.Logger) *Processor {
 	return &Processor{
 		repo:        repo,
 		sender:      sender,
-		sem:         make(chan struct{}, cfg.Parallelism),
 		maxAttempts: cfg.MaxDeliveryAttempts,
 		retryDelay:  time.Duration(cfg.RescheduleDelayMs) * time.Millisecond,
+		retry:       retry,
+		spool:       outcomes,
 		logger:      logger.With("component", "callback.processor"),
 	}
 }
 
 func (p *Processor) Process(ctx context.Context, message message.Callback) error {
-	ctx = logging.AppendCtx(ctx, slog.String("correlationId", uuid.New().String()))
+	ctx = db.WithActor(ctx, "callback.processor")
+
+	if _, ok := logging.CorrelationID(ctx); !ok {
+		ctx = logging.WithCorrelationID(ctx, uuid.New().String())
+	}
 	ctx = logging.AppendCtx(ctx, slog.String("callbackId", message.ID.String()))
 
 	p.logger.InfoContext(ctx, "Processing callback message")
 
-	p.sem <- struct{}{}
-	go func() {
-		defer func() { <-p.sem }()
-		if err := p.processMessage(ctx, message); err != nil {
-			p.logger.ErrorContext(ctx, "Failed to process message", "error", err)
-		}
-	}()
+	if err := p.processMessage(ctx, message); err != nil {
+		p.logger.ErrorContext(ctx, "Failed to process message", "error", err)
+		return err
+	}
 
 	return nil
 }
 
 func (p *Processor) processMessage(ctx context.Context, message message.Callback) error {
-	callbackSendingErr := p.sender.Send(ctx, message.Url, message.Payload)
+	// A message may be delivered more than once, so the row decides whether it is still to be sent.
+	resolved, ok, err := p.loadCallback(ctx, message)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	message = resolved
+
+	sentAt := p.repo.Now()
+	callbackSendingErr := p.sender.Send(ctx, message)
 	if callbackSendingErr != nil {
 		p.logger.ErrorContext(ctx, fmt.Sprintf("Error sending callback: %v", callbackSendingErr))
 		processErrorSendingCounter.Inc()
 	}
 
-	tx, err := p.repo.BeginTx(ctx)
+	record := spool.Record{ID: message.ID, Attempts: message.Attempts, SentAt: sentAt}
+	if callbackSendingErr != nil {
+		errMsg := callbackSendingErr.Error()
+		record.SendError = &errMsg
+	}
+
+	err = p.recordOutcome(ctx, record)
+	if err != nil && p.spool != nil && db.IsTransient(err) {
+		// The callback was sent, so its outcome must not be lost while the database is unavailable.
+		if spoolErr := p.spool.Append(record); spoolErr != nil {
+			p.logger.ErrorContext(ctx, fmt.Sprintf("Error spooling outcome: %v", spoolErr))
+			return errors.Wrap(err, "recording outcome")
+		}
+		p.logger.WarnContext(ctx, fmt.Sprintf("Outcome spooled, database unavailable: %v", err))
+		spooledCounter.Inc()
+		return nil
+	}
 	if err != nil {
-		p.logger.ErrorContext(ctx, fmt.Sprintf("Error starting transaction: %v", err))
-		processErrorTxCounter.Inc()
-		return errors.Wrap(err, "starting transaction")
+		return errors.Wrap(err, "recording outcome")
 	}
-	defer tx.Rollback(ctx)
 
-	entity, err := p.repo.SelectForUpdateByID(ctx, tx, message.ID)
+	p.logger.InfoContext(ctx, "Transaction committed successfully")
+	processSuccessCounter.Inc()
+	return nil
+}
+
+// recordOutcome stores the outcome of sending the callback, retrying transient database errors. An outcome is only
+// stored once: a callback with more delivery attempts than the record already has the outcome of this or a later
+// attempt, e.g. when a commit failed after it was applied.
+func (p *Processor) recordOutcome(ctx context.Context, record spool.Record) error {
+	return db.InTx(ctx, p.repo, p.retry, func(tx db.Tx) error {
+		entity, err := p.repo.SelectForUpdateByID(ctx, tx, record.ID)
+		if err != nil {
+			p.logger.ErrorContext(ctx, fmt.Sprintf("Error selecting callback for update: %v", err))
+			processErrorTxCounter.Inc()
+			return errors.Wrap(err, "selecting callback for update")
+		}
+
+		if entity.DeliveryAttempts > record.Attempts {
+			p.logger.WarnContext(ctx, fmt.Sprintf("Callback has %d delivery attempts, outcome of attempt %d already recorded",
+				entity.DeliveryAttempts, record.Attempts+1))
+			return nil
+		}
+
+		var sendErr error
+		if record.SendError != nil {
+			sendErr = errors.New(*record.SendError)
+		}
+		p.updateEntity(ctx, entity, sendErr, record.SentAt)
+
+		if err := p.repo.UpdateDelivery(ctx, tx, entity); err != nil {
+			p.logger.ErrorContext(ctx, fmt.Sprintf("Error updating callback: %v", err))
+			processErrorUpdateCounter.Inc()
+			return errors.Wrap(err, "updating callback")
+		}
+		return nil
+	})
+}
+
+// Reconcile stores the spooled outcomes once the database is available again. Outcomes of callbacks that no longer
+// exist are dropped.
+func (p *Processor) Reconcile(ctx context.Context) (int, error) {
+	ctx = db.WithActor(ctx, "callback.reconciler")
+
+	return p.spool.Drain(func(record spool.Record) error {
+		recordCtx := logging.AppendCtx(ctx, slog.String("callbackId", record.ID.String()))
+
+		err := p.recordOutcome(recordCtx, record)
+		if errors.Is(err, pgx.ErrNoRows) {
+			p.logger.WarnContext(recordCtx, "Callback of spooled outcome not found, dropping outcome")
+			return nil
+		}
+		return err
+	})
+}
+
+// loadCallback loads the authoritative callback row, which also resolves a claim-check message. It reports false when
+// the callback must not be sent, because it is already delivered or superseded, or because the message is a
+// redelivery of an earlier attempt.
+func (p *Processor) loadCallback(ctx context.Context, claim message.Callback) (message.Callback, bool, error) {
+	entity, err := p.repo.SelectByID(ctx, claim.ID)
 	if err != nil {
-		p.logger.ErrorContext(ctx, fmt.Sprintf("Error selecting callback for update: %v", err))
-		processErrorTxCounter.Inc()
-		return errors.Wrap(err, "selecting callback for update")
+		p.logger.ErrorContext(ctx, fmt.Sprintf("Error loading callback: %v", err))
+		loadErrorCounter.Inc()
+		return message.Callback{}, false, errors.Wrap(err, "loading callback")
 	}
 
-	p.updateEntity(ctx, entity, callbackSendingErr)
+	if entity.DeliveredAt != nil {
+		p.logger.WarnContext(ctx, "Callback is already delivered, skipping")
+		skippedCounter.Inc()
+		return message.Callback{}, false, nil
+	}
 
-	if err := p.repo.Update(ctx, tx, entity); err != nil {
-		p.logger.ErrorContext(ctx, fmt.Sprintf("Error updating callback: %v", err))
-		processErrorUpdateCounter.Inc()
-		return errors.Wrap(err, "updating callback")
+	if entity.SupersededBy != nil {
+		p.logger.InfoContext(ctx, fmt.Sprintf("Callback is superseded by %s, skipping", entity.SupersededBy))
+		skippedCounter.Inc()
+		return message.Callback{}, false, nil
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		p.logger.ErrorContext(ctx, fmt.Sprintf("Error committing transaction: %v", err))
-		processErrorTxCounter.Inc()
-		return errors.Wrap(err, "committing transaction")
+	if entity.DeliveryAttempts > claim.Attempts {
+		p.logger.WarnContext(ctx, fmt.Sprintf("Message refers to attempt %d but callback has %d delivery attempts, skipping",
+			claim.Attempts, entity.DeliveryAttempts))
+		skippedCounter.Inc()
+		return message.Callback{}, false, nil
 	}
 
-	p.logger.InfoContext(ctx, "Transaction committed successfully")
-	processSuccessCounter.Inc()
-	return nil
+	return message.Callback{
+		ID:        entity.ID,
+		PaymentID: entity.PaymentID,
+		Url:       entity.Url,
+		Payload:   entity.Payload,
+		Attempts:  entity.DeliveryAttempts,
+	}, true, nil
 }
 
-func (p *Processor) updateEntity(ctx context.Context, entity *db.CallbackMessageEntity, callbackSendingErr error) {
+// updateEntity applies the outcome of sending the callback at sentAt.
+func (p *Processor) updateEntity(ctx context.Context, entity *db.CallbackMessageEntity, callbackSendingErr error, sentAt time.Time) {
 	entity.DeliveryAttempts++
+	entity.PublishedAt = nil
 
 	if callbackSendingErr != nil {
-		if entity.DeliveryAttempts >= p.maxAttempts {
+		if entity.SupersededBy != nil {
+			p.logger.InfoContext(ctx, "Callback was superseded while sending, not rescheduling")
+			entity.ScheduledAt = nil
+			errorMsg := "Superseded by " + entity.SupersededBy.String() + ". " + callbackSendingErr.Error()
+			entity.Error = &errorMsg
+		} else if entity.DeliveryAttempts >= p.maxAttempts {
 			p.logger.WarnContext(ctx, "Max delivery attempts reached")
 			entity.ScheduledAt = nil
 			errorMsg := "Max delivery attempts reached. " + callbackSendingErr.Error()
 			entity.Error = &errorMsg
 			maxAttemptsCounter.Inc()
 		} else {
-			scheduledAt := time.Now().Add(time.Duration(entity.DeliveryAttempts) * p.retryDelay)
+			scheduledAt := sentAt.Add(time.Duration(entity.DeliveryAttempts) * p.retryDelay)
 			errorMsg := callbackSendingErr.Error()
 			entity.ScheduledAt = &scheduledAt
 			entity.Error = &errorMsg
@@ -123,8 +233,7 @@ func (p *Processor) updateEntity(ctx context.Context, entity *db.CallbackMessage
 		}
 	} else {
 		p.logger.InfoContext(ctx, "Successfully processed callback")
-		now := time.Now()
-		entity.DeliveredAt = &now
+		entity.DeliveredAt = &sentAt
 		entity.ScheduledAt = nil
 		entity.Error = nil
 		successCounter.Inc()
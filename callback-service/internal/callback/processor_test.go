@@ -0,0 +1,243 @@
+package callback
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"callback-service/internal/clock"
+	"callback-service/internal/codec"
+	"callback-service/internal/config"
+	"callback-service/internal/db"
+	"callback-service/internal/inprocess"
+	"callback-service/internal/message"
+	"callback-service/internal/spool"
+	"github.com/google/uuid"
+	"github.com/h2non/gock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createCallback(t *testing.T, repo db.Repository) *db.CallbackMessageEntity {
+	scheduledAt := time.Now()
+	entity, err := repo.Create(context.Background(), &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   uuid.New(),
+		Payload:     `{"status":"successful"}`,
+		Url:         "http://example.com/callback",
+		ScheduledAt: &scheduledAt,
+	})
+	require.NoError(t, err)
+	return entity
+}
+
+func newTestProcessor(repo db.Repository, maxAttempts int, outcomes *spool.Spool) *Processor {
+	sender := NewSender(config.CallbackSender{TimeoutMs: 100}, slog.Default())
+	return NewCallbackProcessor(repo, sender, config.CallbackProcessor{RescheduleDelayMs: 1000, MaxDeliveryAttempts: maxAttempts},
+		db.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, outcomes, slog.Default())
+}
+
+// unavailableRepository fails selecting callbacks for update with a transient error while unavailable is set.
+type unavailableRepository struct {
+	*db.MemoryRepository
+	unavailable bool
+	selects     int
+}
+
+func (r *unavailableRepository) SelectForUpdateByID(ctx context.Context, tx db.Tx, id uuid.UUID) (*db.CallbackMessageEntity, error) {
+	r.selects++
+	if r.unavailable {
+		return nil, &pgconn.PgError{Code: "57P01", Message: "terminating connection due to administrator command"}
+	}
+	return r.MemoryRepository.SelectForUpdateByID(ctx, tx, id)
+}
+
+func TestProcessor_Process(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		maxAttempts int
+		delivered   bool
+		rescheduled bool
+		expectedErr string
+	}{
+		{name: "Delivered", status: 200, maxAttempts: 3, delivered: true},
+		{name: "Rescheduled", status: 500, maxAttempts: 3, rescheduled: true},
+		{name: "Max attempts reached", status: 500, maxAttempts: 1, expectedErr: "Max delivery attempts reached"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer gock.Off()
+			gock.New("http://example.com").Post("/callback").Reply(tt.status)
+
+			ctx := context.Background()
+			repo := db.NewMemoryRepository(clock.System, false)
+			entity := createCallback(t, repo)
+
+			processor := newTestProcessor(repo, tt.maxAttempts, nil)
+
+			err := processor.Process(ctx, message.Callback{ID: entity.ID, PaymentID: entity.PaymentID, Url: entity.Url, Payload: entity.Payload})
+			require.NoError(t, err)
+			assert.True(t, gock.IsDone())
+
+			stored, err := repo.SelectByID(ctx, entity.ID)
+			require.NoError(t, err)
+			assert.Equal(t, 1, stored.DeliveryAttempts)
+			assert.Equal(t, tt.delivered, stored.DeliveredAt != nil)
+			assert.Equal(t, tt.rescheduled, stored.ScheduledAt != nil)
+			if tt.expectedErr != "" {
+				require.NotNil(t, stored.Error)
+				assert.Contains(t, *stored.Error, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestProcessor_Process_SkipsSuperseded(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://example.com").Post("/callback").Reply(200)
+
+	ctx := context.Background()
+	repo := db.NewMemoryRepository(clock.System, false)
+	entity := createCallback(t, repo)
+
+	latest := &db.CallbackMessageEntity{ID: uuid.New(), PaymentID: entity.PaymentID, Payload: `{}`, Url: entity.Url}
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	_, err = repo.CreateTx(ctx, tx, latest)
+	require.NoError(t, err)
+	_, err = repo.Supersede(ctx, tx, latest, true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit(ctx))
+
+	processor := newTestProcessor(repo, 3, nil)
+
+	err = processor.Process(ctx, message.Callback{ID: entity.ID, PaymentID: entity.PaymentID})
+	require.NoError(t, err)
+	assert.False(t, gock.IsDone(), "superseded callback must not be sent")
+
+	stored, err := repo.SelectByID(ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stored.DeliveryAttempts)
+	assert.Nil(t, stored.DeliveredAt)
+}
+
+func TestProcessor_Process_SkipsRedelivered(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://example.com").Post("/callback").Times(1).Reply(200)
+
+	ctx := context.Background()
+	repo := db.NewMemoryRepository(clock.System, false)
+	entity := createCallback(t, repo)
+	processor := newTestProcessor(repo, 3, nil)
+
+	m := message.Callback{ID: entity.ID, PaymentID: entity.PaymentID, Url: entity.Url, Payload: entity.Payload}
+	require.NoError(t, processor.Process(ctx, m))
+	require.True(t, gock.IsDone())
+
+	gock.New("http://example.com").Post("/callback").Reply(200)
+	require.NoError(t, processor.Process(ctx, m))
+	assert.False(t, gock.IsDone(), "a delivered callback must not be sent again")
+
+	stored, err := repo.SelectByID(ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.DeliveryAttempts)
+}
+
+func TestProcessor_Process_ClaimCheck(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://example.com").Post("/callback").JSON(map[string]string{"status": "successful"}).Reply(500)
+
+	ctx := context.Background()
+	repo := db.NewMemoryRepository(clock.System, false)
+	entity := createCallback(t, repo)
+
+	callbackCodec, err := codec.NewCallbackCodec(config.CodecJSON, nil, "callback-messages", false)
+	require.NoError(t, err)
+	b := inprocess.NewBroker(10)
+	producer := NewProducer(repo, b.NewPublisher("callback-messages"), callbackCodec,
+		config.CallbackProducer{FetchSize: 10, RescheduleDelayMs: 1000, MaxPublishAttempts: 3, ClaimCheck: true}, slog.Default())
+	producer.process(ctx)
+
+	d, err := b.NewSubscriber("callback-messages").Fetch(ctx)
+	require.NoError(t, err)
+	m := d.Message()
+	assert.Equal(t, message.ClaimCheckEnabled, m.Headers[message.HeaderClaimCheck])
+
+	claim, err := callbackCodec.Decode(ctx, m.Value)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(claim.Attempts), m.Headers[message.HeaderAttempt], "the header and body agree on the attempt number")
+	assert.Empty(t, claim.Url)
+	assert.Empty(t, claim.Payload)
+	claim.ClaimCheck = m.Headers[message.HeaderClaimCheck] == message.ClaimCheckEnabled
+
+	processor := newTestProcessor(repo, 3, nil)
+
+	require.NoError(t, processor.Process(ctx, claim))
+	assert.True(t, gock.IsDone(), "the claim is resolved to the URL and payload of the callback")
+
+	stored, err := repo.SelectByID(ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.DeliveryAttempts)
+	assert.NotNil(t, stored.ScheduledAt)
+
+	require.NoError(t, processor.Process(ctx, claim))
+
+	stored, err = repo.SelectByID(ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.DeliveryAttempts, "the claim of an earlier attempt is skipped")
+}
+
+func TestProcessor_Process_SpoolsOutcome(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://example.com").Post("/callback").Reply(200)
+
+	ctx := context.Background()
+	repo := &unavailableRepository{MemoryRepository: db.NewMemoryRepository(clock.System, false)}
+	entity := createCallback(t, repo)
+
+	outcomes, err := spool.New(t.TempDir())
+	require.NoError(t, err)
+	processor := newTestProcessor(repo, 3, outcomes)
+
+	repo.unavailable = true
+	err = processor.Process(ctx, message.Callback{ID: entity.ID, PaymentID: entity.PaymentID, Url: entity.Url, Payload: entity.Payload})
+	require.NoError(t, err, "a spooled outcome acknowledges the message")
+	assert.Equal(t, 3, repo.selects, "transient errors are retried")
+
+	spooled, err := outcomes.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 1, spooled)
+
+	n, err := processor.Reconcile(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+
+	repo.unavailable = false
+	n, err = processor.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	stored, err := repo.SelectByID(ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.DeliveryAttempts)
+	assert.NotNil(t, stored.DeliveredAt)
+
+	spooled, err = outcomes.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 0, spooled)
+
+	// Reconciling an outcome again, e.g. after a crash before the spool was rewritten, does not record it twice.
+	require.NoError(t, outcomes.Append(spool.Record{ID: entity.ID, Attempts: 0, SentAt: time.Now()}))
+	n, err = processor.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	stored, err = repo.SelectByID(ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.DeliveryAttempts)
+}
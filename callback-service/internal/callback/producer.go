@@ -2,25 +2,27 @@ package callback
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"time"
 
+	"callback-service/internal/broker"
+	"callback-service/internal/codec"
 	"callback-service/internal/config"
 	"callback-service/internal/db"
 	"callback-service/internal/logging"
 	"callback-service/internal/message"
+	"callback-service/internal/tracing"
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/segmentio/kafka-go"
 )
 
 var (
 	// producer batch metrics
 	producerErrorFetchingCounter = metrics.GetOrCreateCounter(`callback_producer_total{result="fetching_failed"}`)
-	producerErrorKafkaCounter    = metrics.GetOrCreateCounter(`callback_producer_total{result="publish_failed"}`)
+	producerErrorEncodeCounter   = metrics.GetOrCreateCounter(`callback_producer_total{result="encoding_failed"}`)
+	producerErrorPublishCounter  = metrics.GetOrCreateCounter(`callback_producer_total{result="publish_failed"}`)
 	producerErrorUpdateCounter   = metrics.GetOrCreateCounter(`callback_producer_total{result="db_update_failed"}`)
 	producerSuccessCounter       = metrics.GetOrCreateCounter(`callback_producer_total{result="success"}`)
 
@@ -33,23 +35,29 @@ var (
 )
 
 type Producer struct {
-	repo               *db.CallbackRepository
-	writer             *kafka.Writer
+	repo               db.Repository
+	publisher          broker.Publisher
+	codec              codec.Codec[message.Callback]
 	pollingInterval    time.Duration
 	fetchSize          int
 	retryDelay         time.Duration
 	maxPublishAttempts int
+	claimCheck         bool
+	orderedDelivery    bool
 	logger             *slog.Logger
 }
 
-func NewProducer(repo *db.CallbackRepository, writer *kafka.Writer, cfg config.CallbackProducer, logger *slog.Logger) *Producer {
+func NewProducer(repo db.Repository, publisher broker.Publisher, callbackCodec codec.Codec[message.Callback], cfg config.CallbackProducer, logger *slog.Logger) *Producer {
 	return &Producer{
 		repo:               repo,
-		writer:             writer,
+		publisher:          publisher,
+		codec:              callbackCodec,
 		pollingInterval:    time.Duration(cfg.PollingIntervalMs) * time.Millisecond,
 		fetchSize:          cfg.FetchSize,
 		retryDelay:         time.Duration(cfg.RescheduleDelayMs) * time.Millisecond,
 		maxPublishAttempts: cfg.MaxPublishAttempts,
+		claimCheck:         cfg.ClaimCheck,
+		orderedDelivery:    cfg.OrderedDelivery,
 		logger:             logger.With("component", "callback.producer"),
 	}
 }
@@ -72,7 +80,19 @@ func (p *Producer) Start(ctx context.Context) {
 func (p *Producer) process(ctx context.Context) {
 	startTime := time.Now()
 
-	ctx = logging.AppendCtx(ctx, slog.String("correlationId", uuid.New().String()))
+	ctx = db.WithActor(ctx, "callback.producer")
+	ctx = logging.AppendCtx(ctx, slog.String("batchId", uuid.New().String()))
+
+	limit := p.fetchSize
+	if bounded, ok := p.publisher.(broker.Bounded); ok {
+		// Callbacks that do not fit into the queue are left for a later poll instead of failing to publish, which
+		// would use up their publish attempts.
+		limit = min(limit, bounded.Free())
+		if limit == 0 {
+			p.logger.InfoContext(ctx, "Broker queue is full, not fetching callbacks")
+			return
+		}
+	}
 
 	p.logger.DebugContext(ctx, "Starting DB transaction")
 	tx, err := p.repo.BeginTx(ctx)
@@ -86,7 +106,7 @@ func (p *Producer) process(ctx context.Context) {
 
 	p.logger.DebugContext(ctx, "Fetching unprocessed callbacks")
 
-	callbacks, err := p.repo.GetUnprocessedCallbacks(ctx, tx, p.fetchSize)
+	callbacks, err := p.fetchCallbacks(ctx, tx, limit)
 	if err != nil {
 		p.logger.ErrorContext(ctx, fmt.Sprintf("Error fetching callbacks: %v", err))
 		producerErrorFetchingCounter.Inc()
@@ -98,15 +118,21 @@ func (p *Producer) process(ctx context.Context) {
 		producerSuccessCounter.Inc()
 		return
 	} else {
-		kafkaMessages := p.toKafkaMessages(callbacks)
+		var messages []broker.Message
+		messages, err = p.toBrokerMessages(ctx, callbacks)
 
-		p.logger.InfoContext(ctx, "Writing messages to Kafka", slog.Int("messageCount", len(kafkaMessages)))
+		if err != nil {
+			p.logger.ErrorContext(ctx, fmt.Sprintf("Error encoding messages: %v", err))
+			producerErrorEncodeCounter.Inc()
+		} else {
+			p.logger.InfoContext(ctx, "Publishing messages", slog.Int("messageCount", len(messages)))
 
-		err = p.writer.WriteMessages(ctx, kafkaMessages...)
+			err = p.publisher.Publish(ctx, messages...)
 
-		if err != nil {
-			p.logger.ErrorContext(ctx, fmt.Sprintf("Error writing messages to Kafka: %v", err))
-			producerErrorKafkaCounter.Inc()
+			if err != nil {
+				p.logger.ErrorContext(ctx, fmt.Sprintf("Error publishing messages: %v", err))
+				producerErrorPublishCounter.Inc()
+			}
 		}
 
 		p.updateCallbacks(ctx, tx, callbacks, err)
@@ -126,48 +152,97 @@ func (p *Producer) process(ctx context.Context) {
 
 }
 
-func (p *Producer) toKafkaMessages(callbacks []*db.CallbackMessageEntity) []kafka.Message {
-	var kafkaMessages []kafka.Message
+func (p *Producer) fetchCallbacks(ctx context.Context, tx db.Tx, limit int) ([]*db.CallbackMessageEntity, error) {
+	if p.orderedDelivery {
+		return p.repo.GetUnprocessedOrderedCallbacks(ctx, tx, limit)
+	}
+	return p.repo.GetUnprocessedCallbacks(ctx, tx, limit)
+}
+
+func (p *Producer) toBrokerMessages(ctx context.Context, callbacks []*db.CallbackMessageEntity) ([]broker.Message, error) {
+	var messages []broker.Message
 
 	for _, entity := range callbacks {
 
-		callbackMessage := message.Callback{
+		messageBytes, err := p.codec.Encode(ctx, p.toMessage(entity))
+		if err != nil {
+			return nil, err
+		}
+
+		msg := broker.Message{
+			Key:     []byte(entity.PaymentID.String()), // Use payment ID as key to ensure ordering
+			Value:   messageBytes,
+			Headers: p.toHeaders(entity),
+		}
+
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// toMessage builds the message body. In claim-check mode only the callback ID, payment ID and attempt number are
+// sent and the processor loads the authoritative row from the DB before delivery.
+func (p *Producer) toMessage(entity *db.CallbackMessageEntity) message.Callback {
+	if p.claimCheck {
+		return message.Callback{
 			ID:        entity.ID,
 			PaymentID: entity.PaymentID,
-			Url:       entity.Url,
-			Payload:   entity.Payload,
 			Attempts:  entity.DeliveryAttempts,
 		}
+	}
 
-		messageBytes, _ := json.Marshal(callbackMessage)
+	return message.Callback{
+		ID:        entity.ID,
+		PaymentID: entity.PaymentID,
+		Url:       entity.Url,
+		Payload:   entity.Payload,
+		Attempts:  entity.DeliveryAttempts,
+	}
+}
 
-		msg := kafka.Message{
-			Key:   []byte(entity.PaymentID.String()), // Use payment ID as key to ensure ordering
-			Value: messageBytes,
-		}
+// toHeaders builds the message headers. Claim-check messages are marked by the claim-check header, so the processor
+// does not have to guess from the body whether to load the callback row.
+func (p *Producer) toHeaders(entity *db.CallbackMessageEntity) map[string]string {
+	correlationID := entity.CorrelationID
+	if correlationID == "" {
+		correlationID = entity.ID.String()
+	}
 
-		kafkaMessages = append(kafkaMessages, msg)
+	headers := map[string]string{
+		message.HeaderCorrelationID: correlationID,
+		message.HeaderTraceParent:   tracing.NewTraceParent(entity.TraceID).String(),
+		message.HeaderSourceEventID: entity.ID.String(),
+		message.HeaderSchemaVersion: message.CallbackSchemaVersion,
+		message.HeaderAttempt:       strconv.Itoa(entity.DeliveryAttempts),
+	}
+	if p.claimCheck {
+		headers[message.HeaderClaimCheck] = message.ClaimCheckEnabled
 	}
-	return kafkaMessages
+	return headers
 }
 
-func (p *Producer) updateCallbacks(ctx context.Context, tx pgx.Tx, callbacks []*db.CallbackMessageEntity, kafkaErr error) {
+func (p *Producer) updateCallbacks(ctx context.Context, tx db.Tx, callbacks []*db.CallbackMessageEntity, publishErr error) {
 	for _, callback := range callbacks {
 		messageCtx := logging.AppendCtx(ctx, slog.String("callbackId", callback.ID.String()))
+		if callback.CorrelationID != "" {
+			messageCtx = logging.WithCorrelationID(messageCtx, callback.CorrelationID)
+		}
 		p.logger.InfoContext(messageCtx, "Update callback message values")
 
 		callback.PublishAttempts++
-		if kafkaErr != nil {
-			errMsg := kafkaErr.Error()
+		if publishErr != nil {
+			errMsg := publishErr.Error()
 			callback.Error = &errMsg
 			p.handleMaxAttempts(messageCtx, callback)
 		} else {
+			publishedAt := p.repo.Now()
 			callback.ScheduledAt = nil
+			callback.PublishedAt = &publishedAt
 			callback.Error = nil
 			producerMessagesPublishedCounter.Inc()
 		}
 
-		if err := p.repo.Update(messageCtx, tx, callback); err != nil {
+		if err := p.repo.UpdatePublication(messageCtx, tx, callback); err != nil {
 			p.logger.ErrorContext(messageCtx, fmt.Sprintf("Error updating callback entity: %v", err))
 			return
 		}
@@ -181,7 +256,7 @@ func (p *Producer) handleMaxAttempts(ctx context.Context, callback *db.CallbackM
 		callback.ScheduledAt = nil
 		producerMessagesMaxAttemptsCounter.Inc()
 	} else {
-		scheduledAt := time.Now().Add(time.Duration(callback.PublishAttempts) * p.retryDelay)
+		scheduledAt := p.repo.Now().Add(time.Duration(callback.PublishAttempts) * p.retryDelay)
 		callback.ScheduledAt = &scheduledAt
 		producerMessagesRescheduledCounter.Inc()
 	}
@@ -0,0 +1,121 @@
+package callback
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"callback-service/internal/config"
+	"callback-service/internal/db"
+	"callback-service/internal/logging"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/google/uuid"
+)
+
+var (
+	reaperErrorFetchingCounter = metrics.GetOrCreateCounter(`callback_reaper_total{result="fetching_failed"}`)
+	reaperErrorUpdateCounter   = metrics.GetOrCreateCounter(`callback_reaper_total{result="db_update_failed"}`)
+	reaperSuccessCounter       = metrics.GetOrCreateCounter(`callback_reaper_total{result="success"}`)
+
+	reaperMessagesRescuedCounter = metrics.GetOrCreateCounter(`callback_reaper_messages_total{result="rescued"}`)
+)
+
+// Reaper re-schedules callbacks that were published to the broker but never got a delivery outcome,
+// e.g. because the message was lost or the processor crashed while handling it.
+type Reaper struct {
+	repo              db.Repository
+	interval          time.Duration
+	visibilityTimeout time.Duration
+	batchSize         int
+	logger            *slog.Logger
+}
+
+func NewReaper(repo db.Repository, cfg config.CallbackReaper, logger *slog.Logger) *Reaper {
+	return &Reaper{
+		repo:              repo,
+		interval:          time.Duration(cfg.IntervalMs) * time.Millisecond,
+		visibilityTimeout: time.Duration(cfg.VisibilityTimeoutMs) * time.Millisecond,
+		batchSize:         cfg.BatchSize,
+		logger:            logger.With("component", "callback.reaper"),
+	}
+}
+
+func (r *Reaper) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.process(ctx)
+		case <-ctx.Done():
+			r.logger.InfoContext(ctx, "Context done, stopping reaper")
+			return
+		}
+	}
+}
+
+func (r *Reaper) process(ctx context.Context) {
+	ctx = db.WithActor(ctx, "callback.reaper")
+	ctx = logging.AppendCtx(ctx, slog.String("correlationId", uuid.New().String()))
+
+	tx, err := r.repo.BeginTx(ctx)
+	if err != nil {
+		r.logger.ErrorContext(ctx, fmt.Sprintf("Error starting transaction: %v", err))
+		reaperErrorFetchingCounter.Inc()
+		return
+	}
+
+	defer tx.Rollback(ctx)
+
+	callbacks, err := r.repo.GetStaleCallbacks(ctx, tx, r.repo.Now().Add(-r.visibilityTimeout), r.batchSize)
+	if err != nil {
+		r.logger.ErrorContext(ctx, fmt.Sprintf("Error fetching stale callbacks: %v", err))
+		reaperErrorFetchingCounter.Inc()
+		return
+	}
+
+	if len(callbacks) == 0 {
+		r.logger.DebugContext(ctx, "No stale callbacks found")
+		reaperSuccessCounter.Inc()
+		return
+	}
+
+	if err := r.rescheduleCallbacks(ctx, tx, callbacks); err != nil {
+		reaperErrorUpdateCounter.Inc()
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.ErrorContext(ctx, fmt.Sprintf("Error committing transaction: %v", err))
+		reaperErrorUpdateCounter.Inc()
+		return
+	}
+
+	reaperMessagesRescuedCounter.Add(len(callbacks))
+	reaperSuccessCounter.Inc()
+}
+
+func (r *Reaper) rescheduleCallbacks(ctx context.Context, tx db.Tx, callbacks []*db.CallbackMessageEntity) error {
+	now := r.repo.Now()
+
+	for _, callback := range callbacks {
+		messageCtx := logging.AppendCtx(ctx, slog.String("callbackId", callback.ID.String()))
+
+		r.logger.WarnContext(messageCtx, fmt.Sprintf("Callback published at %v has no delivery outcome after %v, rescheduling",
+			*callback.PublishedAt, r.visibilityTimeout))
+
+		errMsg := fmt.Sprintf("No delivery outcome within visibility timeout of %v", r.visibilityTimeout)
+		callback.ScheduledAt = &now
+		callback.PublishedAt = nil
+		callback.PublishAttempts = 0
+		callback.Error = &errMsg
+
+		if err := r.repo.UpdatePublication(messageCtx, tx, callback); err != nil {
+			r.logger.ErrorContext(messageCtx, fmt.Sprintf("Error updating stale callback: %v", err))
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package callback
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"callback-service/internal/logging"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/google/uuid"
+)
+
+var (
+	reconcilerSuccessCounter = metrics.GetOrCreateCounter(`callback_reconciler_total{result="success"}`)
+	reconcilerFailedCounter  = metrics.GetOrCreateCounter(`callback_reconciler_total{result="failed"}`)
+
+	reconciledCounter = metrics.GetOrCreateCounter(`callback_reconciler_outcomes_total`)
+)
+
+// Reconciler periodically stores the outcomes the processor spooled while the database was unavailable.
+type Reconciler struct {
+	processor *Processor
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+func NewReconciler(processor *Processor, intervalMs int, logger *slog.Logger) *Reconciler {
+	return &Reconciler{
+		processor: processor,
+		interval:  time.Duration(intervalMs) * time.Millisecond,
+		logger:    logger.With("component", "callback.reconciler"),
+	}
+}
+
+func (r *Reconciler) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.Run(ctx)
+		case <-ctx.Done():
+			r.logger.InfoContext(ctx, "Context done, stopping reconciler")
+			return
+		}
+	}
+}
+
+// Run stores the spooled outcomes, in the order they were spooled, until the spool is empty or storing one fails.
+func (r *Reconciler) Run(ctx context.Context) {
+	ctx = logging.AppendCtx(ctx, slog.String("correlationId", uuid.New().String()))
+
+	n, err := r.processor.Reconcile(ctx)
+	reconciledCounter.Add(n)
+	if n > 0 {
+		r.logger.InfoContext(ctx, fmt.Sprintf("Reconciled %d spooled outcomes", n))
+	}
+	if err != nil {
+		r.logger.ErrorContext(ctx, fmt.Sprintf("Error reconciling spooled outcomes: %v", err))
+		reconcilerFailedCounter.Inc()
+		return
+	}
+	reconcilerSuccessCounter.Inc()
+}
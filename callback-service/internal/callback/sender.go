@@ -3,41 +3,50 @@ package callback
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
+	"callback-service/internal/cloudevents"
 	"callback-service/internal/config"
+	"callback-service/internal/message"
+	"callback-service/internal/tracing"
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/pkg/errors"
 )
 
 type Sender struct {
-	client *http.Client
-	logger *slog.Logger
+	client      *http.Client
+	cloudEvents config.CallbackSenderCloudEvents
+	logger      *slog.Logger
 }
 
 func NewSender(cfg config.CallbackSender, logger *slog.Logger) *Sender {
 	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
 	return &Sender{
-		client: &http.Client{Timeout: timeout},
-		logger: logger.With("component", "callback.sender"),
+		client:      &http.Client{Timeout: timeout},
+		cloudEvents: cfg.CloudEvents,
+		logger:      logger.With("component", "callback.sender"),
 	}
 }
 
-func (s *Sender) Send(ctx context.Context, url, payload string) error {
+func (s *Sender) Send(ctx context.Context, callback message.Callback) error {
 	startTime := time.Now()
+	url := callback.Url
 
-	s.logger.InfoContext(ctx, fmt.Sprintf("Sending callback to %s with payload %s", url, payload))
+	s.logger.InfoContext(ctx, fmt.Sprintf("Sending callback to %s with payload %s", url, callback.Payload))
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer([]byte(payload)))
+	req, err := s.newRequest(ctx, callback)
 	if err != nil {
 		s.logger.ErrorContext(ctx, fmt.Sprintf("Error creating request to %s", url), "error", err)
 		return errors.Wrap(err, "creating http request")
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if tp, ok := tracing.FromContext(ctx); ok {
+		req.Header.Set("traceparent", tracing.NewTraceParent(tp.TraceID).String())
+	}
 
 	resp, err := s.client.Do(req)
 	if err != nil {
@@ -64,3 +73,46 @@ func (s *Sender) Send(ctx context.Context, url, payload string) error {
 	s.logger.InfoContext(ctx, fmt.Sprintf("Callback sent successfully to %s", url))
 	return nil
 }
+
+// newRequest builds the callback request. With CloudEvents the callback ID is the event ID, so merchants can detect
+// redelivered callbacks, and the payment ID is the subject.
+func (s *Sender) newRequest(ctx context.Context, callback message.Callback) (*http.Request, error) {
+	body := []byte(callback.Payload)
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	if s.cloudEvents.Mode == config.CloudEventsModeStructured || s.cloudEvents.Mode == config.CloudEventsModeBinary {
+		now := time.Now().UTC()
+		event := cloudevents.Event{
+			SpecVersion:     cloudevents.SpecVersion,
+			ID:              callback.ID.String(),
+			Source:          s.cloudEvents.Source,
+			Type:            s.cloudEvents.Type,
+			Subject:         callback.PaymentID.String(),
+			Time:            &now,
+			DataContentType: "application/json",
+		}
+
+		if s.cloudEvents.Mode == config.CloudEventsModeStructured {
+			event.Data = json.RawMessage(callback.Payload)
+			structured, err := json.Marshal(event)
+			if err != nil {
+				return nil, errors.Wrap(err, "encoding cloud event")
+			}
+			body = structured
+			headers["Content-Type"] = cloudevents.ContentType
+		} else {
+			for key, value := range event.BinaryHeaders(cloudevents.HTTPHeaderPrefix) {
+				headers[key] = value
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback.Url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
@@ -2,11 +2,17 @@ package callback
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"log/slog"
+	"net/http"
 	"testing"
 	"time"
 
+	"callback-service/internal/cloudevents"
 	"callback-service/internal/config"
+	"callback-service/internal/message"
+	"github.com/google/uuid"
 	"github.com/h2non/gock"
 	"github.com/stretchr/testify/assert"
 )
@@ -61,7 +67,7 @@ func TestSender_Send(t *testing.T) {
 			url := "http://example.com/callback"
 			payload := `{"data":"test"}`
 
-			err := sender.Send(ctx, url, payload)
+			err := sender.Send(ctx, message.Callback{Url: url, Payload: payload})
 			if tt.expectedError {
 				assert.Error(t, err)
 				if tt.expectedErrMsg != "" {
@@ -74,3 +80,67 @@ func TestSender_Send(t *testing.T) {
 		})
 	}
 }
+
+func TestSender_Send_CloudEvents(t *testing.T) {
+	callback := message.Callback{
+		ID:        uuid.New(),
+		PaymentID: uuid.New(),
+		Url:       "http://example.com/callback",
+		Payload:   `{"data":"test"}`,
+	}
+	cloudEventsConfig := config.CallbackSenderCloudEvents{Source: "/callback-service", Type: "com.example.payment.callback"}
+
+	t.Run("Structured", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("http://example.com").
+			Post("/callback").
+			MatchHeader("Content-Type", "^application/cloudevents\\+json$").
+			AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+				body, err := io.ReadAll(req.Body)
+				if err != nil {
+					return false, err
+				}
+				var event cloudevents.Event
+				if err := json.Unmarshal(body, &event); err != nil {
+					return false, err
+				}
+				return event.Validate() == nil &&
+					event.ID == callback.ID.String() &&
+					event.Subject == callback.PaymentID.String() &&
+					event.Type == "com.example.payment.callback" &&
+					event.Time != nil &&
+					string(event.Data) == callback.Payload, nil
+			}).
+			Reply(200)
+
+		cloudEventsConfig.Mode = config.CloudEventsModeStructured
+		sender := NewSender(config.CallbackSender{TimeoutMs: 100, CloudEvents: cloudEventsConfig}, slog.Default())
+
+		assert.NoError(t, sender.Send(context.Background(), callback))
+		assert.True(t, gock.IsDone())
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("http://example.com").
+			Post("/callback").
+			MatchHeader("Content-Type", "^application/json$").
+			MatchHeader("ce-specversion", "^1\\.0$").
+			MatchHeader("ce-id", callback.ID.String()).
+			MatchHeader("ce-source", "/callback-service").
+			MatchHeader("ce-type", "com.example.payment.callback").
+			MatchHeader("ce-subject", callback.PaymentID.String()).
+			MatchHeader("ce-time", ".+").
+			AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+				body, err := io.ReadAll(req.Body)
+				return string(body) == callback.Payload, err
+			}).
+			Reply(200)
+
+		cloudEventsConfig.Mode = config.CloudEventsModeBinary
+		sender := NewSender(config.CallbackSender{TimeoutMs: 100, CloudEvents: cloudEventsConfig}, slog.Default())
+
+		assert.NoError(t, sender.Send(context.Background(), callback))
+		assert.True(t, gock.IsDone())
+	})
+}
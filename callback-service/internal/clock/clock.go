@@ -0,0 +1,95 @@
+package clock
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	syncSuccessCounter = metrics.GetOrCreateCounter(`clock_sync_total{result="success"}`)
+	syncFailedCounter  = metrics.GetOrCreateCounter(`clock_sync_total{result="failed"}`)
+
+	offsetGauge = metrics.GetOrCreateGauge(`clock_offset_seconds`, nil)
+)
+
+// Clock tells the current time. Timestamps that are compared across instances, like the time a callback is scheduled
+// at, must all be taken from the same clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// System is the clock of the host.
+var System Clock = systemClock{}
+
+// Synced is the host clock corrected by its offset to a reference clock, e.g. the database server's. Instances
+// synced to the same reference agree on the time regardless of the skew between their hosts. Until the first sync
+// succeeds it tells the host time.
+type Synced struct {
+	reference func(ctx context.Context) (time.Time, error)
+	interval  time.Duration
+	offset    atomic.Int64
+	logger    *slog.Logger
+}
+
+func NewSynced(reference func(ctx context.Context) (time.Time, error), intervalMs int, logger *slog.Logger) *Synced {
+	return &Synced{
+		reference: reference,
+		interval:  time.Duration(intervalMs) * time.Millisecond,
+		logger:    logger.With("component", "clock.synced"),
+	}
+}
+
+func (c *Synced) Now() time.Time {
+	return time.Now().Add(c.Offset())
+}
+
+// Offset is the last measured difference of the reference clock to the host clock.
+func (c *Synced) Offset() time.Duration {
+	return time.Duration(c.offset.Load())
+}
+
+// Sync measures the offset to the reference clock. The reference time is assumed to be taken halfway through the
+// round trip.
+func (c *Synced) Sync(ctx context.Context) error {
+	start := time.Now()
+	reference, err := c.reference(ctx)
+	if err != nil {
+		syncFailedCounter.Inc()
+		return err
+	}
+	roundTrip := time.Since(start)
+
+	offset := reference.Sub(start.Add(roundTrip / 2))
+	c.offset.Store(int64(offset))
+	offsetGauge.Set(offset.Seconds())
+	syncSuccessCounter.Inc()
+	return nil
+}
+
+func (c *Synced) Start(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Sync(ctx); err != nil {
+				c.logger.ErrorContext(ctx, fmt.Sprintf("Error syncing clock, keeping offset %s: %v", c.Offset(), err))
+			}
+		case <-ctx.Done():
+			c.logger.InfoContext(ctx, "Context done, stopping clock sync")
+			return
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package clock
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSynced(t *testing.T) {
+	ctx := context.Background()
+	skew := time.Hour
+	var referenceErr error
+	synced := NewSynced(func(context.Context) (time.Time, error) {
+		return time.Now().Add(skew), referenceErr
+	}, 1000, slog.Default())
+
+	assert.WithinDuration(t, time.Now(), synced.Now(), time.Second, "tells the host time before the first sync")
+
+	require.NoError(t, synced.Sync(ctx))
+	assert.InDelta(t, skew, synced.Offset(), float64(100*time.Millisecond))
+	assert.WithinDuration(t, time.Now().Add(skew), synced.Now(), time.Second)
+
+	referenceErr = errors.New("connection refused")
+	skew = 0
+	assert.Error(t, synced.Sync(ctx))
+	assert.InDelta(t, time.Hour, synced.Offset(), float64(100*time.Millisecond), "keeps the offset when syncing fails")
+}
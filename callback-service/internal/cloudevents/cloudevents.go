@@ -0,0 +1,127 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SpecVersion = "1.0"
+
+	// ContentType is the content type of events in the structured content mode.
+	ContentType = "application/cloudevents+json"
+
+	// Prefixes of the attribute headers in the binary content mode.
+	KafkaHeaderPrefix = "ce_"
+	HTTPHeaderPrefix  = "ce-"
+)
+
+// Event is a CloudEvents 1.0 event in the JSON event format. Extension attributes are not supported.
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+func (e Event) Validate() error {
+	if e.SpecVersion != SpecVersion {
+		return errors.Errorf("unsupported specversion %q", e.SpecVersion)
+	}
+	if e.ID == "" {
+		return errors.New("id is required")
+	}
+	if e.Source == "" {
+		return errors.New("source is required")
+	}
+	if e.Type == "" {
+		return errors.New("type is required")
+	}
+	return nil
+}
+
+// Payload returns the event data, decoding data_base64 if the event was encoded with it.
+func (e Event) Payload() []byte {
+	if len(e.DataBase64) > 0 {
+		return e.DataBase64
+	}
+	return e.Data
+}
+
+// IsJSON reports whether the data is JSON, which is assumed when the data content type is not set.
+func (e Event) IsJSON() bool {
+	if e.DataContentType == "" {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(e.DataContentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// IsStructured reports whether the content type is the one of the structured content mode.
+func IsStructured(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(strings.ToLower(mediaType)) == ContentType
+}
+
+// IsBinary reports whether the headers carry the attributes of an event in the binary content mode.
+func IsBinary(headers map[string]string, prefix string) bool {
+	_, ok := headers[prefix+"specversion"]
+	return ok
+}
+
+// FromStructured decodes an event in the structured content mode.
+func FromStructured(body []byte) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return Event{}, errors.Wrap(err, "decoding cloud event")
+	}
+	return e, e.Validate()
+}
+
+// FromBinary builds an event in the binary content mode from the attribute headers and the body. The data content
+// type is carried by the content type header of the binding.
+func FromBinary(headers map[string]string, prefix, contentType string, body []byte) (Event, error) {
+	e := Event{
+		SpecVersion:     headers[prefix+"specversion"],
+		ID:              headers[prefix+"id"],
+		Source:          headers[prefix+"source"],
+		Type:            headers[prefix+"type"],
+		Subject:         headers[prefix+"subject"],
+		DataContentType: contentType,
+		Data:            body,
+	}
+	if value := headers[prefix+"time"]; value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return Event{}, errors.Wrap(err, "parsing time")
+		}
+		e.Time = &t
+	}
+	return e, e.Validate()
+}
+
+// BinaryHeaders returns the attribute headers of the event in the binary content mode, without the data content type.
+func (e Event) BinaryHeaders(prefix string) map[string]string {
+	headers := map[string]string{
+		prefix + "specversion": e.SpecVersion,
+		prefix + "id":          e.ID,
+		prefix + "source":      e.Source,
+		prefix + "type":        e.Type,
+	}
+	if e.Subject != "" {
+		headers[prefix+"subject"] = e.Subject
+	}
+	if e.Time != nil {
+		headers[prefix+"time"] = e.Time.Format(time.RFC3339Nano)
+	}
+	return headers
+}
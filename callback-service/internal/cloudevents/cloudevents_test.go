@@ -0,0 +1,47 @@
+package cloudevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	eventTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	event := Event{
+		SpecVersion:     SpecVersion,
+		ID:              "1",
+		Source:          "/payments",
+		Type:            "payment.updated",
+		Subject:         "payment",
+		Time:            &eventTime,
+		DataContentType: "application/json",
+		Data:            []byte(`{}`),
+	}
+
+	headers := event.BinaryHeaders(HTTPHeaderPrefix)
+	assert.True(t, IsBinary(headers, HTTPHeaderPrefix))
+	assert.False(t, IsBinary(headers, KafkaHeaderPrefix))
+
+	decoded, err := FromBinary(headers, HTTPHeaderPrefix, "application/json", []byte(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, event, decoded)
+}
+
+func TestIsJSON(t *testing.T) {
+	assert.True(t, Event{}.IsJSON())
+	assert.True(t, Event{DataContentType: "application/json; charset=utf-8"}.IsJSON())
+	assert.True(t, Event{DataContentType: "application/vnd.payment+json"}.IsJSON())
+	assert.False(t, Event{DataContentType: "application/xml"}.IsJSON())
+}
+
+func TestFromStructured(t *testing.T) {
+	event, err := FromStructured([]byte(`{"specversion":"1.0","id":"1","source":"/payments","type":"payment.updated","data_base64":"e30="}`))
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{}`), event.Payload())
+
+	_, err = FromStructured([]byte(`{"specversion":"1.0","source":"/payments","type":"payment.updated"}`))
+	assert.EqualError(t, err, "id is required")
+}
@@ -0,0 +1,210 @@
+package codec
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"callback-service/internal/message"
+	"callback-service/internal/payload"
+	"github.com/hamba/avro/v2"
+	"github.com/pkg/errors"
+)
+
+// avroCodec decodes messages with the schema they were written with, resolved against the schema of the codec, so
+// fields added with defaults or removed by producers are handled by Avro schema resolution.
+type avroCodec[T, R any] struct {
+	schema     *subjectSchema
+	reader     avro.Schema
+	toRecord   func(T) R
+	fromRecord func(R) (T, error)
+
+	mu       sync.Mutex
+	resolved map[int]avro.Schema
+}
+
+func newAvroCodec[T, R any](schema *subjectSchema, toRecord func(T) R, fromRecord func(R) (T, error)) (*avroCodec[T, R], error) {
+	reader, err := parseAvro(schema.schema.Schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing avro schema")
+	}
+	return &avroCodec[T, R]{
+		schema:     schema,
+		reader:     reader,
+		toRecord:   toRecord,
+		fromRecord: fromRecord,
+		resolved:   make(map[int]avro.Schema),
+	}, nil
+}
+
+// parseAvro parses the schema with its own cache, the default cache would let schemas of different versions with the
+// same name replace each other.
+func parseAvro(schema string) (avro.Schema, error) {
+	return avro.ParseWithCache(schema, "", &avro.SchemaCache{})
+}
+
+func (c *avroCodec[T, R]) Encode(ctx context.Context, v T) ([]byte, error) {
+	id, err := c.schema.id(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := avro.Marshal(c.reader, c.toRecord(v))
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding avro message")
+	}
+	return append(appendHeader(make([]byte, 0, headerSize+len(data)), id), data...), nil
+}
+
+func (c *avroCodec[T, R]) Decode(ctx context.Context, data []byte) (T, error) {
+	var v T
+
+	id, data, err := readHeader(data)
+	if err != nil {
+		return v, err
+	}
+	schema, err := c.resolve(ctx, id)
+	if err != nil {
+		return v, err
+	}
+
+	var record R
+	if err := avro.Unmarshal(schema, data, &record); err != nil {
+		return v, errors.Wrap(ErrInvalid, err.Error())
+	}
+	return c.fromRecord(record)
+}
+
+func (c *avroCodec[T, R]) resolve(ctx context.Context, id int) (avro.Schema, error) {
+	c.mu.Lock()
+	schema, ok := c.resolved[id]
+	c.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	writerSchema, err := c.schema.writerSchema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := parseAvro(writerSchema.Schema)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalid, "parsing schema %d: %v", id, err)
+	}
+	schema, err = avro.NewSchemaCompatibility().Resolve(c.reader, writer)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalid, "schema %d is incompatible: %v", id, err)
+	}
+
+	c.mu.Lock()
+	c.resolved[id] = schema
+	c.mu.Unlock()
+
+	return schema, nil
+}
+
+type avroPaymentEvent struct {
+	ID      string      `avro:"id"`
+	Event   string      `avro:"event"`
+	Payload avroPayment `avro:"payload"`
+	Source  *string     `avro:"source"`
+	Time    *time.Time  `avro:"time"`
+}
+
+type avroPayment struct {
+	ID          string    `avro:"id"`
+	Amount      int64     `avro:"amount"`
+	Currency    string    `avro:"currency"`
+	Status      string    `avro:"status"`
+	CreatedAt   time.Time `avro:"createdAt"`
+	UpdatedAt   time.Time `avro:"updatedAt"`
+	CallbackUrl string    `avro:"callbackUrl"`
+}
+
+type avroCallback struct {
+	ID        string `avro:"id"`
+	PaymentID string `avro:"paymentId"`
+	Url       string `avro:"url"`
+	Payload   string `avro:"payload"`
+	Attempts  int    `avro:"attempts"`
+}
+
+func paymentEventToAvro(e message.PaymentEvent) avroPaymentEvent {
+	record := avroPaymentEvent{
+		ID:    e.ID.String(),
+		Event: e.Event,
+		Payload: avroPayment{
+			ID:          e.Payload.ID.String(),
+			Amount:      int64(e.Payload.Amount),
+			Currency:    e.Payload.Currency,
+			Status:      e.Payload.Status,
+			CreatedAt:   e.Payload.CreatedAt,
+			UpdatedAt:   e.Payload.UpdatedAt,
+			CallbackUrl: e.Payload.CallbackUrl,
+		},
+		Time: e.Time,
+	}
+	if e.Source != "" {
+		record.Source = &e.Source
+	}
+	return record
+}
+
+func paymentEventFromAvro(r avroPaymentEvent) (message.PaymentEvent, error) {
+	id, err := parseUUID(r.ID)
+	if err != nil {
+		return message.PaymentEvent{}, err
+	}
+	paymentID, err := parseUUID(r.Payload.ID)
+	if err != nil {
+		return message.PaymentEvent{}, err
+	}
+
+	e := message.PaymentEvent{
+		ID:    id,
+		Event: r.Event,
+		Payload: payload.Payment{
+			ID:          paymentID,
+			Amount:      int(r.Payload.Amount),
+			Currency:    r.Payload.Currency,
+			Status:      r.Payload.Status,
+			CreatedAt:   r.Payload.CreatedAt,
+			UpdatedAt:   r.Payload.UpdatedAt,
+			CallbackUrl: r.Payload.CallbackUrl,
+		},
+		Time: r.Time,
+	}
+	if r.Source != nil {
+		e.Source = *r.Source
+	}
+	return e, nil
+}
+
+func callbackToAvro(c message.Callback) avroCallback {
+	return avroCallback{
+		ID:        c.ID.String(),
+		PaymentID: c.PaymentID.String(),
+		Url:       c.Url,
+		Payload:   c.Payload,
+		Attempts:  c.Attempts,
+	}
+}
+
+func callbackFromAvro(r avroCallback) (message.Callback, error) {
+	id, err := parseUUID(r.ID)
+	if err != nil {
+		return message.Callback{}, err
+	}
+	paymentID, err := parseUUID(r.PaymentID)
+	if err != nil {
+		return message.Callback{}, err
+	}
+
+	return message.Callback{
+		ID:        id,
+		PaymentID: paymentID,
+		Url:       r.Url,
+		Payload:   r.Payload,
+		Attempts:  r.Attempts,
+	}, nil
+}
@@ -0,0 +1,130 @@
+package codec
+
+//go:generate protoc -I schemas --go_out=../.. --go_opt=module=callback-service payment_event.proto callback.proto
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+
+	"callback-service/internal/config"
+	"callback-service/internal/message"
+	"callback-service/internal/schemaregistry"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalid marks messages that can not be decoded. Other decoding errors, e.g. an unavailable schema registry, are
+// transient.
+var ErrInvalid = errors.New("invalid message")
+
+var (
+	//go:embed schemas/payment_event.proto
+	paymentEventProto string
+	//go:embed schemas/callback.proto
+	callbackProto string
+	//go:embed schemas/payment_event.avsc
+	paymentEventAvro string
+	//go:embed schemas/callback.avsc
+	callbackAvro string
+)
+
+type Codec[T any] interface {
+	Encode(ctx context.Context, v T) ([]byte, error)
+	Decode(ctx context.Context, data []byte) (T, error)
+}
+
+// Registry is the part of the schema registry client used by the codecs.
+type Registry interface {
+	Register(ctx context.Context, subject string, schema schemaregistry.Schema) (int, error)
+	Lookup(ctx context.Context, subject string, schema schemaregistry.Schema) (int, error)
+	SchemaByID(ctx context.Context, id int) (schemaregistry.Schema, error)
+}
+
+// Subject returns the subject of the values of the topic, following the topic name strategy.
+func Subject(topic string) string {
+	return topic + "-value"
+}
+
+func NewPaymentEventCodec(format string, registry Registry, topic string, autoRegister bool) (Codec[message.PaymentEvent], error) {
+	switch format {
+	case config.CodecJSON:
+		return jsonCodec[message.PaymentEvent]{}, nil
+	case config.CodecProtobuf:
+		return newProtobufCodec(newSubjectSchema(registry, topic, schemaregistry.TypeProtobuf, paymentEventProto, autoRegister),
+			paymentEventToProto, paymentEventFromProto), nil
+	case config.CodecAvro:
+		return newAvroCodec(newSubjectSchema(registry, topic, schemaregistry.TypeAvro, paymentEventAvro, autoRegister),
+			paymentEventToAvro, paymentEventFromAvro)
+	}
+	return nil, errors.Errorf("unknown codec %q", format)
+}
+
+func NewCallbackCodec(format string, registry Registry, topic string, autoRegister bool) (Codec[message.Callback], error) {
+	switch format {
+	case config.CodecJSON:
+		return jsonCodec[message.Callback]{}, nil
+	case config.CodecProtobuf:
+		return newProtobufCodec(newSubjectSchema(registry, topic, schemaregistry.TypeProtobuf, callbackProto, autoRegister),
+			callbackToProto, callbackFromProto), nil
+	case config.CodecAvro:
+		return newAvroCodec(newSubjectSchema(registry, topic, schemaregistry.TypeAvro, callbackAvro, autoRegister),
+			callbackToAvro, callbackFromAvro)
+	}
+	return nil, errors.Errorf("unknown codec %q", format)
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(_ context.Context, v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Decode(_ context.Context, data []byte) (T, error) {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, errors.Wrap(ErrInvalid, err.Error())
+	}
+	return v, nil
+}
+
+// subjectSchema is the schema of the values of a topic in the registry.
+type subjectSchema struct {
+	registry     Registry
+	subject      string
+	schema       schemaregistry.Schema
+	autoRegister bool
+}
+
+func newSubjectSchema(registry Registry, topic, schemaType, schema string, autoRegister bool) *subjectSchema {
+	return &subjectSchema{
+		registry:     registry,
+		subject:      Subject(topic),
+		schema:       schemaregistry.Schema{Type: schemaType, Schema: schema},
+		autoRegister: autoRegister,
+	}
+}
+
+// id returns the ID the schema is registered with. Without auto registration the schema must have been registered
+// before, e.g. by the deployment pipeline.
+func (s *subjectSchema) id(ctx context.Context) (int, error) {
+	if s.autoRegister {
+		return s.registry.Register(ctx, s.subject, s.schema)
+	}
+	return s.registry.Lookup(ctx, s.subject, s.schema)
+}
+
+// writerSchema returns the schema a message was written with.
+func (s *subjectSchema) writerSchema(ctx context.Context, id int) (schemaregistry.Schema, error) {
+	schema, err := s.registry.SchemaByID(ctx, id)
+	if err != nil {
+		var registryErr *schemaregistry.Error
+		if errors.As(err, &registryErr) && registryErr.Status == 404 {
+			return schema, errors.Wrap(ErrInvalid, err.Error())
+		}
+		return schema, err
+	}
+	if schema.Type != s.schema.Type {
+		return schema, errors.Wrapf(ErrInvalid, "schema %d is a %s schema, expected %s", id, schema.Type, s.schema.Type)
+	}
+	return schema, nil
+}
@@ -0,0 +1,191 @@
+package codec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"callback-service/internal/config"
+	"callback-service/internal/message"
+	"callback-service/internal/payload"
+	"callback-service/internal/schemaregistry"
+	"callback-service/internal/schemaregistry/schemaregistrytest"
+	"github.com/google/uuid"
+	"github.com/hamba/avro/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRegistry(t *testing.T) *schemaregistry.Client {
+	server := schemaregistrytest.NewServer()
+	t.Cleanup(server.Close)
+	return schemaregistry.NewClient(config.SchemaRegistry{URL: server.URL, TimeoutMs: 1000})
+}
+
+func testPaymentEvent() message.PaymentEvent {
+	eventTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return message.PaymentEvent{
+		ID:    uuid.New(),
+		Event: "payment.updated",
+		Payload: payload.Payment{
+			ID:          uuid.New(),
+			Amount:      100,
+			Currency:    "EUR",
+			Status:      "successful",
+			CreatedAt:   eventTime.Add(-time.Minute),
+			UpdatedAt:   eventTime,
+			CallbackUrl: "http://merchant/callback",
+		},
+		Source: "/payments",
+		Time:   &eventTime,
+	}
+}
+
+func TestPaymentEventCodec_RoundTrip(t *testing.T) {
+	for _, format := range []string{config.CodecJSON, config.CodecProtobuf, config.CodecAvro} {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+			c, err := NewPaymentEventCodec(format, newRegistry(t), "payment-events", true)
+			require.NoError(t, err)
+
+			e := testPaymentEvent()
+			data, err := c.Encode(ctx, e)
+			require.NoError(t, err)
+
+			decoded, err := c.Decode(ctx, data)
+			require.NoError(t, err)
+			assert.Equal(t, e, decoded)
+		})
+	}
+}
+
+func TestCallbackCodec_RoundTrip(t *testing.T) {
+	callbacks := map[string]message.Callback{
+		"Callback": {ID: uuid.New(), PaymentID: uuid.New(), Url: "http://merchant/callback", Payload: `{"status":"successful"}`, Attempts: 2},
+		"Claim":    {ID: uuid.New(), PaymentID: uuid.New(), Attempts: 1},
+	}
+
+	for _, format := range []string{config.CodecJSON, config.CodecProtobuf, config.CodecAvro} {
+		for name, callback := range callbacks {
+			t.Run(format+"/"+name, func(t *testing.T) {
+				ctx := context.Background()
+				c, err := NewCallbackCodec(format, newRegistry(t), "callback-messages", true)
+				require.NoError(t, err)
+
+				data, err := c.Encode(ctx, callback)
+				require.NoError(t, err)
+
+				decoded, err := c.Decode(ctx, data)
+				require.NoError(t, err)
+				assert.Equal(t, callback, decoded)
+			})
+		}
+	}
+}
+
+func TestCodec_WireFormat(t *testing.T) {
+	ctx := context.Background()
+	registry := newRegistry(t)
+
+	c, err := NewCallbackCodec(config.CodecProtobuf, registry, "callback-messages", true)
+	require.NoError(t, err)
+	data, err := c.Encode(ctx, message.Callback{ID: uuid.New(), PaymentID: uuid.New()})
+	require.NoError(t, err)
+
+	id, err := registry.Lookup(ctx, "callback-messages-value", schemaregistry.Schema{Type: schemaregistry.TypeProtobuf, Schema: callbackProto})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, byte(id), 0}, data[:6])
+}
+
+func TestCodec_WithoutAutoRegister(t *testing.T) {
+	ctx := context.Background()
+	registry := newRegistry(t)
+
+	c, err := NewCallbackCodec(config.CodecAvro, registry, "callback-messages", false)
+	require.NoError(t, err)
+
+	_, err = c.Encode(ctx, message.Callback{ID: uuid.New(), PaymentID: uuid.New()})
+	var registryErr *schemaregistry.Error
+	require.ErrorAs(t, err, &registryErr)
+	assert.Equal(t, 40401, registryErr.Code)
+
+	_, err = registry.Register(ctx, "callback-messages-value", schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: callbackAvro})
+	require.NoError(t, err)
+	_, err = c.Encode(ctx, message.Callback{ID: uuid.New(), PaymentID: uuid.New()})
+	assert.NoError(t, err)
+}
+
+func TestCodec_DecodeInvalid(t *testing.T) {
+	ctx := context.Background()
+	registry := newRegistry(t)
+
+	avroCodec, err := NewCallbackCodec(config.CodecAvro, registry, "callback-messages", true)
+	require.NoError(t, err)
+	avroData, err := avroCodec.Encode(ctx, message.Callback{ID: uuid.New(), PaymentID: uuid.New()})
+	require.NoError(t, err)
+
+	protobufCodec, err := NewCallbackCodec(config.CodecProtobuf, registry, "callback-messages-protobuf", true)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		codec Codec[message.Callback]
+		data  []byte
+	}{
+		{name: "JSON", codec: jsonCodec[message.Callback]{}, data: []byte(`{`)},
+		{name: "Missing header", codec: protobufCodec, data: []byte(`{"id":"1"}`)},
+		{name: "Unknown schema", codec: protobufCodec, data: []byte{0, 0, 0, 0, 99, 0}},
+		{name: "Schema of another type", codec: protobufCodec, data: avroData},
+		{name: "Truncated avro message", codec: avroCodec, data: avroData[:8]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.codec.Decode(ctx, tt.data)
+			assert.True(t, errors.Is(err, ErrInvalid), "expected ErrInvalid, got %v", err)
+		})
+	}
+}
+
+// TestAvroCodec_DecodesOlderWriterSchema checks that messages written with an older schema version are decoded by
+// schema resolution.
+func TestAvroCodec_DecodesOlderWriterSchema(t *testing.T) {
+	ctx := context.Background()
+	registry := newRegistry(t)
+
+	older := schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: `{
+		"type": "record",
+		"name": "Callback",
+		"namespace": "callbackservice.v1",
+		"fields": [
+			{"name": "id", "type": {"type": "string", "logicalType": "uuid"}},
+			{"name": "paymentId", "type": {"type": "string", "logicalType": "uuid"}},
+			{"name": "attempts", "type": "int"}
+		]
+	}`}
+	olderID, err := registry.Register(ctx, "callback-messages-value", older)
+	require.NoError(t, err)
+	olderSchema, err := parseAvro(older.Schema)
+	require.NoError(t, err)
+
+	callback := avroCallback{ID: uuid.New().String(), PaymentID: uuid.New().String(), Attempts: 3}
+	data, err := avroMarshal(olderSchema, olderID, callback)
+	require.NoError(t, err)
+
+	c, err := NewCallbackCodec(config.CodecAvro, registry, "callback-messages", true)
+	require.NoError(t, err)
+	decoded, err := c.Decode(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, callback.ID, decoded.ID.String())
+	assert.Equal(t, 3, decoded.Attempts)
+	assert.Empty(t, decoded.Url)
+}
+
+func avroMarshal(schema avro.Schema, id int, v any) ([]byte, error) {
+	data, err := avro.Marshal(schema, v)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendHeader(nil, id), data...), nil
+}
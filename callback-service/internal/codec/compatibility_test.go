@@ -0,0 +1,94 @@
+package codec
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/hamba/avro/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// The schemas in testdata are the released versions. A schema change must stay compatible with all of them, so
+// consumers can read messages of producers that are not upgraded yet and the other way around. Add a new version to
+// testdata when releasing a schema change.
+
+func TestAvroSchemas_AreCompatibleWithReleasedVersions(t *testing.T) {
+	tests := map[string]string{
+		"testdata/payment_event.v1.avsc": paymentEventAvro,
+		"testdata/callback.v1.avsc":      callbackAvro,
+	}
+
+	for path, current := range tests {
+		t.Run(path, func(t *testing.T) {
+			released, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			releasedSchema, err := parseAvro(string(released))
+			require.NoError(t, err)
+			currentSchema, err := parseAvro(current)
+			require.NoError(t, err)
+
+			compatibility := avro.NewSchemaCompatibility()
+			assert.NoError(t, compatibility.Compatible(currentSchema, releasedSchema), "backward")
+			assert.NoError(t, compatibility.Compatible(releasedSchema, currentSchema), "forward")
+		})
+	}
+}
+
+func TestProtobufSchemas_AreCompatibleWithReleasedVersions(t *testing.T) {
+	tests := map[string]string{
+		"payment_event.v1.proto": "payment_event.proto",
+		"callback.v1.proto":      "callback.proto",
+	}
+
+	for releasedPath, currentPath := range tests {
+		t.Run(releasedPath, func(t *testing.T) {
+			released := compileProto(t, "testdata", releasedPath)
+			current := compileProto(t, "schemas", currentPath)
+
+			messages := released.Messages()
+			for i := 0; i < messages.Len(); i++ {
+				assertMessageCompatible(t, messages.Get(i), current.Messages().ByName(messages.Get(i).Name()))
+			}
+		})
+	}
+}
+
+func compileProto(t *testing.T, dir, path string) protoreflect.FileDescriptor {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: []string{dir}}),
+	}
+	files, err := compiler.Compile(context.Background(), path)
+	require.NoError(t, err)
+	return files[0]
+}
+
+// assertMessageCompatible checks that every released field is still there with the same number and wire type, or that
+// its number is reserved.
+func assertMessageCompatible(t *testing.T, released, current protoreflect.MessageDescriptor) {
+	if !assert.NotNil(t, current, "message %s was removed", released.FullName()) {
+		return
+	}
+
+	fields := released.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if current.ReservedRanges().Has(field.Number()) {
+			continue
+		}
+
+		currentField := current.Fields().ByNumber(field.Number())
+		if !assert.NotNil(t, currentField, "field %s was removed without reserving its number", field.FullName()) {
+			continue
+		}
+		assert.Equal(t, field.Kind(), currentField.Kind(), "type of field %s changed", field.FullName())
+		assert.Equal(t, field.Cardinality(), currentField.Cardinality(), "cardinality of field %s changed", field.FullName())
+		if field.Message() != nil {
+			assert.Equal(t, field.Message().FullName(), currentField.Message().FullName(), "type of field %s changed", field.FullName())
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package codec
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"callback-service/internal/codec/pb"
+	"callback-service/internal/message"
+	"callback-service/internal/payload"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The encoded messages are the first message type of their schema.
+var protobufMessageIndexes = []int{0}
+
+type protobufCodec[T any, M proto.Message] struct {
+	schema    *subjectSchema
+	toProto   func(T) M
+	fromProto func(M) (T, error)
+}
+
+func newProtobufCodec[T any, M proto.Message](schema *subjectSchema, toProto func(T) M, fromProto func(M) (T, error)) *protobufCodec[T, M] {
+	return &protobufCodec[T, M]{schema: schema, toProto: toProto, fromProto: fromProto}
+}
+
+func (c *protobufCodec[T, M]) Encode(ctx context.Context, v T) ([]byte, error) {
+	id, err := c.schema.id(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b := appendMessageIndexes(appendHeader(nil, id), protobufMessageIndexes)
+	b, err = proto.MarshalOptions{}.MarshalAppend(b, c.toProto(v))
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding protobuf message")
+	}
+	return b, nil
+}
+
+func (c *protobufCodec[T, M]) Decode(ctx context.Context, data []byte) (T, error) {
+	var v T
+
+	id, data, err := readHeader(data)
+	if err != nil {
+		return v, err
+	}
+	if _, err := c.schema.writerSchema(ctx, id); err != nil {
+		return v, err
+	}
+	indexes, data, err := readMessageIndexes(data)
+	if err != nil {
+		return v, err
+	}
+	if !slices.Equal(indexes, protobufMessageIndexes) {
+		return v, errors.Wrapf(ErrInvalid, "unexpected message type %v of schema %d", indexes, id)
+	}
+
+	var zero M
+	m := zero.ProtoReflect().Type().New().Interface().(M)
+	if err := proto.Unmarshal(data, m); err != nil {
+		return v, errors.Wrap(ErrInvalid, err.Error())
+	}
+	return c.fromProto(m)
+}
+
+func paymentEventToProto(e message.PaymentEvent) *pb.PaymentEvent {
+	return &pb.PaymentEvent{
+		Id:    e.ID.String(),
+		Event: e.Event,
+		Payload: &pb.Payment{
+			Id:          e.Payload.ID.String(),
+			Amount:      int64(e.Payload.Amount),
+			Currency:    e.Payload.Currency,
+			Status:      e.Payload.Status,
+			CreatedAt:   timestamppb.New(e.Payload.CreatedAt),
+			UpdatedAt:   timestamppb.New(e.Payload.UpdatedAt),
+			CallbackUrl: e.Payload.CallbackUrl,
+		},
+		Source: e.Source,
+		Time:   toTimestamp(e.Time),
+	}
+}
+
+func paymentEventFromProto(m *pb.PaymentEvent) (message.PaymentEvent, error) {
+	id, err := parseUUID(m.GetId())
+	if err != nil {
+		return message.PaymentEvent{}, err
+	}
+	paymentID, err := parseUUID(m.GetPayload().GetId())
+	if err != nil {
+		return message.PaymentEvent{}, err
+	}
+
+	return message.PaymentEvent{
+		ID:    id,
+		Event: m.GetEvent(),
+		Payload: payload.Payment{
+			ID:          paymentID,
+			Amount:      int(m.GetPayload().GetAmount()),
+			Currency:    m.GetPayload().GetCurrency(),
+			Status:      m.GetPayload().GetStatus(),
+			CreatedAt:   fromTimestamp(m.GetPayload().GetCreatedAt()),
+			UpdatedAt:   fromTimestamp(m.GetPayload().GetUpdatedAt()),
+			CallbackUrl: m.GetPayload().GetCallbackUrl(),
+		},
+		Source: m.GetSource(),
+		Time:   fromOptionalTimestamp(m.GetTime()),
+	}, nil
+}
+
+func callbackToProto(c message.Callback) *pb.Callback {
+	return &pb.Callback{
+		Id:        c.ID.String(),
+		PaymentId: c.PaymentID.String(),
+		Url:       c.Url,
+		Payload:   c.Payload,
+		Attempts:  int32(c.Attempts),
+	}
+}
+
+func callbackFromProto(m *pb.Callback) (message.Callback, error) {
+	id, err := parseUUID(m.GetId())
+	if err != nil {
+		return message.Callback{}, err
+	}
+	paymentID, err := parseUUID(m.GetPaymentId())
+	if err != nil {
+		return message.Callback{}, err
+	}
+
+	return message.Callback{
+		ID:        id,
+		PaymentID: paymentID,
+		Url:       m.GetUrl(),
+		Payload:   m.GetPayload(),
+		Attempts:  int(m.GetAttempts()),
+	}, nil
+}
+
+func parseUUID(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return id, errors.Wrap(ErrInvalid, err.Error())
+	}
+	return id, nil
+}
+
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func fromTimestamp(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func fromOptionalTimestamp(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
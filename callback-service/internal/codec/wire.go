@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Messages are framed in the Confluent wire format: a zero magic byte and the big endian schema ID, followed by the
+// encoded value.
+const (
+	magicByte  = 0
+	headerSize = 5
+)
+
+func appendHeader(b []byte, id int) []byte {
+	b = append(b, magicByte)
+	return binary.BigEndian.AppendUint32(b, uint32(id))
+}
+
+func readHeader(data []byte) (int, []byte, error) {
+	if len(data) < headerSize || data[0] != magicByte {
+		return 0, nil, errors.Wrap(ErrInvalid, "missing schema ID header")
+	}
+	return int(binary.BigEndian.Uint32(data[1:headerSize])), data[headerSize:], nil
+}
+
+// appendMessageIndexes appends the path of the message type in the Protobuf schema as zigzag varints, prefixed by
+// the number of indexes. The path of the first message is written as a single zero.
+func appendMessageIndexes(b []byte, indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return append(b, 0)
+	}
+	b = binary.AppendVarint(b, int64(len(indexes)))
+	for _, index := range indexes {
+		b = binary.AppendVarint(b, int64(index))
+	}
+	return b
+}
+
+func readMessageIndexes(data []byte) ([]int, []byte, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 || count > int64(len(data)) {
+		return nil, nil, errors.Wrap(ErrInvalid, "invalid message indexes")
+	}
+	data = data[n:]
+	if count == 0 {
+		return []int{0}, data, nil
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		index, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, nil, errors.Wrap(ErrInvalid, "invalid message indexes")
+		}
+		indexes[i] = int(index)
+		data = data[n:]
+	}
+	return indexes, data, nil
+}
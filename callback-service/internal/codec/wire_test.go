@@ -0,0 +1,43 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	data := append(appendHeader(nil, 258), 'x')
+	assert.Equal(t, []byte{0, 0, 0, 1, 2, 'x'}, data)
+
+	id, rest, err := readHeader(data)
+	require.NoError(t, err)
+	assert.Equal(t, 258, id)
+	assert.Equal(t, []byte{'x'}, rest)
+
+	_, _, err = readHeader([]byte{1, 0, 0, 0, 1})
+	assert.True(t, errors.Is(err, ErrInvalid))
+}
+
+func TestMessageIndexes(t *testing.T) {
+	tests := []struct {
+		indexes []int
+		encoded []byte
+	}{
+		{indexes: []int{0}, encoded: []byte{0}},
+		{indexes: []int{1}, encoded: []byte{2, 2}},
+		{indexes: []int{1, 3}, encoded: []byte{4, 2, 6}},
+	}
+
+	for _, tt := range tests {
+		encoded := appendMessageIndexes(nil, tt.indexes)
+		assert.Equal(t, tt.encoded, encoded)
+
+		indexes, rest, err := readMessageIndexes(append(encoded, 'x'))
+		require.NoError(t, err)
+		assert.Equal(t, tt.indexes, indexes)
+		assert.Equal(t, []byte{'x'}, rest)
+	}
+}
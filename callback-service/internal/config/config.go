@@ -1,70 +1,329 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
+type DatabasePartitions struct {
+	IntervalMs    int `mapstructure:"interval-ms"`
+	PremakeDays   int `mapstructure:"premake-days"`
+	RetentionDays int `mapstructure:"retention-days"`
+	LockTimeoutMs int `mapstructure:"lock-timeout-ms"`
+	KeyBatchSize  int `mapstructure:"key-batch-size"`
+}
+
+type DatabasePool struct {
+	MaxConns            int `mapstructure:"max-conns"`
+	MinConns            int `mapstructure:"min-conns"`
+	MaxConnLifetimeMs   int `mapstructure:"max-conn-lifetime-ms"`
+	MaxConnIdleTimeMs   int `mapstructure:"max-conn-idle-time-ms"`
+	HealthCheckPeriodMs int `mapstructure:"health-check-period-ms"`
+}
+
+type DatabaseRetry struct {
+	MaxAttempts      int `mapstructure:"max-attempts"`
+	InitialBackoffMs int `mapstructure:"initial-backoff-ms"`
+	MaxBackoffMs     int `mapstructure:"max-backoff-ms"`
+}
+
+type DatabaseMigrations struct {
+	Auto          bool `mapstructure:"auto"`
+	LockTimeoutMs int  `mapstructure:"lock-timeout-ms"`
+}
+
+type DatabaseClock struct {
+	Source         string `mapstructure:"source"`
+	SyncIntervalMs int    `mapstructure:"sync-interval-ms"`
+}
+
 type Database struct {
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
-	Name     string `mapstructure:"name"`
-	Host     string `mapstructure:"host"`
-	Port     string `mapstructure:"port"`
-	SSLMode  string `mapstructure:"ssl-mode"`
+	Type       string             `mapstructure:"type"`
+	User       string             `mapstructure:"user"`
+	Password   string             `mapstructure:"password"`
+	Name       string             `mapstructure:"name"`
+	Host       string             `mapstructure:"host"`
+	Port       string             `mapstructure:"port"`
+	SSLMode    string             `mapstructure:"ssl-mode"`
+	Pool       DatabasePool       `mapstructure:"pool"`
+	Retry      DatabaseRetry      `mapstructure:"retry"`
+	Migrations DatabaseMigrations `mapstructure:"migrations"`
+	Partitions DatabasePartitions `mapstructure:"partitions"`
+	Clock      DatabaseClock      `mapstructure:"clock"`
+
+	StatementTimeoutMs int `mapstructure:"statement-timeout-ms"`
+	OperationTimeoutMs int `mapstructure:"operation-timeout-ms"`
 }
 
 type KafkaWriter struct {
-	BatchSize      int `mapstructure:"batch-size"`
-	BatchTimeoutMs int `mapstructure:"batch-timeout-ms"`
+	BatchSize      int    `mapstructure:"batch-size"`
+	BatchTimeoutMs int    `mapstructure:"batch-timeout-ms"`
+	Compression    string `mapstructure:"compression"`
+	MaxAttempts    int    `mapstructure:"max-attempts"`
+	WriteTimeoutMs int    `mapstructure:"write-timeout-ms"`
+	RequiredAcks   string `mapstructure:"required-acks"`
+	Balancer       string `mapstructure:"balancer"`
 }
 
 type KafkaBroker struct {
 	URL string `mapstructure:"url"`
 }
 
-type KafkaTopic struct {
+type KafkaReader struct {
+	StartOffset                 string `mapstructure:"start-offset"`
+	MinBytes                    int    `mapstructure:"min-bytes"`
+	MaxBytes                    int    `mapstructure:"max-bytes"`
+	MaxWaitMs                   int    `mapstructure:"max-wait-ms"`
+	CommitIntervalMs            int    `mapstructure:"commit-interval-ms"`
+	RebalanceTimeoutMs          int    `mapstructure:"rebalance-timeout-ms"`
+	PartitionAssignmentStrategy string `mapstructure:"partition-assignment-strategy"`
+}
+
+type KafkaReaders struct {
+	PaymentEvents    KafkaReader `mapstructure:"payment-events"`
+	CallbackMessages KafkaReader `mapstructure:"callback-messages"`
+}
+
+type KafkaTopicSpec struct {
+	Name              string `mapstructure:"name"`
+	Partitions        int    `mapstructure:"partitions"`
+	ReplicationFactor int    `mapstructure:"replication-factor"`
+}
+
+type KafkaProvisioningTopics struct {
+	PaymentEvents    KafkaTopicSpec `mapstructure:"payment-events"`
+	CallbackMessages KafkaTopicSpec `mapstructure:"callback-messages"`
+}
+
+type KafkaProvisioning struct {
+	Verify           bool                    `mapstructure:"verify"`
+	Provision        bool                    `mapstructure:"provision"`
+	Topics           KafkaProvisioningTopics `mapstructure:"topics"`
+	AdditionalTopics []KafkaTopicSpec        `mapstructure:"additional-topics"`
+}
+
+type KafkaTLS struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca-file"`
+	CertFile           string `mapstructure:"cert-file"`
+	KeyFile            string `mapstructure:"key-file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure-skip-verify"`
+}
+
+type KafkaSASL struct {
+	Mechanism    string `mapstructure:"mechanism"`
+	Username     string `mapstructure:"username"`
+	Password     string `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password-file"`
+}
+
+type Kafka struct {
+	Writer KafkaWriter  `mapstructure:"writer"`
+	Broker KafkaBroker  `mapstructure:"broker"`
+	Reader KafkaReaders `mapstructure:"reader"`
+	TLS    KafkaTLS     `mapstructure:"tls"`
+	SASL   KafkaSASL    `mapstructure:"sasl"`
+
+	Provisioning KafkaProvisioning `mapstructure:"provisioning"`
+
+	StatsIntervalMs int `mapstructure:"stats-interval-ms"`
+}
+
+type NatsStream struct {
+	Name     string `mapstructure:"name"`
+	Replicas int    `mapstructure:"replicas"`
+}
+
+type Nats struct {
+	URL            string     `mapstructure:"url"`
+	Stream         NatsStream `mapstructure:"stream"`
+	AckWaitMs      int        `mapstructure:"ack-wait-ms"`
+	MaxDeliver     int        `mapstructure:"max-deliver"`
+	FetchBatchSize int        `mapstructure:"fetch-batch-size"`
+}
+
+type Redis struct {
+	Address        string `mapstructure:"address"`
+	Username       string `mapstructure:"username"`
+	Password       string `mapstructure:"password"`
+	DB             int    `mapstructure:"db"`
+	MaxLen         int64  `mapstructure:"max-len"`
+	BlockMs        int    `mapstructure:"block-ms"`
+	ClaimMinIdleMs int    `mapstructure:"claim-min-idle-ms"`
+	FetchBatchSize int    `mapstructure:"fetch-batch-size"`
+}
+
+type InProcess struct {
+	BufferSize int `mapstructure:"buffer-size"`
+}
+
+const (
+	BrokerKafka     = "kafka"
+	BrokerNats      = "nats"
+	BrokerRedis     = "redis"
+	BrokerInProcess = "in-process"
+)
+
+const (
+	DatabasePostgres = "postgres"
+	DatabaseMemory   = "memory"
+)
+
+const (
+	ClockSystem   = "system"
+	ClockDatabase = "database"
+)
+
+type BrokerTopic struct {
 	PaymentEvents    string `mapstructure:"payment-events"`
 	CallbackMessages string `mapstructure:"callback-messages"`
 }
 
-type KafkaReader struct {
-	GroupID string `mapstructure:"group-id"`
+const (
+	CodecJSON     = "json"
+	CodecProtobuf = "protobuf"
+	CodecAvro     = "avro"
+)
+
+type BrokerCodec struct {
+	PaymentEvents    string `mapstructure:"payment-events"`
+	CallbackMessages string `mapstructure:"callback-messages"`
 }
 
-type Kafka struct {
-	Writer KafkaWriter `mapstructure:"writer"`
-	Broker KafkaBroker `mapstructure:"broker"`
-	Topic  KafkaTopic  `mapstructure:"topic"`
-	Reader KafkaReader `mapstructure:"reader"`
+type BrokerConsumerBatch struct {
+	Size     int `mapstructure:"size"`
+	WindowMs int `mapstructure:"window-ms"`
+}
+
+type BrokerConsumerRetry struct {
+	MaxAttempts int `mapstructure:"max-attempts"`
+	BackoffMs   int `mapstructure:"backoff-ms"`
+}
+
+type BrokerConsumer struct {
+	Group     string              `mapstructure:"group"`
+	Workers   int                 `mapstructure:"workers"`
+	QueueSize int                 `mapstructure:"queue-size"`
+	Retry     BrokerConsumerRetry `mapstructure:"retry"`
+	Batch     BrokerConsumerBatch `mapstructure:"batch"`
+}
+
+type BrokerConsumers struct {
+	PaymentEvents    BrokerConsumer `mapstructure:"payment-events"`
+	CallbackMessages BrokerConsumer `mapstructure:"callback-messages"`
+}
+
+type Broker struct {
+	Type     string          `mapstructure:"type"`
+	Topic    BrokerTopic     `mapstructure:"topic"`
+	Codec    BrokerCodec     `mapstructure:"codec"`
+	Consumer BrokerConsumers `mapstructure:"consumer"`
+}
+
+type SchemaRegistry struct {
+	URL          string `mapstructure:"url"`
+	Username     string `mapstructure:"username"`
+	Password     string `mapstructure:"password"`
+	TimeoutMs    int    `mapstructure:"timeout-ms"`
+	AutoRegister bool   `mapstructure:"auto-register"`
 }
 
 type CallbackProcessor struct {
-	Parallelism         int `mapstructure:"parallelism"`
 	RescheduleDelayMs   int `mapstructure:"reschedule-delay-ms"`
 	MaxDeliveryAttempts int `mapstructure:"max-delivery-attempts"`
 }
 
 type CallbackProducer struct {
-	PollingIntervalMs  int `mapstructure:"polling-interval-ms"`
-	FetchSize          int `mapstructure:"fetch-size"`
-	RescheduleDelayMs  int `mapstructure:"reschedule-delay-ms"`
-	MaxPublishAttempts int `mapstructure:"max-publish-attempts"`
+	PollingIntervalMs  int  `mapstructure:"polling-interval-ms"`
+	FetchSize          int  `mapstructure:"fetch-size"`
+	RescheduleDelayMs  int  `mapstructure:"reschedule-delay-ms"`
+	MaxPublishAttempts int  `mapstructure:"max-publish-attempts"`
+	ClaimCheck         bool `mapstructure:"claim-check"`
+	OrderedDelivery    bool `mapstructure:"ordered-delivery"`
+}
+
+type CallbackReaper struct {
+	IntervalMs          int `mapstructure:"interval-ms"`
+	VisibilityTimeoutMs int `mapstructure:"visibility-timeout-ms"`
+	BatchSize           int `mapstructure:"batch-size"`
+}
+
+type CallbackSpool struct {
+	Enabled             bool   `mapstructure:"enabled"`
+	Dir                 string `mapstructure:"dir"`
+	ReconcileIntervalMs int    `mapstructure:"reconcile-interval-ms"`
+}
+
+type CallbackRetentionArchive struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"`
+}
+
+type CallbackRetention struct {
+	Enabled        bool                     `mapstructure:"enabled"`
+	IntervalMs     int                      `mapstructure:"interval-ms"`
+	BatchSize      int                      `mapstructure:"batch-size"`
+	DeliveredHours int                      `mapstructure:"delivered-hours"`
+	FailedHours    int                      `mapstructure:"failed-hours"`
+	AuditHours     int                      `mapstructure:"audit-hours"`
+	Archive        CallbackRetentionArchive `mapstructure:"archive"`
+}
+
+const (
+	SupersessionNone     = "none"
+	SupersessionCancel   = "cancel"
+	SupersessionCoalesce = "coalesce"
+)
+
+type EventValidation struct {
+	EventTypes []string `mapstructure:"event-types"`
+}
+
+type Event struct {
+	Validation EventValidation `mapstructure:"validation"`
+}
+
+type CallbackSupersession struct {
+	Policy     string `mapstructure:"policy"`
+	DebounceMs int    `mapstructure:"debounce-ms"`
+}
+
+const (
+	CloudEventsModeNone       = "none"
+	CloudEventsModeStructured = "structured"
+	CloudEventsModeBinary     = "binary"
+)
+
+type CallbackSenderCloudEvents struct {
+	Mode   string `mapstructure:"mode"`
+	Source string `mapstructure:"source"`
+	Type   string `mapstructure:"type"`
 }
 
 type CallbackSender struct {
-	TimeoutMs int `mapstructure:"timeout-ms"`
+	TimeoutMs   int                       `mapstructure:"timeout-ms"`
+	CloudEvents CallbackSenderCloudEvents `mapstructure:"cloud-events"`
 }
 
 type Callback struct {
-	Processor CallbackProcessor `mapstructure:"processor"`
-	Producer  CallbackProducer  `mapstructure:"producer"`
-	Sender    CallbackSender    `mapstructure:"sender"`
+	Processor    CallbackProcessor    `mapstructure:"processor"`
+	Producer     CallbackProducer     `mapstructure:"producer"`
+	Sender       CallbackSender       `mapstructure:"sender"`
+	Reaper       CallbackReaper       `mapstructure:"reaper"`
+	Retention    CallbackRetention    `mapstructure:"retention"`
+	Spool        CallbackSpool        `mapstructure:"spool"`
+	Supersession CallbackSupersession `mapstructure:"supersession"`
 }
 
 type Server struct {
-	Port string `mapstructure:"port"`
+	Port             string `mapstructure:"port"`
+	MaxBodyBytes     int64  `mapstructure:"max-body-bytes"`
+	MaxBatchSize     int    `mapstructure:"max-batch-size"`
+	IdempotencyTTLMs int    `mapstructure:"idempotency-ttl-ms"`
 }
 
 type Metrics struct {
@@ -78,18 +337,26 @@ type Logs struct {
 }
 
 type Config struct {
-	Database Database `mapstructure:"database"`
-	Kafka    Kafka    `mapstructure:"kafka"`
-	Callback Callback `mapstructure:"callback"`
-	Server   Server   `mapstructure:"server"`
-	Metrics  Metrics  `mapstructure:"metrics"`
-	Logs     Logs     `mapstructure:"logs"`
+	Database       Database       `mapstructure:"database"`
+	Broker         Broker         `mapstructure:"broker"`
+	Kafka          Kafka          `mapstructure:"kafka"`
+	Nats           Nats           `mapstructure:"nats"`
+	Redis          Redis          `mapstructure:"redis"`
+	InProcess      InProcess      `mapstructure:"in-process"`
+	SchemaRegistry SchemaRegistry `mapstructure:"schema-registry"`
+	Event          Event          `mapstructure:"event"`
+	Callback       Callback       `mapstructure:"callback"`
+	Server         Server         `mapstructure:"server"`
+	Metrics        Metrics        `mapstructure:"metrics"`
+	Logs           Logs           `mapstructure:"logs"`
 }
 
 func LoadConfig(path string) (*Config, error) {
 	viper.AddConfigPath(path)
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
@@ -100,9 +367,180 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	// Relative directories are relative to the config file, not to the working directory the service is started in.
+	base, err := filepath.Abs(filepath.Dir(viper.ConfigFileUsed()))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []*string{&config.Callback.Spool.Dir, &config.Callback.Retention.Archive.Dir} {
+		if *dir != "" && !filepath.IsAbs(*dir) {
+			*dir = filepath.Join(base, *dir)
+		}
+	}
+
 	return &config, nil
 }
 
+func (c *Config) validate() error {
+	switch c.Broker.Type {
+	case "":
+		c.Broker.Type = BrokerKafka
+	case BrokerKafka, BrokerNats, BrokerRedis, BrokerInProcess:
+	default:
+		return fmt.Errorf("unknown broker type %q", c.Broker.Type)
+	}
+
+	// Kafka rebalances all members of a group together, so with one group for both topics a consumer of one topic
+	// joining or leaving would also stop the consumers of the other.
+	if groups := c.Broker.Consumer; c.Broker.Type != BrokerInProcess {
+		if groups.PaymentEvents.Group == "" || groups.CallbackMessages.Group == "" {
+			return fmt.Errorf("broker consumer groups are required for broker type %q", c.Broker.Type)
+		}
+		if groups.PaymentEvents.Group == groups.CallbackMessages.Group {
+			return fmt.Errorf("broker consumer groups of payment events and callback messages must differ, both are %q", groups.PaymentEvents.Group)
+		}
+	}
+
+	for _, consumer := range []BrokerConsumer{c.Broker.Consumer.PaymentEvents, c.Broker.Consumer.CallbackMessages} {
+		if consumer.Retry.MaxAttempts < 1 || consumer.Retry.BackoffMs < 0 {
+			return fmt.Errorf("broker consumer retry max attempts must be positive and backoff must not be negative")
+		}
+	}
+
+	// A message claimed while its consumer still processes it is sent twice, so it may only be claimed once the
+	// consumer has run out of attempts: each sends the callback and loads and updates it with database retries.
+	if retry := c.Broker.Consumer.CallbackMessages.Retry; c.Broker.Type == BrokerRedis {
+		attemptMs := c.Callback.Sender.TimeoutMs + (1+c.Database.Retry.MaxAttempts)*c.Database.OperationTimeoutMs
+		processingMs := retry.MaxAttempts*attemptMs + (retry.MaxAttempts-1)*retry.BackoffMs
+		if c.Redis.ClaimMinIdleMs <= processingMs {
+			return fmt.Errorf("redis claim min idle must exceed %dms, the longest processing time of a callback message", processingMs)
+		}
+	}
+
+	// A batch committed by one worker also commits the offsets of the batches before it that other workers are still
+	// processing.
+	if events := c.Broker.Consumer.PaymentEvents; c.Broker.Type == BrokerKafka && events.Batch.Size > 1 && events.Workers > 1 {
+		return fmt.Errorf("payment events consumer workers must be 1 when batching with broker type %q", BrokerKafka)
+	}
+
+	switch c.Database.Type {
+	case "":
+		c.Database.Type = DatabasePostgres
+	case DatabasePostgres:
+	case DatabaseMemory:
+		// Callbacks in memory are only seen by this instance, so they must be dispatched within it as well.
+		if c.Broker.Type != BrokerInProcess {
+			return fmt.Errorf("database type %q requires broker type %q", DatabaseMemory, BrokerInProcess)
+		}
+	default:
+		return fmt.Errorf("unknown database type %q", c.Database.Type)
+	}
+
+	switch c.Database.Clock.Source {
+	case "":
+		c.Database.Clock.Source = ClockSystem
+	case ClockSystem:
+	case ClockDatabase:
+		if c.Database.Clock.SyncIntervalMs <= 0 {
+			return fmt.Errorf("database clock sync interval must be positive for source %q", ClockDatabase)
+		}
+	default:
+		return fmt.Errorf("unknown database clock source %q", c.Database.Clock.Source)
+	}
+
+	for _, codec := range []*string{&c.Broker.Codec.PaymentEvents, &c.Broker.Codec.CallbackMessages} {
+		switch *codec {
+		case "":
+			*codec = CodecJSON
+		case CodecJSON:
+		case CodecProtobuf, CodecAvro:
+			if c.SchemaRegistry.URL == "" {
+				return fmt.Errorf("schema registry url is required for codec %q", *codec)
+			}
+		default:
+			return fmt.Errorf("unknown codec %q", *codec)
+		}
+	}
+
+	switch c.Callback.Supersession.Policy {
+	case "":
+		c.Callback.Supersession.Policy = SupersessionNone
+	case SupersessionNone, SupersessionCancel, SupersessionCoalesce:
+	default:
+		return fmt.Errorf("unknown callback supersession policy %q", c.Callback.Supersession.Policy)
+	}
+
+	cloudEvents := &c.Callback.Sender.CloudEvents
+	switch cloudEvents.Mode {
+	case "":
+		cloudEvents.Mode = CloudEventsModeNone
+	case CloudEventsModeNone:
+	case CloudEventsModeStructured, CloudEventsModeBinary:
+		if cloudEvents.Source == "" || cloudEvents.Type == "" {
+			return fmt.Errorf("callback cloud events source and type are required in mode %q", cloudEvents.Mode)
+		}
+	default:
+		return fmt.Errorf("unknown callback cloud events mode %q", cloudEvents.Mode)
+	}
+
+	pool := c.Database.Pool
+	if pool.MaxConns < 0 || pool.MinConns < 0 || pool.MaxConnLifetimeMs < 0 || pool.MaxConnIdleTimeMs < 0 || pool.HealthCheckPeriodMs < 0 {
+		return fmt.Errorf("database pool settings must not be negative")
+	}
+	if pool.MaxConns > 0 && pool.MinConns > pool.MaxConns {
+		return fmt.Errorf("database pool min conns must not exceed max conns")
+	}
+	if c.Database.StatementTimeoutMs < 0 || c.Database.OperationTimeoutMs < 0 {
+		return fmt.Errorf("database statement and operation timeouts must not be negative")
+	}
+
+	if c.Database.Migrations.LockTimeoutMs < 0 {
+		return fmt.Errorf("database migrations lock timeout must not be negative")
+	}
+
+	retry := c.Database.Retry
+	if retry.MaxAttempts < 1 || retry.InitialBackoffMs <= 0 || retry.MaxBackoffMs < retry.InitialBackoffMs {
+		return fmt.Errorf("database retry max attempts and initial backoff must be positive and max backoff must not be below the initial backoff")
+	}
+
+	partitions := c.Database.Partitions
+	if partitions.IntervalMs <= 0 || partitions.PremakeDays < 1 || partitions.LockTimeoutMs <= 0 || partitions.KeyBatchSize <= 0 {
+		return fmt.Errorf("database partitions interval, premake days, lock timeout and key batch size must be positive")
+	}
+	if partitions.RetentionDays < 0 {
+		return fmt.Errorf("database partitions retention days must not be negative")
+	}
+
+	spool := c.Callback.Spool
+	if spool.Enabled && (spool.Dir == "" || spool.ReconcileIntervalMs <= 0) {
+		return fmt.Errorf("callback spool dir and reconcile interval are required when the spool is enabled")
+	}
+
+	retention := c.Callback.Retention
+	if retention.Enabled {
+		if retention.IntervalMs <= 0 || retention.BatchSize <= 0 {
+			return fmt.Errorf("callback retention interval and batch size must be positive")
+		}
+		if retention.DeliveredHours < 0 || retention.FailedHours < 0 || retention.AuditHours < 0 {
+			return fmt.Errorf("callback retention periods must not be negative")
+		}
+		if retention.Archive.Enabled && retention.Archive.Dir == "" {
+			return fmt.Errorf("callback retention archive dir is required")
+		}
+		// Dropping a partition deletes its callbacks without archiving them.
+		if retention.Archive.Enabled && partitions.RetentionDays > 0 &&
+			partitions.RetentionDays*24 <= max(retention.DeliveredHours, retention.FailedHours) {
+			return fmt.Errorf("database partitions retention must be longer than the archived callback retention")
+		}
+	}
+
+	return nil
+}
+
 func MustLoadConfig(path string) *Config {
 	config, err := LoadConfig(path)
 	if err != nil {
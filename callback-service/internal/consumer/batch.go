@@ -0,0 +1,167 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"github.com/pkg/errors"
+)
+
+// batchMessage is a message of a batch with the context built from its headers.
+type batchMessage struct {
+	ctx     context.Context
+	message broker.Message
+}
+
+// readBatches works like readMessages but collects the fetched messages into batches of up to the batch size. A batch
+// is handed to a worker once it is full or the window has passed since its first message. Its messages are
+// acknowledged together once the batch is processed, so offsets are only committed after the whole batch is stored.
+func readBatches(ctx context.Context, subscriber broker.Subscriber, cfg config.BrokerConsumer, logger *slog.Logger, process func(context.Context, []batchMessage) []error, consumerMetrics Metrics) {
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	window := time.Duration(cfg.Batch.WindowMs) * time.Millisecond
+
+	deliveries := make(chan broker.Delivery, cfg.Batch.Size)
+	batches := make(chan []broker.Delivery)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case batch := <-batches:
+					handleBatch(ctx, batch, logger, process, consumerMetrics)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			logger.InfoContext(ctx, fmt.Sprintf("Waiting for messages from %s...", subscriber.Topic()))
+			d, err := subscriber.Fetch(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.ErrorContext(ctx, fmt.Sprintf("Error reading message: %v", err))
+				consumerMetrics.ReadErrorCounter.Inc()
+				continue
+			}
+			m := d.Message()
+			logger.InfoContext(ctx, fmt.Sprintf("Received message: %s from topic %s", string(m.Value), m.Topic))
+
+			select {
+			case deliveries <- d:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go collectBatches(ctx, deliveries, batches, cfg.Batch.Size, window)
+}
+
+// collectBatches groups deliveries into batches of up to size deliveries, waiting at most the window for a batch to
+// fill up.
+func collectBatches(ctx context.Context, deliveries <-chan broker.Delivery, batches chan<- []broker.Delivery, size int, window time.Duration) {
+	timer := time.NewTimer(window)
+	timer.Stop()
+
+	var batch []broker.Delivery
+	flush := func() bool {
+		timer.Stop()
+		select {
+		case batches <- batch:
+			batch = nil
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case d := <-deliveries:
+			batch = append(batch, d)
+			if len(batch) == 1 {
+				timer.Reset(window)
+			}
+			if len(batch) >= size && !flush() {
+				return
+			}
+		case <-timer.C:
+			if len(batch) > 0 && !flush() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// batchRetryDelay is how long a batch with failed messages waits before they are processed again.
+var batchRetryDelay = time.Second
+
+// handleBatch processes a batch and acknowledges all its messages once none failed. Failed messages are processed
+// again in place until they succeed, because Kafka can not redeliver them: acknowledging the next batch would commit
+// past them. Messages already processed are not processed again. If the context is done first, the batch is neither
+// acknowledged nor rejected, so it is delivered again after the restart.
+func handleBatch(ctx context.Context, deliveries []broker.Delivery, logger *slog.Logger, process func(context.Context, []batchMessage) []error, consumerMetrics Metrics) {
+	messages := make([]batchMessage, len(deliveries))
+	pending := make([]int, len(deliveries))
+	for i, d := range deliveries {
+		messages[i] = batchMessage{ctx: contextFromHeaders(ctx, d.Message().Headers), message: d.Message()}
+		pending[i] = i
+	}
+
+	for {
+		batch := make([]batchMessage, len(pending))
+		for j, i := range pending {
+			batch[j] = messages[i]
+		}
+
+		var failed []int
+		for j, err := range process(ctx, batch) {
+			i := pending[j]
+			switch {
+			case err == nil:
+				consumerMetrics.SuccessCounter.Inc()
+			case errors.Is(err, errUnprocessable):
+				logger.ErrorContext(messages[i].ctx, fmt.Sprintf("Dropping unprocessable message: %v", err))
+			default:
+				logger.ErrorContext(messages[i].ctx, fmt.Sprintf("Error processing message: %v", err))
+				consumerMetrics.ProcessErrorCounter.Inc()
+				failed = append(failed, i)
+			}
+		}
+		if len(failed) == 0 {
+			ackBatch(ctx, deliveries, messages, logger, consumerMetrics)
+			return
+		}
+
+		logger.WarnContext(ctx, fmt.Sprintf("Retrying %d failed messages of the batch in %s", len(failed), batchRetryDelay))
+		select {
+		case <-time.After(batchRetryDelay):
+			pending = failed
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func ackBatch(ctx context.Context, deliveries []broker.Delivery, messages []batchMessage, logger *slog.Logger, consumerMetrics Metrics) {
+	for i, d := range deliveries {
+		if err := d.Ack(ctx); err != nil {
+			logger.ErrorContext(messages[i].ctx, fmt.Sprintf("Error acknowledging message: %v", err))
+			consumerMetrics.AckErrorCounter.Inc()
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"callback-service/internal/broker"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deliveries := make(chan broker.Delivery)
+	batches := make(chan []broker.Delivery)
+	go collectBatches(ctx, deliveries, batches, 2, 50*time.Millisecond)
+
+	first, second, third := newFakeDelivery("1"), newFakeDelivery("2"), newFakeDelivery("3")
+	deliveries <- first
+	deliveries <- second
+	assert.Equal(t, []broker.Delivery{first, second}, <-batches, "a full batch is flushed at once")
+
+	start := time.Now()
+	deliveries <- third
+	assert.Equal(t, []broker.Delivery{third}, <-batches, "a partial batch is flushed after the window")
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestHandleBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		processErrs []error
+		wantAck     bool
+	}{
+		{name: "Processed batch is acknowledged", processErrs: []error{nil, nil}, wantAck: true},
+		{name: "Unprocessable messages are acknowledged with the batch", processErrs: []error{nil, errors.Wrap(errUnprocessable, "invalid json")}, wantAck: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries := []*fakeDelivery{newFakeDelivery("1"), newFakeDelivery("2")}
+
+			var processed []string
+			handleBatch(context.Background(), []broker.Delivery{deliveries[0], deliveries[1]}, slog.Default(), func(_ context.Context, messages []batchMessage) []error {
+				for _, m := range messages {
+					processed = append(processed, string(m.message.Value))
+				}
+				return tt.processErrs
+			}, paymentEventMetrics)
+
+			require.Equal(t, []string{"1", "2"}, processed)
+			for _, d := range deliveries {
+				assert.Equal(t, tt.wantAck, d.acked)
+				assert.Equal(t, !tt.wantAck, d.nacked)
+			}
+		})
+	}
+}
+
+func TestHandleBatch_RetriesFailedMessages(t *testing.T) {
+	batchRetryDelay = time.Millisecond
+	defer func() { batchRetryDelay = time.Second }()
+
+	deliveries := []*fakeDelivery{newFakeDelivery("1"), newFakeDelivery("2")}
+
+	var processed [][]string
+	handleBatch(context.Background(), []broker.Delivery{deliveries[0], deliveries[1]}, slog.Default(), func(_ context.Context, messages []batchMessage) []error {
+		var values []string
+		for _, m := range messages {
+			values = append(values, string(m.message.Value))
+		}
+		processed = append(processed, values)
+
+		if len(processed) == 1 {
+			return []error{nil, errors.New("db down")}
+		}
+		return make([]error, len(messages))
+	}, paymentEventMetrics)
+
+	assert.Equal(t, [][]string{{"1", "2"}, {"2"}}, processed, "only the failed message is processed again")
+	for _, d := range deliveries {
+		assert.True(t, d.acked)
+		assert.False(t, d.nacked)
+	}
+}
+
+func TestHandleBatch_UnsettledWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	deliveries := []*fakeDelivery{newFakeDelivery("1"), newFakeDelivery("2")}
+
+	handleBatch(ctx, []broker.Delivery{deliveries[0], deliveries[1]}, slog.Default(), func(context.Context, []batchMessage) []error {
+		cancel()
+		return []error{nil, errors.New("db down")}
+	}, paymentEventMetrics)
+
+	for _, d := range deliveries {
+		assert.False(t, d.acked)
+		assert.False(t, d.nacked, "the batch is delivered again after the restart")
+	}
+}
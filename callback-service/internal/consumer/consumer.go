@@ -0,0 +1,200 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/callback"
+	"callback-service/internal/codec"
+	"callback-service/internal/config"
+	"callback-service/internal/event"
+	"callback-service/internal/message"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/pkg/errors"
+)
+
+type Metrics struct {
+	ReadErrorCounter      *metrics.Counter
+	UnmarshalErrorCounter *metrics.Counter
+	ProcessErrorCounter   *metrics.Counter
+	AckErrorCounter       *metrics.Counter
+	SuccessCounter        *metrics.Counter
+}
+
+var (
+	// Define metrics for payment events
+	paymentEventMetrics = Metrics{
+		ReadErrorCounter:      metrics.GetOrCreateCounter(`consumer_messages_total{result="read_error",type="payment_event"}`),
+		UnmarshalErrorCounter: metrics.GetOrCreateCounter(`consumer_messages_total{result="unmarshal_error",type="payment_event"}`),
+		ProcessErrorCounter:   metrics.GetOrCreateCounter(`consumer_messages_total{result="process_error",type="payment_event"}`),
+		AckErrorCounter:       metrics.GetOrCreateCounter(`consumer_messages_total{result="ack_error",type="payment_event"}`),
+		SuccessCounter:        metrics.GetOrCreateCounter(`consumer_messages_total{result="success",type="payment_event"}`),
+	}
+
+	// Define metrics for callback messages
+	callbackMessageMetrics = Metrics{
+		ReadErrorCounter:      metrics.GetOrCreateCounter(`consumer_messages_total{result="read_error",type="callback_message"}`),
+		UnmarshalErrorCounter: metrics.GetOrCreateCounter(`consumer_messages_total{result="unmarshal_error",type="callback_message"}`),
+		ProcessErrorCounter:   metrics.GetOrCreateCounter(`consumer_messages_total{result="process_error",type="callback_message"}`),
+		AckErrorCounter:       metrics.GetOrCreateCounter(`consumer_messages_total{result="ack_error",type="callback_message"}`),
+		SuccessCounter:        metrics.GetOrCreateCounter(`consumer_messages_total{result="success",type="callback_message"}`),
+	}
+)
+
+// errUnprocessable marks messages that can never be processed, they are acknowledged instead of being redelivered.
+var errUnprocessable = errors.New("unprocessable message")
+
+// ReadPaymentEvents processes payment events one by one, or in batches if the batch size is larger than one.
+func ReadPaymentEvents(subscriber broker.Subscriber, cfg config.BrokerConsumer, paymentEventCodec codec.Codec[message.PaymentEvent], processor *event.Processor, logger *slog.Logger) {
+	logger = logger.With("component", "consumer.payment_events")
+
+	if cfg.Batch.Size > 1 {
+		readBatches(context.Background(), subscriber, cfg, logger, func(ctx context.Context, messages []batchMessage) []error {
+			return processPaymentEventBatch(ctx, messages, paymentEventCodec, processor, logger)
+		}, paymentEventMetrics)
+		return
+	}
+
+	readMessages(context.Background(), subscriber, cfg, logger, func(ctx context.Context, m broker.Message) error {
+		e, err := decodePaymentEvent(ctx, m, paymentEventCodec)
+		if err != nil {
+			return decodeError(ctx, err, logger, paymentEventMetrics)
+		}
+		_, err = processor.Process(ctx, e)
+		return processError(err)
+	}, paymentEventMetrics)
+}
+
+func processPaymentEventBatch(ctx context.Context, messages []batchMessage, paymentEventCodec codec.Codec[message.PaymentEvent], processor *event.Processor, logger *slog.Logger) []error {
+	errs := make([]error, len(messages))
+
+	var (
+		indexes []int
+		events  []event.BatchEvent
+	)
+	for i, m := range messages {
+		e, err := decodePaymentEvent(m.ctx, m.message, paymentEventCodec)
+		if err != nil {
+			errs[i] = decodeError(m.ctx, err, logger, paymentEventMetrics)
+			continue
+		}
+		indexes = append(indexes, i)
+		events = append(events, event.BatchEvent{Ctx: m.ctx, Event: e})
+	}
+	if len(events) == 0 {
+		return errs
+	}
+
+	for j, result := range processor.ProcessBatch(ctx, events) {
+		errs[indexes[j]] = processError(result.Err)
+	}
+	return errs
+}
+
+// processError marks rejected events as unprocessable.
+func processError(err error) error {
+	var validationErr *event.ValidationError
+	if errors.As(err, &validationErr) {
+		return errors.Wrap(errUnprocessable, err.Error())
+	}
+	return err
+}
+
+func ReadCallbackMessages(subscriber broker.Subscriber, cfg config.BrokerConsumer, callbackCodec codec.Codec[message.Callback], processor *callback.Processor, logger *slog.Logger) {
+	logger = logger.With("component", "consumer.callback_messages")
+	readMessages(context.Background(), subscriber, cfg, logger, func(ctx context.Context, m broker.Message) error {
+		c, err := callbackCodec.Decode(ctx, m.Value)
+		if err != nil {
+			return decodeError(ctx, err, logger, callbackMessageMetrics)
+		}
+		c.ClaimCheck = m.Headers[message.HeaderClaimCheck] == message.ClaimCheckEnabled
+		return processor.Process(ctx, c)
+	}, callbackMessageMetrics)
+}
+
+// decodeError marks messages that can not be decoded as unprocessable. Other errors, e.g. when the schema registry is
+// unavailable, are returned as they are, so the message is redelivered.
+func decodeError(ctx context.Context, err error, logger *slog.Logger, consumerMetrics Metrics) error {
+	logger.ErrorContext(ctx, fmt.Sprintf("Error unmarshalling message: %v", err))
+	consumerMetrics.UnmarshalErrorCounter.Inc()
+
+	if errors.Is(err, codec.ErrInvalid) {
+		return errors.Wrap(errUnprocessable, err.Error())
+	}
+	return err
+}
+
+// readMessages fetches messages and hands them to a worker pool. A message is acknowledged once it is processed. If
+// processing fails it is retried in place up to the configured attempts and then negatively acknowledged, see
+// broker.Delivery for what the brokers do with it. With several workers messages finish out of order; brokers
+// committing offsets, like Kafka, only commit up to the last message before which all messages of the partition are
+// settled, so a restart can redeliver already processed messages but never skips one.
+func readMessages(ctx context.Context, subscriber broker.Subscriber, cfg config.BrokerConsumer, logger *slog.Logger, process func(context.Context, broker.Message) error, consumerMetrics Metrics) {
+	pool := newWorkerPool(subscriber.Topic(), cfg.Workers, cfg.QueueSize)
+	pool.start(ctx, func(d broker.Delivery) {
+		handleDelivery(ctx, d, cfg.Retry, logger, process, consumerMetrics)
+	})
+
+	go func() {
+		for {
+			logger.InfoContext(ctx, fmt.Sprintf("Waiting for messages from %s...", subscriber.Topic()))
+			d, err := subscriber.Fetch(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.ErrorContext(ctx, fmt.Sprintf("Error reading message: %v", err))
+				consumerMetrics.ReadErrorCounter.Inc()
+				continue
+			}
+			m := d.Message()
+			logger.InfoContext(ctx, fmt.Sprintf("Received message: %s from topic %s", string(m.Value), m.Topic))
+
+			if err := pool.submit(ctx, d); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// handleDelivery processes a message, retrying it in place while attempts remain. If the context is done while
+// waiting to retry, the message is neither acknowledged nor rejected, so it is delivered again after the restart.
+func handleDelivery(ctx context.Context, d broker.Delivery, retry config.BrokerConsumerRetry, logger *slog.Logger, process func(context.Context, broker.Message) error, consumerMetrics Metrics) {
+	msgCtx := contextFromHeaders(ctx, d.Message().Headers)
+	backoff := time.Duration(retry.BackoffMs) * time.Millisecond
+
+	err, attempt := process(msgCtx, d.Message()), 1
+	for ; err != nil && !errors.Is(err, errUnprocessable) && attempt < retry.MaxAttempts; attempt++ {
+		logger.WarnContext(msgCtx, fmt.Sprintf("Error processing message, retrying in %s: %v", backoff, err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		err = process(msgCtx, d.Message())
+	}
+
+	switch {
+	case err == nil:
+		consumerMetrics.SuccessCounter.Inc()
+	case errors.Is(err, errUnprocessable):
+		logger.ErrorContext(msgCtx, fmt.Sprintf("Dropping unprocessable message: %v", err))
+	default:
+		logger.ErrorContext(msgCtx, fmt.Sprintf("Error processing message, giving up after %d attempts: %v", attempt, err))
+		consumerMetrics.ProcessErrorCounter.Inc()
+
+		if err := d.Nack(ctx); err != nil {
+			logger.ErrorContext(msgCtx, fmt.Sprintf("Error rejecting message: %v", err))
+			consumerMetrics.AckErrorCounter.Inc()
+		}
+		return
+	}
+
+	if err := d.Ack(ctx); err != nil {
+		logger.ErrorContext(msgCtx, fmt.Sprintf("Error acknowledging message: %v", err))
+		consumerMetrics.AckErrorCounter.Inc()
+	}
+}
@@ -0,0 +1,99 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"callback-service/internal/logging"
+	"callback-service/internal/message"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDelivery struct {
+	message broker.Message
+	acked   bool
+	nacked  bool
+}
+
+func newFakeDelivery(value string) *fakeDelivery {
+	return &fakeDelivery{message: broker.Message{Topic: "test", Value: []byte(value)}}
+}
+
+func (d *fakeDelivery) Message() broker.Message {
+	return d.message
+}
+
+func (d *fakeDelivery) Ack(context.Context) error {
+	d.acked = true
+	return nil
+}
+
+func (d *fakeDelivery) Nack(context.Context) error {
+	d.nacked = true
+	return nil
+}
+
+var testRetry = config.BrokerConsumerRetry{MaxAttempts: 3, BackoffMs: 1}
+
+func TestHandleDelivery(t *testing.T) {
+	tests := []struct {
+		name         string
+		processErrs  []error
+		wantAck      bool
+		wantNack     bool
+		wantAttempts int
+	}{
+		{name: "Processed message is acknowledged", processErrs: []error{nil}, wantAck: true, wantAttempts: 1},
+		{name: "Failed message is retried", processErrs: []error{errors.New("db down"), nil}, wantAck: true, wantAttempts: 2},
+		{name: "Failed message is rejected after max attempts", processErrs: []error{errors.New("db down")}, wantNack: true, wantAttempts: 3},
+		{name: "Unprocessable message is acknowledged", processErrs: []error{errors.Wrap(errUnprocessable, "invalid json")}, wantAck: true, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newFakeDelivery("{}")
+			attempts := 0
+			handleDelivery(context.Background(), d, testRetry, slog.Default(), func(context.Context, broker.Message) error {
+				err := tt.processErrs[min(attempts, len(tt.processErrs)-1)]
+				attempts++
+				return err
+			}, paymentEventMetrics)
+
+			assert.Equal(t, tt.wantAck, d.acked)
+			assert.Equal(t, tt.wantNack, d.nacked)
+			assert.Equal(t, tt.wantAttempts, attempts)
+		})
+	}
+}
+
+func TestHandleDelivery_UnsettledWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := newFakeDelivery("{}")
+
+	handleDelivery(ctx, d, config.BrokerConsumerRetry{MaxAttempts: 3, BackoffMs: int(time.Minute.Milliseconds())}, slog.Default(),
+		func(context.Context, broker.Message) error {
+			cancel()
+			return errors.New("db down")
+		}, paymentEventMetrics)
+
+	assert.False(t, d.acked)
+	assert.False(t, d.nacked, "the message is delivered again after the restart")
+}
+
+func TestHandleDelivery_PassesHeadersToContext(t *testing.T) {
+	d := newFakeDelivery("{}")
+	d.message.Headers = map[string]string{message.HeaderCorrelationID: "correlation"}
+
+	var correlationID string
+	handleDelivery(context.Background(), d, testRetry, slog.Default(), func(ctx context.Context, _ broker.Message) error {
+		correlationID, _ = logging.CorrelationID(ctx)
+		return nil
+	}, paymentEventMetrics)
+
+	assert.Equal(t, "correlation", correlationID)
+}
@@ -0,0 +1,72 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/cloudevents"
+	"callback-service/internal/codec"
+	"callback-service/internal/message"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// decodePaymentEvent decodes a payment event from a CloudEvent in the structured or binary content mode of the Kafka
+// protocol binding, or from the bespoke envelope with the codec of the topic. Structured events are also recognised
+// without the content type header by their specversion attribute. CloudEvents are always JSON.
+func decodePaymentEvent(ctx context.Context, m broker.Message, paymentEventCodec codec.Codec[message.PaymentEvent]) (message.PaymentEvent, error) {
+	var (
+		e   message.PaymentEvent
+		ce  cloudevents.Event
+		err error
+	)
+
+	switch {
+	case cloudevents.IsBinary(m.Headers, cloudevents.KafkaHeaderPrefix):
+		ce, err = cloudevents.FromBinary(m.Headers, cloudevents.KafkaHeaderPrefix, m.Headers[message.HeaderContentType], m.Value)
+	case cloudevents.IsStructured(m.Headers[message.HeaderContentType]) || hasSpecVersion(m.Value):
+		ce, err = cloudevents.FromStructured(m.Value)
+	default:
+		return paymentEventCodec.Decode(ctx, m.Value)
+	}
+	if err == nil {
+		e, err = fromCloudEvent(ce)
+	}
+	if err != nil {
+		return e, errors.Wrapf(codec.ErrInvalid, "decoding cloud event: %v", err)
+	}
+	return e, nil
+}
+
+func hasSpecVersion(value []byte) bool {
+	var attributes struct {
+		SpecVersion *string `json:"specversion"`
+	}
+	return json.Unmarshal(value, &attributes) == nil && attributes.SpecVersion != nil
+}
+
+// fromCloudEvent maps a CloudEvent to a payment event. The data is the payment. IDs that are not UUIDs are mapped to
+// a name based UUID of the source and ID, which are unique together, so redelivered events keep their callback ID.
+func fromCloudEvent(ce cloudevents.Event) (message.PaymentEvent, error) {
+	e := message.PaymentEvent{
+		Event:  ce.Type,
+		Source: ce.Source,
+		Time:   ce.Time,
+	}
+
+	if !ce.IsJSON() {
+		return e, errors.Errorf("unsupported data content type %q", ce.DataContentType)
+	}
+	if err := json.Unmarshal(ce.Payload(), &e.Payload); err != nil {
+		return e, errors.Wrap(err, "decoding cloud event data")
+	}
+
+	id, err := uuid.Parse(ce.ID)
+	if err != nil {
+		id = uuid.NewSHA1(uuid.NameSpaceURL, []byte(ce.Source+"#"+ce.ID))
+	}
+	e.ID = id
+
+	return e, nil
+}
@@ -0,0 +1,154 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/codec"
+	"callback-service/internal/config"
+	"callback-service/internal/event"
+	"callback-service/internal/message"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPayment = `{"id":"a6fa3bd2-6ae4-4b26-9d26-34d3a8e0b1a4","status":"successful","callbackUrl":"http://merchant/callback"}`
+
+func newJSONCodec(t *testing.T) codec.Codec[message.PaymentEvent] {
+	c, err := codec.NewPaymentEventCodec(config.CodecJSON, nil, "payment-events", false)
+	require.NoError(t, err)
+	return c
+}
+
+func TestDecodePaymentEvent(t *testing.T) {
+	eventID := uuid.MustParse("99d2aa54-7dc6-487e-a3eb-77a5c6135446")
+	paymentID := uuid.MustParse("a6fa3bd2-6ae4-4b26-9d26-34d3a8e0b1a4")
+	eventTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		message    broker.Message
+		wantID     uuid.UUID
+		wantEvent  string
+		wantSource string
+		wantTime   *time.Time
+	}{
+		{
+			name:      "Envelope",
+			message:   broker.Message{Value: []byte(`{"id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446","event":"payment.updated","payload":` + testPayment + `}`)},
+			wantID:    eventID,
+			wantEvent: "payment.updated",
+		},
+		{
+			name: "Structured cloud event",
+			message: broker.Message{
+				Headers: map[string]string{message.HeaderContentType: "application/cloudevents+json; charset=UTF-8"},
+				Value:   []byte(`{"specversion":"1.0","id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446","source":"/payments","type":"payment.updated","time":"2024-05-01T12:00:00Z","data":` + testPayment + `}`),
+			},
+			wantID:     eventID,
+			wantEvent:  "payment.updated",
+			wantSource: "/payments",
+			wantTime:   &eventTime,
+		},
+		{
+			name:       "Structured cloud event without content type",
+			message:    broker.Message{Value: []byte(`{"specversion":"1.0","id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446","source":"/payments","type":"payment.updated","data":` + testPayment + `}`)},
+			wantID:     eventID,
+			wantEvent:  "payment.updated",
+			wantSource: "/payments",
+		},
+		{
+			name: "Binary cloud event",
+			message: broker.Message{
+				Headers: map[string]string{
+					"ce_specversion":          "1.0",
+					"ce_id":                   "99d2aa54-7dc6-487e-a3eb-77a5c6135446",
+					"ce_source":               "/payments",
+					"ce_type":                 "payment.updated",
+					"ce_time":                 "2024-05-01T12:00:00Z",
+					message.HeaderContentType: "application/json",
+				},
+				Value: []byte(testPayment),
+			},
+			wantID:     eventID,
+			wantEvent:  "payment.updated",
+			wantSource: "/payments",
+			wantTime:   &eventTime,
+		},
+		{
+			name: "Cloud event ID that is not a UUID",
+			message: broker.Message{
+				Headers: map[string]string{"ce_specversion": "1.0", "ce_id": "42", "ce_source": "/payments", "ce_type": "payment.updated"},
+				Value:   []byte(testPayment),
+			},
+			wantID:     uuid.NewSHA1(uuid.NameSpaceURL, []byte("/payments#42")),
+			wantEvent:  "payment.updated",
+			wantSource: "/payments",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := decodePaymentEvent(context.Background(), tt.message, newJSONCodec(t))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantID, e.ID)
+			assert.Equal(t, tt.wantEvent, e.Event)
+			assert.Equal(t, tt.wantSource, e.Source)
+			assert.Equal(t, tt.wantTime, e.Time)
+			assert.Equal(t, paymentID, e.Payload.ID)
+			assert.Equal(t, "successful", e.Payload.Status)
+		})
+	}
+}
+
+func TestDecodePaymentEvent_CloudEventIsValidWithDefaultConfig(t *testing.T) {
+	cfg, err := config.LoadConfig("../..")
+	require.NoError(t, err)
+
+	m := broker.Message{
+		Headers: map[string]string{message.HeaderContentType: "application/cloudevents+json"},
+		Value: []byte(`{"specversion":"1.0","id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446","source":"/payments",` +
+			`"type":"com.example.payment.updated","time":"2021-09-29T12:00:00Z","datacontenttype":"application/json",` +
+			`"data":{"id":"e3814f7f-b6ba-4cf8-923b-f7064c8b614c","amount":100,"currency":"USD","status":"successful",` +
+			`"createdAt":"2021-09-29T12:00:00Z","updatedAt":"2021-09-29T12:00:00Z","callbackUrl":"http://localhost:8085/callback"}}`),
+	}
+
+	e, err := decodePaymentEvent(context.Background(), m, newJSONCodec(t))
+	require.NoError(t, err)
+	assert.NoError(t, event.NewValidator(cfg.Event.Validation).Validate(e))
+}
+
+func TestDecodePaymentEvent_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		message broker.Message
+	}{
+		{name: "Invalid JSON", message: broker.Message{Value: []byte(`{`)}},
+		{
+			name:    "Unsupported spec version",
+			message: broker.Message{Value: []byte(`{"specversion":"0.3","id":"1","source":"/payments","type":"payment.updated","data":{}}`)},
+		},
+		{
+			name:    "Missing source",
+			message: broker.Message{Headers: map[string]string{"ce_specversion": "1.0", "ce_id": "1", "ce_type": "payment.updated"}, Value: []byte(testPayment)},
+		},
+		{
+			name: "Data that is not JSON",
+			message: broker.Message{
+				Headers: map[string]string{"ce_specversion": "1.0", "ce_id": "1", "ce_source": "/payments", "ce_type": "payment.updated", message.HeaderContentType: "application/xml"},
+				Value:   []byte(`<payment/>`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodePaymentEvent(context.Background(), tt.message, newJSONCodec(t))
+			assert.ErrorIs(t, err, codec.ErrInvalid)
+		})
+	}
+}
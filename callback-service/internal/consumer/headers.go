@@ -0,0 +1,37 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+
+	"callback-service/internal/logging"
+	"callback-service/internal/message"
+	"callback-service/internal/tracing"
+)
+
+// contextFromHeaders carries the correlation ID and trace context of a message into the processing context, so that
+// logs of every component handling the same payment share one correlation ID.
+func contextFromHeaders(ctx context.Context, headers map[string]string) context.Context {
+	for key, value := range headers {
+		if value == "" {
+			continue
+		}
+
+		switch key {
+		case message.HeaderCorrelationID:
+			ctx = logging.WithCorrelationID(ctx, value)
+		case message.HeaderTraceParent:
+			if tp, err := tracing.ParseTraceParent(value); err == nil {
+				ctx = tracing.WithTraceParent(ctx, tp)
+				ctx = logging.AppendCtx(ctx, slog.String("traceId", tp.TraceID))
+			}
+		case message.HeaderSourceEventID:
+			ctx = logging.AppendCtx(ctx, slog.String("sourceEventId", value))
+		case message.HeaderSchemaVersion:
+			ctx = logging.AppendCtx(ctx, slog.String("schemaVersion", value))
+		case message.HeaderAttempt:
+			ctx = logging.AppendCtx(ctx, slog.String("attempt", value))
+		}
+	}
+	return ctx
+}
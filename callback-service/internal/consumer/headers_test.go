@@ -0,0 +1,41 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+
+	"callback-service/internal/logging"
+	"callback-service/internal/message"
+	"callback-service/internal/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextFromHeaders(t *testing.T) {
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	ctx := contextFromHeaders(context.Background(), map[string]string{
+		message.HeaderCorrelationID: "correlation",
+		message.HeaderTraceParent:   traceParent,
+		message.HeaderAttempt:       "2",
+	})
+
+	correlationID, ok := logging.CorrelationID(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "correlation", correlationID)
+
+	tp, ok := tracing.FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, traceParent, tp.String())
+}
+
+func TestContextFromHeaders_InvalidTraceParent(t *testing.T) {
+	ctx := contextFromHeaders(context.Background(), map[string]string{
+		message.HeaderTraceParent: "invalid",
+	})
+
+	_, ok := tracing.FromContext(ctx)
+	assert.False(t, ok)
+
+	_, ok = logging.CorrelationID(ctx)
+	assert.False(t, ok)
+}
@@ -0,0 +1,81 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"callback-service/internal/broker"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// workerPool processes messages with a fixed number of workers fed by a bounded queue. Submitting blocks while the
+// queue is full, so the consumer stops fetching from the broker until the workers catch up.
+type workerPool struct {
+	queue   chan broker.Delivery
+	workers int
+	busy    atomic.Int64
+
+	queueFullCounter *metrics.Counter
+}
+
+func newWorkerPool(topic string, workers, queueSize int) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &workerPool{
+		queue:            make(chan broker.Delivery, queueSize),
+		workers:          workers,
+		queueFullCounter: metrics.GetOrCreateCounter(fmt.Sprintf(`consumer_worker_queue_full_total{topic="%s"}`, topic)),
+	}
+
+	metrics.GetOrCreateGauge(fmt.Sprintf(`consumer_worker_queue_depth{topic="%s"}`, topic), func() float64 {
+		return float64(len(p.queue))
+	})
+	metrics.GetOrCreateGauge(fmt.Sprintf(`consumer_worker_busy{topic="%s"}`, topic), func() float64 {
+		return float64(p.busy.Load())
+	})
+	metrics.GetOrCreateGauge(fmt.Sprintf(`consumer_worker_utilization{topic="%s"}`, topic), func() float64 {
+		return float64(p.busy.Load()) / float64(p.workers)
+	})
+
+	return p
+}
+
+func (p *workerPool) start(ctx context.Context, handle func(broker.Delivery)) {
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for {
+				select {
+				case m := <-p.queue:
+					p.busy.Add(1)
+					handle(m)
+					p.busy.Add(-1)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (p *workerPool) submit(ctx context.Context, m broker.Delivery) error {
+	select {
+	case p.queue <- m:
+		return nil
+	default:
+	}
+
+	p.queueFullCounter.Inc()
+
+	select {
+	case p.queue <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
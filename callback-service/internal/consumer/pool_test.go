@@ -0,0 +1,59 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"callback-service/internal/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPool_ProcessesMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var processed []string
+
+	pool := newWorkerPool("pool-test-process", 4, 10)
+	pool.start(ctx, func(d broker.Delivery) {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, string(d.Message().Value))
+	})
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, pool.submit(ctx, newFakeDelivery("message")))
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(processed) == 20
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWorkerPool_BlocksWhenQueueIsFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	pool := newWorkerPool("pool-test-backpressure", 1, 1)
+	pool.start(ctx, func(broker.Delivery) {
+		<-release
+	})
+
+	// The first message occupies the worker, the second one fills the queue.
+	assert.NoError(t, pool.submit(ctx, newFakeDelivery("first")))
+	assert.Eventually(t, func() bool { return pool.busy.Load() == 1 }, time.Second, 10*time.Millisecond)
+	assert.NoError(t, pool.submit(ctx, newFakeDelivery("second")))
+
+	submitCtx, submitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer submitCancel()
+	assert.ErrorIs(t, pool.submit(submitCtx, newFakeDelivery("third")), context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, pool.submit(ctx, newFakeDelivery("third")))
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	OperationInsert = "insert"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// AuditEntity is a change of a callback message. Before and After are the row as JSON before and after the change,
+// Before is nil for inserts and After for deletes.
+type AuditEntity struct {
+	ID         int64
+	CallbackID uuid.UUID
+	Version    int64
+	Operation  string
+	Actor      string
+	Before     *string
+	After      *string
+	ChangedAt  time.Time
+}
+
+type actorKey struct{}
+
+// WithActor returns a context attributing the changes made with it to the actor, the component making them.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return "unknown"
+}
+
+// Audit returns the changes of the callback in the order they were made.
+func (r *CallbackRepository) Audit(ctx context.Context, callbackID uuid.UUID) ([]*AuditEntity, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, callback_id, version, operation, actor, before::text, after::text, changed_at
+	          FROM callback_message_audit
+	          WHERE callback_id = $1
+	          ORDER BY id`
+	rows, err := r.pool.Query(ctx, query, callbackID)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting callback message audit")
+	}
+	defer rows.Close()
+
+	var changes []*AuditEntity
+	for rows.Next() {
+		var change AuditEntity
+		if err := rows.Scan(&change.ID, &change.CallbackID, &change.Version, &change.Operation, &change.Actor, &change.Before,
+			&change.After, &change.ChangedAt); err != nil {
+			return nil, errors.Wrap(err, "scanning callback message audit")
+		}
+		changes = append(changes, &change)
+	}
+	return changes, rows.Err()
+}
+
+// PurgeAudit deletes up to limit of the oldest changes made before changedBefore and returns how many it deleted. The
+// audit is append-only, so it deletes them with purge_callback_message_audit, which the service's role must be
+// allowed to execute.
+func (r *CallbackRepository) PurgeAudit(ctx context.Context, changedBefore time.Time, limit int) (int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var purged int
+	if err := r.pool.QueryRow(ctx, `SELECT purge_callback_message_audit($1, $2)`, changedBefore, limit).Scan(&purged); err != nil {
+		return 0, errors.Wrap(err, "purging callback message audit")
+	}
+	return purged, nil
+}
+
+// auditRow encodes the callback like to_jsonb encodes its row.
+func auditRow(entity CallbackMessageEntity) *string {
+	row := map[string]any{
+		"id":                entity.ID,
+		"payment_id":        entity.PaymentID,
+		"payload":           json.RawMessage(entity.Payload),
+		"url":               entity.Url,
+		"created_at":        entity.CreatedAt,
+		"updated_at":        entity.UpdatedAt,
+		"scheduled_at":      entity.ScheduledAt,
+		"delivered_at":      entity.DeliveredAt,
+		"delivery_attempts": entity.DeliveryAttempts,
+		"publish_attempts":  entity.PublishAttempts,
+		"error":             entity.Error,
+		"published_at":      entity.PublishedAt,
+		"sequence":          entity.Sequence,
+		"superseded_by":     entity.SupersededBy,
+		"correlation_id":    entity.CorrelationID,
+		"trace_id":          entity.TraceID,
+		"version":           entity.Version,
+		"event_time":        entity.EventTime,
+	}
+	encoded, err := json.Marshal(row)
+	if err != nil {
+		// Only the payload can fail to encode, it is valid JSON by the time it is stored.
+		return nil
+	}
+	s := string(encoded)
+	return &s
+}
@@ -2,36 +2,82 @@ package db
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"log"
+	"strconv"
+	"time"
 
 	"callback-service/internal/config"
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/jackc/pgx/v5/pgxpool"
-	_ "github.com/lib/pq"
-	"github.com/pressly/goose/v3"
+	"github.com/pkg/errors"
 )
 
 func GetConnStr(cfg config.Database) string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name, cfg.SSLMode)
 }
 
-func RunMigrations(connStr, migrationsDir string) {
-	db, err := sql.Open("postgres", connStr)
+// GetPool creates the connection pool. Zero pool settings keep the pgxpool defaults.
+func GetPool(connStr string, cfg config.Database) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
-		log.Fatal(err)
+		return nil, errors.Wrap(err, "parsing connection string")
 	}
-	defer db.Close()
 
-	if err := goose.Up(db, migrationsDir); err != nil {
-		log.Fatal(err)
+	if cfg.Pool.MaxConns > 0 {
+		poolCfg.MaxConns = int32(cfg.Pool.MaxConns)
+	}
+	if cfg.Pool.MinConns > 0 {
+		poolCfg.MinConns = int32(cfg.Pool.MinConns)
+	}
+	if cfg.Pool.MaxConnLifetimeMs > 0 {
+		poolCfg.MaxConnLifetime = time.Duration(cfg.Pool.MaxConnLifetimeMs) * time.Millisecond
+	}
+	if cfg.Pool.MaxConnIdleTimeMs > 0 {
+		poolCfg.MaxConnIdleTime = time.Duration(cfg.Pool.MaxConnIdleTimeMs) * time.Millisecond
+	}
+	if cfg.Pool.HealthCheckPeriodMs > 0 {
+		poolCfg.HealthCheckPeriod = time.Duration(cfg.Pool.HealthCheckPeriodMs) * time.Millisecond
+	}
+	if cfg.StatementTimeoutMs > 0 {
+		poolCfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.Itoa(cfg.StatementTimeoutMs)
 	}
-}
 
-func GetPool(connStr string) (*pgxpool.Pool, error) {
-	dbpool, err := pgxpool.New(context.Background(), connStr)
+	dbpool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
 	if err != nil {
 		return nil, err
 	}
 	return dbpool, nil
 }
+
+// ServerTime returns a function reading the current time of the database server, the clock all instances agree on.
+func ServerTime(pool *pgxpool.Pool) func(ctx context.Context) (time.Time, error) {
+	return func(ctx context.Context) (time.Time, error) {
+		var now time.Time
+		if err := pool.QueryRow(ctx, `SELECT clock_timestamp()`).Scan(&now); err != nil {
+			return time.Time{}, errors.Wrap(err, "reading database time")
+		}
+		return now, nil
+	}
+}
+
+// ExportPoolStats exports the statistics of the pool as metrics, read whenever metrics are collected.
+func ExportPoolStats(pool *pgxpool.Pool) {
+	stat := func(f func(s *pgxpool.Stat) float64) func() float64 {
+		return func() float64 { return f(pool.Stat()) }
+	}
+
+	metrics.GetOrCreateGauge(`db_pool_connections{state="total"}`, stat(func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }))
+	metrics.GetOrCreateGauge(`db_pool_connections{state="acquired"}`, stat(func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }))
+	metrics.GetOrCreateGauge(`db_pool_connections{state="idle"}`, stat(func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }))
+	metrics.GetOrCreateGauge(`db_pool_connections{state="constructing"}`, stat(func(s *pgxpool.Stat) float64 { return float64(s.ConstructingConns()) }))
+	metrics.GetOrCreateGauge(`db_pool_max_connections`, stat(func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }))
+
+	// Cumulative since the pool was created.
+	metrics.GetOrCreateGauge(`db_pool_acquires_total`, stat(func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }))
+	metrics.GetOrCreateGauge(`db_pool_empty_acquires_total`, stat(func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }))
+	metrics.GetOrCreateGauge(`db_pool_canceled_acquires_total`, stat(func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }))
+	metrics.GetOrCreateGauge(`db_pool_acquire_duration_seconds_total`, stat(func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }))
+	metrics.GetOrCreateGauge(`db_pool_new_connections_total`, stat(func(s *pgxpool.Stat) float64 { return float64(s.NewConnsCount()) }))
+	metrics.GetOrCreateGauge(`db_pool_destroyed_connections_total{reason="max_lifetime"}`, stat(func(s *pgxpool.Stat) float64 { return float64(s.MaxLifetimeDestroyCount()) }))
+	metrics.GetOrCreateGauge(`db_pool_destroyed_connections_total{reason="max_idle_time"}`, stat(func(s *pgxpool.Stat) float64 { return float64(s.MaxIdleDestroyCount()) }))
+}
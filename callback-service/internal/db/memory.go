@@ -0,0 +1,672 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"callback-service/internal/clock"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// MemoryRepository is an in-memory Repository with the semantics of CallbackRepository: a transaction sees its own
+// changes, other transactions see them once it commits, and callbacks are locked like the SQL queries lock their rows.
+// Locking a callback locked by another transaction waits for that transaction to end, except for the queries claiming
+// callbacks, which skip locked callbacks like FOR UPDATE SKIP LOCKED. Waiting for a lock does not observe the
+// context. Callbacks are lost on restart, the repository is meant for tests and single instance development.
+type MemoryRepository struct {
+	mu        sync.Mutex
+	unlocked  *sync.Cond
+	callbacks map[uuid.UUID]CallbackMessageEntity
+	sequences map[uuid.UUID]int64
+	locks     map[memoryLock]*memoryTx
+	audit     []AuditEntity
+	auditSeq  int64
+	clock     clock.Clock
+
+	sequenceInPayload bool
+}
+
+// memoryLock identifies a locked row: a callback, the key claimed to insert a callback, or a payment's sequence.
+type memoryLock struct {
+	table string
+	id    uuid.UUID
+}
+
+type memoryTx struct {
+	writes    map[uuid.UUID]CallbackMessageEntity
+	deletes   map[uuid.UUID]bool
+	sequences map[uuid.UUID]int64
+	audit     []AuditEntity
+	closed    bool
+}
+
+var _ Repository = (*MemoryRepository)(nil)
+
+func NewMemoryRepository(clk clock.Clock, sequenceInPayload bool) *MemoryRepository {
+	r := &MemoryRepository{
+		clock:             clk,
+		callbacks:         make(map[uuid.UUID]CallbackMessageEntity),
+		sequences:         make(map[uuid.UUID]int64),
+		locks:             make(map[memoryLock]*memoryTx),
+		sequenceInPayload: sequenceInPayload,
+	}
+	r.unlocked = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *MemoryRepository) Now() time.Time {
+	return r.clock.Now()
+}
+
+func (r *MemoryRepository) BeginTx(context.Context) (Tx, error) {
+	return &memoryTransaction{repo: r, tx: &memoryTx{
+		writes:    make(map[uuid.UUID]CallbackMessageEntity),
+		deletes:   make(map[uuid.UUID]bool),
+		sequences: make(map[uuid.UUID]int64),
+	}}, nil
+}
+
+// memoryTransaction is the Tx handed out by MemoryRepository, it guards the transaction state with the repository
+// mutex.
+type memoryTransaction struct {
+	repo *MemoryRepository
+	tx   *memoryTx
+}
+
+func (t *memoryTransaction) Commit(context.Context) error {
+	r := t.repo
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.tx.closed {
+		return pgx.ErrTxClosed
+	}
+	for id := range t.tx.deletes {
+		delete(r.callbacks, id)
+	}
+	for id, entity := range t.tx.writes {
+		r.callbacks[id] = entity
+	}
+	for paymentID, sequence := range t.tx.sequences {
+		r.sequences[paymentID] = sequence
+	}
+	for _, change := range t.tx.audit {
+		r.auditSeq++
+		change.ID = r.auditSeq
+		r.audit = append(r.audit, change)
+	}
+	r.release(t.tx)
+	return nil
+}
+
+func (t *memoryTransaction) Rollback(context.Context) error {
+	r := t.repo
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.tx.closed {
+		return pgx.ErrTxClosed
+	}
+	r.release(t.tx)
+	return nil
+}
+
+// release ends the transaction and wakes up transactions waiting for its locks.
+func (r *MemoryRepository) release(tx *memoryTx) {
+	tx.closed = true
+	for lock, holder := range r.locks {
+		if holder == tx {
+			delete(r.locks, lock)
+		}
+	}
+	r.unlocked.Broadcast()
+}
+
+// memoryTxOf returns the state of a Tx begun by the repository.
+func (r *MemoryRepository) memoryTxOf(tx Tx) (*memoryTx, error) {
+	t := tx.(*memoryTransaction)
+	if t.repo != r {
+		return nil, errors.New("transaction of another repository")
+	}
+	if t.tx.closed {
+		return nil, pgx.ErrTxClosed
+	}
+	return t.tx, nil
+}
+
+// lock locks the row for the transaction. A row locked by another transaction is skipped if skipLocked is set,
+// otherwise lock waits until it is released. It reports whether the row is locked by the transaction.
+func (r *MemoryRepository) lock(tx *memoryTx, lock memoryLock, skipLocked bool) bool {
+	for {
+		holder, ok := r.locks[lock]
+		if !ok || holder == tx {
+			r.locks[lock] = tx
+			return true
+		}
+		if skipLocked {
+			return false
+		}
+		r.unlocked.Wait()
+	}
+}
+
+func callbackLock(id uuid.UUID) memoryLock {
+	return memoryLock{table: "callback_message", id: id}
+}
+
+// get returns the callback as the transaction sees it. Without a transaction only committed callbacks are seen.
+func (r *MemoryRepository) get(tx *memoryTx, id uuid.UUID) (CallbackMessageEntity, bool) {
+	if tx != nil {
+		if tx.deletes[id] {
+			return CallbackMessageEntity{}, false
+		}
+		if entity, ok := tx.writes[id]; ok {
+			return entity, true
+		}
+	}
+	entity, ok := r.callbacks[id]
+	return entity, ok
+}
+
+// visible returns the callbacks the transaction sees matching the filter, ordered by creation.
+func (r *MemoryRepository) visible(tx *memoryTx, filter func(CallbackMessageEntity) bool) []CallbackMessageEntity {
+	var entities []CallbackMessageEntity
+	for id := range r.callbacks {
+		if _, written := tx.writes[id]; written {
+			continue
+		}
+		if entity, ok := r.get(tx, id); ok && filter(entity) {
+			entities = append(entities, entity)
+		}
+	}
+	for _, entity := range tx.writes {
+		if filter(entity) {
+			entities = append(entities, entity)
+		}
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		if !entities[i].CreatedAt.Equal(entities[j].CreatedAt) {
+			return entities[i].CreatedAt.Before(entities[j].CreatedAt)
+		}
+		return entities[i].ID.String() < entities[j].ID.String()
+	})
+	return entities
+}
+
+// claim locks up to limit of the callbacks, skipping callbacks locked by other transactions.
+func (r *MemoryRepository) claim(tx *memoryTx, entities []CallbackMessageEntity, limit int) []CallbackMessageEntity {
+	var claimed []CallbackMessageEntity
+	for _, entity := range entities {
+		if len(claimed) == limit {
+			break
+		}
+		if r.lock(tx, callbackLock(entity.ID), true) {
+			claimed = append(claimed, entity)
+		}
+	}
+	return claimed
+}
+
+func (r *MemoryRepository) Create(ctx context.Context, entity *CallbackMessageEntity) (*CallbackMessageEntity, error) {
+	tx, err := r.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := r.CreateTx(ctx, tx, entity); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+func (r *MemoryRepository) CreateTx(ctx context.Context, tx Tx, entity *CallbackMessageEntity) (*CallbackMessageEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.create(mtx, entity, r.clock.Now(), actorFrom(ctx)); err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+func (r *MemoryRepository) CreateBatch(ctx context.Context, tx Tx, entities []*CallbackMessageEntity) ([]error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	errs := make([]error, len(entities))
+	for i, entity := range entities {
+		err := r.create(mtx, entity, now, actorFrom(ctx))
+		if errors.Is(err, ErrDuplicate) {
+			errs[i] = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return errs, nil
+}
+
+// create inserts the callback like createQuery: it claims the key of the callback, waiting for a transaction
+// inserting the same ID, and takes the next sequence of the payment, waiting for transactions inserting callbacks of
+// the same payment.
+func (r *MemoryRepository) create(tx *memoryTx, entity *CallbackMessageEntity, now time.Time, actor string) error {
+	r.lock(tx, memoryLock{table: "callback_message_key", id: entity.ID}, false)
+	if _, exists := r.get(tx, entity.ID); exists {
+		return ErrDuplicate
+	}
+	if _, committed := r.callbacks[entity.ID]; committed {
+		// Deleted by the transaction, the key is only released on commit.
+		return ErrDuplicate
+	}
+
+	r.lock(tx, memoryLock{table: "callback_payment_sequence", id: entity.PaymentID}, false)
+	sequence, ok := tx.sequences[entity.PaymentID]
+	if !ok {
+		sequence = r.sequences[entity.PaymentID]
+	}
+	sequence++
+
+	payload := entity.Payload
+	if r.sequenceInPayload {
+		var err error
+		if payload, err = withSequence(payload, sequence); err != nil {
+			return errors.Wrap(err, "inserting callback message")
+		}
+	}
+
+	tx.sequences[entity.PaymentID] = sequence
+	created := CallbackMessageEntity{
+		ID:               entity.ID,
+		PaymentID:        entity.PaymentID,
+		Url:              entity.Url,
+		Payload:          payload,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		ScheduledAt:      entity.ScheduledAt,
+		DeliveryAttempts: entity.DeliveryAttempts,
+		PublishAttempts:  entity.PublishAttempts,
+		Sequence:         sequence,
+		EventTime:        entity.EventTime,
+		CorrelationID:    entity.CorrelationID,
+		TraceID:          entity.TraceID,
+	}
+	tx.writes[entity.ID] = created
+	tx.record(OperationInsert, actor, nil, &created, now)
+
+	entity.Payload = payload
+	entity.Sequence = sequence
+	entity.CreatedAt = now
+	return nil
+}
+
+// record adds the change of a callback to the audit of the transaction.
+func (tx *memoryTx) record(operation, actor string, before, after *CallbackMessageEntity, now time.Time) {
+	change := AuditEntity{Operation: operation, Actor: actor, ChangedAt: now}
+	if before != nil {
+		change.CallbackID = before.ID
+		change.Version = before.Version
+		change.Before = auditRow(*before)
+	}
+	if after != nil {
+		change.CallbackID = after.ID
+		change.Version = after.Version
+		change.After = auditRow(*after)
+	}
+	tx.audit = append(tx.audit, change)
+}
+
+// withSequence adds the sequence to the JSON object payload, like jsonb_set.
+func withSequence(payload string, sequence int64) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return "", errors.Wrap(err, "decoding payload")
+	}
+	fields["sequence"] = json.RawMessage(strconv.FormatInt(sequence, 10))
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding payload")
+	}
+	return string(encoded), nil
+}
+
+func (r *MemoryRepository) GetUnprocessedCallbacks(_ context.Context, tx Tx, limit int) ([]*CallbackMessageEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	candidates := r.visible(mtx, func(e CallbackMessageEntity) bool {
+		return e.ScheduledAt != nil && !e.ScheduledAt.After(now)
+	})
+	return unprocessed(r.claim(mtx, candidates, limit)), nil
+}
+
+func (r *MemoryRepository) GetUnprocessedOrderedCallbacks(_ context.Context, tx Tx, limit int) ([]*CallbackMessageEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	inFlight := r.visible(mtx, func(e CallbackMessageEntity) bool {
+		return e.DeliveredAt == nil && (e.ScheduledAt != nil || e.PublishedAt != nil)
+	})
+	now := r.clock.Now()
+	candidates := r.visible(mtx, func(e CallbackMessageEntity) bool {
+		if e.ScheduledAt == nil || e.ScheduledAt.After(now) {
+			return false
+		}
+		for _, earlier := range inFlight {
+			if earlier.PaymentID == e.PaymentID && earlier.Sequence < e.Sequence {
+				return false
+			}
+		}
+		return true
+	})
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].ScheduledAt.Before(*candidates[j].ScheduledAt)
+	})
+	return unprocessed(r.claim(mtx, candidates, limit)), nil
+}
+
+// unprocessed returns the columns the unprocessed callback queries select.
+func unprocessed(entities []CallbackMessageEntity) []*CallbackMessageEntity {
+	callbacks := make([]*CallbackMessageEntity, len(entities))
+	for i, e := range entities {
+		callbacks[i] = &CallbackMessageEntity{
+			ID:               e.ID,
+			PaymentID:        e.PaymentID,
+			Payload:          e.Payload,
+			Url:              e.Url,
+			DeliveryAttempts: e.DeliveryAttempts,
+			PublishAttempts:  e.PublishAttempts,
+			CorrelationID:    e.CorrelationID,
+			TraceID:          e.TraceID,
+			CreatedAt:        e.CreatedAt,
+			Version:          e.Version,
+		}
+	}
+	return callbacks
+}
+
+func (r *MemoryRepository) GetStaleCallbacks(_ context.Context, tx Tx, publishedBefore time.Time, limit int) ([]*CallbackMessageEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := r.visible(mtx, func(e CallbackMessageEntity) bool {
+		return e.PublishedAt != nil && !e.PublishedAt.After(publishedBefore) && e.DeliveredAt == nil
+	})
+	return pointers(r.claim(mtx, candidates, limit)), nil
+}
+
+func (r *MemoryRepository) DeleteDelivered(ctx context.Context, tx Tx, deliveredBefore time.Time, limit int) ([]*CallbackMessageEntity, error) {
+	return r.delete(ctx, tx, limit, func(e CallbackMessageEntity) bool {
+		return e.DeliveredAt != nil && e.DeliveredAt.Before(deliveredBefore)
+	})
+}
+
+func (r *MemoryRepository) DeleteFailed(ctx context.Context, tx Tx, updatedBefore time.Time, limit int) ([]*CallbackMessageEntity, error) {
+	return r.delete(ctx, tx, limit, func(e CallbackMessageEntity) bool {
+		return e.DeliveredAt == nil && e.ScheduledAt == nil && e.PublishedAt == nil && e.UpdatedAt.Before(updatedBefore)
+	})
+}
+
+func (r *MemoryRepository) delete(ctx context.Context, tx Tx, limit int, filter func(CallbackMessageEntity) bool) ([]*CallbackMessageEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.clock.Now()
+	deleted := r.claim(mtx, r.visible(mtx, filter), limit)
+	for _, entity := range deleted {
+		delete(mtx.writes, entity.ID)
+		mtx.deletes[entity.ID] = true
+		mtx.record(OperationDelete, actorFrom(ctx), &entity, nil, now)
+	}
+	return pointers(deleted), nil
+}
+
+func (r *MemoryRepository) Supersede(ctx context.Context, tx Tx, entity *CallbackMessageEntity, includeInFlight bool) ([]uuid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *CallbackMessageEntity
+	for _, candidate := range r.visible(mtx, func(e CallbackMessageEntity) bool {
+		return e.PaymentID == entity.PaymentID && laterState(e, *entity)
+	}) {
+		if latest == nil || laterState(candidate, *latest) {
+			latest = &candidate
+		}
+	}
+	if latest != nil {
+		// The entity may have been superseded already by a callback created in the same transaction.
+		r.lock(mtx, callbackLock(entity.ID), false)
+		current, ok := r.get(mtx, entity.ID)
+		if !ok {
+			return nil, errors.Wrap(pgx.ErrNoRows, "selecting callback message for update")
+		}
+		*entity = current
+		if entity.SupersededBy != nil {
+			return nil, nil
+		}
+		markSuperseded(entity, latest.ID)
+		return nil, r.updateLocked(ctx, mtx, entity, supersessionColumns)
+	}
+
+	supersedable := func(e CallbackMessageEntity) bool {
+		return e.PaymentID == entity.PaymentID && laterState(*entity, e) && e.DeliveredAt == nil && e.SupersededBy == nil &&
+			(e.ScheduledAt != nil || (includeInFlight && e.PublishedAt != nil))
+	}
+
+	now := r.clock.Now()
+	var (
+		ids      []uuid.UUID
+		earliest *time.Time
+	)
+	for _, candidate := range r.visible(mtx, supersedable) {
+		// Like UPDATE, wait for the lock and check the callback again, it may have changed meanwhile.
+		r.lock(mtx, callbackLock(candidate.ID), false)
+		current, ok := r.get(mtx, candidate.ID)
+		if !ok || !supersedable(current) {
+			continue
+		}
+		if current.ScheduledAt != nil && (earliest == nil || current.ScheduledAt.Before(*earliest)) {
+			earliest = current.ScheduledAt
+		}
+
+		before := current
+		markSuperseded(&current, entity.ID)
+		current.UpdatedAt = now
+		current.Version++
+		mtx.writes[current.ID] = current
+		mtx.record(OperationUpdate, actorFrom(ctx), &before, &current, now)
+		ids = append(ids, current.ID)
+	}
+
+	if earliest != nil && entity.ScheduledAt != nil && earliest.Before(*entity.ScheduledAt) {
+		entity.ScheduledAt = earliest
+		if err := r.updateLocked(ctx, mtx, entity, []string{"scheduled_at"}); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// laterState reports whether the callback a reports a later payment state than b, like comparing
+// (event_time, sequence) in SQL.
+func laterState(a, b CallbackMessageEntity) bool {
+	if !a.EventTime.Equal(b.EventTime) {
+		return a.EventTime.After(b.EventTime)
+	}
+	return a.Sequence > b.Sequence
+}
+
+func (r *MemoryRepository) UpdatePublication(ctx context.Context, tx Tx, entity *CallbackMessageEntity) error {
+	return r.update(ctx, tx, entity, publicationColumns)
+}
+
+func (r *MemoryRepository) UpdateDelivery(ctx context.Context, tx Tx, entity *CallbackMessageEntity) error {
+	return r.update(ctx, tx, entity, deliveryColumns)
+}
+
+func (r *MemoryRepository) update(ctx context.Context, tx Tx, entity *CallbackMessageEntity, columns []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return err
+	}
+	return r.updateLocked(ctx, mtx, entity, columns)
+}
+
+// updateLocked works like update, the caller holds the mutex.
+func (r *MemoryRepository) updateLocked(ctx context.Context, mtx *memoryTx, entity *CallbackMessageEntity, columns []string) error {
+	r.lock(mtx, callbackLock(entity.ID), false)
+	current, ok := r.get(mtx, entity.ID)
+	if !ok || !current.CreatedAt.Equal(entity.CreatedAt) || current.Version != entity.Version {
+		return ErrConflict
+	}
+
+	before := current
+	for _, column := range columns {
+		setColumn(&current, entity, column)
+	}
+	current.UpdatedAt = r.clock.Now()
+	current.Version++
+	mtx.writes[current.ID] = current
+	mtx.record(OperationUpdate, actorFrom(ctx), &before, &current, current.UpdatedAt)
+
+	entity.Version = current.Version
+	entity.UpdatedAt = current.UpdatedAt
+	return nil
+}
+
+// setColumn copies the column from the entity to the stored callback.
+func setColumn(stored, entity *CallbackMessageEntity, column string) {
+	switch column {
+	case "publish_attempts":
+		stored.PublishAttempts = entity.PublishAttempts
+	case "delivery_attempts":
+		stored.DeliveryAttempts = entity.DeliveryAttempts
+	case "scheduled_at":
+		stored.ScheduledAt = entity.ScheduledAt
+	case "published_at":
+		stored.PublishedAt = entity.PublishedAt
+	case "delivered_at":
+		stored.DeliveredAt = entity.DeliveredAt
+	case "error":
+		stored.Error = entity.Error
+	case "superseded_by":
+		stored.SupersededBy = entity.SupersededBy
+	default:
+		panic("unknown callback message column " + column)
+	}
+}
+
+func (r *MemoryRepository) SelectForUpdateByID(_ context.Context, tx Tx, id uuid.UUID) (*CallbackMessageEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mtx, err := r.memoryTxOf(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock(mtx, callbackLock(id), false)
+	entity, ok := r.get(mtx, id)
+	if !ok {
+		return nil, errors.Wrap(pgx.ErrNoRows, "selecting callback message for update")
+	}
+	return &entity, nil
+}
+
+func (r *MemoryRepository) SelectByID(_ context.Context, id uuid.UUID) (*CallbackMessageEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entity, ok := r.get(nil, id)
+	if !ok {
+		return nil, errors.Wrap(pgx.ErrNoRows, "selecting callback message")
+	}
+	return &entity, nil
+}
+
+func (r *MemoryRepository) Audit(_ context.Context, callbackID uuid.UUID) ([]*AuditEntity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var changes []*AuditEntity
+	for _, change := range r.audit {
+		if change.CallbackID == callbackID {
+			changes = append(changes, &change)
+		}
+	}
+	return changes, nil
+}
+
+func (r *MemoryRepository) PurgeAudit(_ context.Context, changedBefore time.Time, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.audit[:0]
+	purged := 0
+	for _, change := range r.audit {
+		if purged < limit && change.ChangedAt.Before(changedBefore) {
+			purged++
+			continue
+		}
+		kept = append(kept, change)
+	}
+	r.audit = kept
+	return purged, nil
+}
+
+func pointers(entities []CallbackMessageEntity) []*CallbackMessageEntity {
+	callbacks := make([]*CallbackMessageEntity, len(entities))
+	for i := range entities {
+		callbacks[i] = &entities[i]
+	}
+	return callbacks
+}
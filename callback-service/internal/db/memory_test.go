@@ -0,0 +1,393 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"callback-service/internal/clock"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCallback(paymentID uuid.UUID) *CallbackMessageEntity {
+	scheduledAt := time.Now().Add(-time.Second)
+	return &CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Payload:     `{"status":"successful"}`,
+		Url:         "https://merchant.example.com/callback",
+		ScheduledAt: &scheduledAt,
+	}
+}
+
+func TestMemoryRepository_Create(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, true)
+	paymentID := uuid.New()
+
+	first, err := repo.Create(ctx, newCallback(paymentID))
+	require.NoError(t, err)
+	second, err := repo.Create(ctx, newCallback(paymentID))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), first.Sequence)
+	assert.Equal(t, int64(2), second.Sequence)
+	assert.False(t, second.CreatedAt.IsZero())
+
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal([]byte(second.Payload), &fields))
+	assert.Equal(t, map[string]any{"status": "successful", "sequence": float64(2)}, fields)
+
+	_, err = repo.Create(ctx, &CallbackMessageEntity{ID: first.ID, PaymentID: paymentID, Payload: `{}`})
+	assert.ErrorIs(t, err, ErrDuplicate)
+
+	stored, err := repo.SelectByID(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, second.Payload, stored.Payload)
+	assert.Equal(t, int64(2), stored.Sequence)
+}
+
+func TestMemoryRepository_Create_PayloadWithoutSequence(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+
+	created, err := repo.Create(ctx, newCallback(uuid.New()))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), created.Sequence)
+	assert.JSONEq(t, `{"status":"successful"}`, created.Payload)
+}
+
+func TestMemoryRepository_CreateBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+	existing, err := repo.Create(ctx, newCallback(uuid.New()))
+	require.NoError(t, err)
+
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+
+	created := newCallback(uuid.New())
+	errs, err := repo.CreateBatch(ctx, tx, []*CallbackMessageEntity{created, {ID: existing.ID, PaymentID: existing.PaymentID, Payload: `{}`}})
+	require.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], ErrDuplicate)
+	require.NoError(t, tx.Commit(ctx))
+
+	_, err = repo.SelectByID(ctx, created.ID)
+	assert.NoError(t, err)
+}
+
+func TestMemoryRepository_Rollback(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+	paymentID := uuid.New()
+
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	entity := newCallback(paymentID)
+	_, err = repo.CreateTx(ctx, tx, entity)
+	require.NoError(t, err)
+
+	_, err = repo.SelectByID(ctx, entity.ID)
+	assert.ErrorIs(t, err, pgx.ErrNoRows, "uncommitted callbacks are not visible outside the transaction")
+
+	require.NoError(t, tx.Rollback(ctx))
+	assert.ErrorIs(t, tx.Commit(ctx), pgx.ErrTxClosed)
+
+	_, err = repo.SelectByID(ctx, entity.ID)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+	// The sequence taken by the rolled back transaction is taken again.
+	created, err := repo.Create(ctx, newCallback(paymentID))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), created.Sequence)
+}
+
+func TestMemoryRepository_GetUnprocessedCallbacks_SkipsLocked(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+	for range 3 {
+		_, err := repo.Create(ctx, newCallback(uuid.New()))
+		require.NoError(t, err)
+	}
+	future := newCallback(uuid.New())
+	future.ScheduledAt = ptr(time.Now().Add(time.Hour))
+	_, err := repo.Create(ctx, future)
+	require.NoError(t, err)
+
+	tx1, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx1.Rollback(ctx)
+	tx2, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx2.Rollback(ctx)
+
+	claimed1, err := repo.GetUnprocessedCallbacks(ctx, tx1, 2)
+	require.NoError(t, err)
+	claimed2, err := repo.GetUnprocessedCallbacks(ctx, tx2, 2)
+	require.NoError(t, err)
+
+	assert.Len(t, claimed1, 2)
+	assert.Len(t, claimed2, 1)
+	for _, c := range claimed2 {
+		assert.NotContains(t, ids(claimed1), c.ID)
+		assert.NotEqual(t, future.ID, c.ID)
+	}
+
+	require.NoError(t, tx1.Rollback(ctx))
+	claimed2, err = repo.GetUnprocessedCallbacks(ctx, tx2, 10)
+	require.NoError(t, err)
+	assert.Len(t, claimed2, 3, "callbacks are claimed again once the claiming transaction ends")
+}
+
+func TestMemoryRepository_GetUnprocessedCallbacks_UsesRepositoryClock(t *testing.T) {
+	ctx := context.Background()
+	ahead := clock.NewSynced(func(context.Context) (time.Time, error) {
+		return time.Now().Add(time.Hour), nil
+	}, 1000, slog.Default())
+	require.NoError(t, ahead.Sync(ctx))
+	repo := NewMemoryRepository(ahead, false)
+
+	// Not due by the host clock, but by the repository's.
+	entity := newCallback(uuid.New())
+	scheduledAt := time.Now().Add(30 * time.Minute)
+	entity.ScheduledAt = &scheduledAt
+	_, err := repo.Create(ctx, entity)
+	require.NoError(t, err)
+
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+
+	claimed, err := repo.GetUnprocessedCallbacks(ctx, tx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{entity.ID}, ids(claimed))
+	assert.WithinDuration(t, ahead.Now(), claimed[0].CreatedAt, time.Second)
+}
+
+func TestMemoryRepository_GetUnprocessedOrderedCallbacks(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+	paymentID := uuid.New()
+
+	first, err := repo.Create(ctx, newCallback(paymentID))
+	require.NoError(t, err)
+	_, err = repo.Create(ctx, newCallback(paymentID))
+	require.NoError(t, err)
+
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+
+	claimed, err := repo.GetUnprocessedOrderedCallbacks(ctx, tx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{first.ID}, ids(claimed), "later callbacks of a payment wait for the earlier ones")
+
+	now := time.Now()
+	stored, err := repo.SelectForUpdateByID(ctx, tx, first.ID)
+	require.NoError(t, err)
+	stored.ScheduledAt = nil
+	stored.DeliveredAt = &now
+	require.NoError(t, repo.UpdateDelivery(ctx, tx, stored))
+
+	claimed, err = repo.GetUnprocessedOrderedCallbacks(ctx, tx, 10)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 1)
+	assert.NotEqual(t, first.ID, claimed[0].ID)
+}
+
+func TestMemoryRepository_Supersede(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+	paymentID := uuid.New()
+
+	scheduled, err := repo.Create(ctx, newCallback(paymentID))
+	require.NoError(t, err)
+	published, err := repo.Create(ctx, newCallback(paymentID))
+	require.NoError(t, err)
+	published.ScheduledAt = nil
+	published.PublishedAt = ptr(time.Now())
+	update(t, repo, published)
+
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+
+	latest := newCallback(paymentID)
+	_, err = repo.CreateTx(ctx, tx, latest)
+	require.NoError(t, err)
+
+	superseded, err := repo.Supersede(ctx, tx, latest, false)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{scheduled.ID}, superseded)
+
+	superseded, err = repo.Supersede(ctx, tx, latest, true)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{published.ID}, superseded)
+	require.NoError(t, tx.Commit(ctx))
+
+	stored, err := repo.SelectByID(ctx, scheduled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &latest.ID, stored.SupersededBy)
+	assert.Nil(t, stored.ScheduledAt)
+	assert.Equal(t, "Superseded by "+latest.ID.String(), *stored.Error)
+}
+
+func TestMemoryRepository_SelectForUpdateByID_Waits(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+	entity, err := repo.Create(ctx, newCallback(uuid.New()))
+	require.NoError(t, err)
+
+	tx1, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	locked, err := repo.SelectForUpdateByID(ctx, tx1, entity.ID)
+	require.NoError(t, err)
+
+	selected := make(chan *CallbackMessageEntity)
+	go func() {
+		tx2, err := repo.BeginTx(ctx)
+		if err != nil {
+			close(selected)
+			return
+		}
+		defer tx2.Rollback(ctx)
+		stored, _ := repo.SelectForUpdateByID(ctx, tx2, entity.ID)
+		selected <- stored
+	}()
+
+	select {
+	case <-selected:
+		t.Fatal("selected a callback locked by another transaction")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	locked.DeliveryAttempts = 1
+	require.NoError(t, repo.UpdateDelivery(ctx, tx1, locked))
+	require.NoError(t, tx1.Commit(ctx))
+
+	select {
+	case stored := <-selected:
+		require.NotNil(t, stored)
+		assert.Equal(t, 1, stored.DeliveryAttempts)
+	case <-time.After(time.Second):
+		t.Fatal("callback not selected after the lock was released")
+	}
+}
+
+func TestMemoryRepository_Update(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+	entity, err := repo.Create(WithActor(ctx, "event.processor"), newCallback(uuid.New()))
+	require.NoError(t, err)
+	stale, err := repo.SelectByID(ctx, entity.ID)
+	require.NoError(t, err)
+
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	entity.Url = "https://merchant.example.com/changed"
+	entity.DeliveryAttempts = 3
+	entity.PublishAttempts = 1
+	entity.PublishedAt = ptr(time.Now())
+	require.NoError(t, repo.UpdatePublication(WithActor(ctx, "callback.producer"), tx, entity))
+	assert.Equal(t, int64(1), entity.Version)
+
+	stale.DeliveryAttempts = 1
+	assert.ErrorIs(t, repo.UpdateDelivery(ctx, tx, stale), ErrConflict)
+	require.NoError(t, tx.Commit(ctx))
+
+	stored, err := repo.SelectByID(ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://merchant.example.com/callback", stored.Url, "only the publication columns are set")
+	assert.Equal(t, 0, stored.DeliveryAttempts)
+	assert.Equal(t, 1, stored.PublishAttempts)
+	assert.Equal(t, int64(1), stored.Version)
+
+	changes, err := repo.Audit(ctx, entity.ID)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, OperationInsert, changes[0].Operation)
+	assert.Equal(t, "event.processor", changes[0].Actor)
+	assert.Nil(t, changes[0].Before)
+	assert.Equal(t, OperationUpdate, changes[1].Operation)
+	assert.Equal(t, "callback.producer", changes[1].Actor)
+	assert.Equal(t, int64(1), changes[1].Version)
+	assert.Contains(t, *changes[1].Before, `"publish_attempts":0`)
+	assert.Contains(t, *changes[1].After, `"publish_attempts":1`)
+}
+
+func TestMemoryRepository_DeleteDelivered(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+
+	delivered, err := repo.Create(ctx, newCallback(uuid.New()))
+	require.NoError(t, err)
+	delivered.ScheduledAt = nil
+	delivered.DeliveredAt = ptr(time.Now().Add(-time.Hour))
+	update(t, repo, delivered)
+
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	deleted, err := repo.DeleteDelivered(ctx, tx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{delivered.ID}, ids(deleted))
+	require.NoError(t, tx.Commit(ctx))
+
+	_, err = repo.SelectByID(ctx, delivered.ID)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
+
+func TestMemoryRepository_PurgeAudit(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(clock.System, false)
+
+	first, err := repo.Create(ctx, newCallback(uuid.New()))
+	require.NoError(t, err)
+	second, err := repo.Create(ctx, newCallback(uuid.New()))
+	require.NoError(t, err)
+
+	purged, err := repo.PurgeAudit(ctx, time.Now().Add(time.Minute), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	changes, err := repo.Audit(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Empty(t, changes, "the oldest changes are purged first")
+	changes, err = repo.Audit(ctx, second.ID)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+
+	third, err := repo.Create(ctx, newCallback(uuid.New()))
+	require.NoError(t, err)
+	latest, err := repo.Audit(ctx, third.ID)
+	require.NoError(t, err)
+	require.Len(t, latest, 1)
+	assert.Greater(t, latest[0].ID, changes[0].ID, "IDs are not reused after a purge")
+}
+
+func update(t *testing.T, repo *MemoryRepository, entity *CallbackMessageEntity) {
+	ctx := context.Background()
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateDelivery(ctx, tx, entity))
+	require.NoError(t, tx.Commit(ctx))
+}
+
+func ids(entities []*CallbackMessageEntity) []uuid.UUID {
+	ids := make([]uuid.UUID, len(entities))
+	for i, e := range entities {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
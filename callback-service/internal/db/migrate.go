@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"callback-service/internal/config"
+	"callback-service/migrations"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// migrationLockID is the advisory lock held while migrating, so concurrently starting instances migrate one after
+// the other; the later ones find nothing left to apply.
+const migrationLockID = 7_305_002
+
+// Migrator applies the migrations embedded in the binary.
+type Migrator struct {
+	db       *sql.DB
+	provider *goose.Provider
+	logger   *slog.Logger
+}
+
+func NewMigrator(connStr string, cfg config.DatabaseMigrations, logger *slog.Logger) (*Migrator, error) {
+	lockOpts := []lock.SessionLockerOption{lock.WithLockID(migrationLockID)}
+	if cfg.LockTimeoutMs > 0 {
+		// The lock is tried once a second.
+		lockOpts = append(lockOpts, lock.WithLockTimeout(1, uint64(max((cfg.LockTimeoutMs+999)/1000, 1))))
+	}
+	locker, err := lock.NewPostgresSessionLocker(lockOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating migration lock")
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrations.FS, goose.WithSessionLocker(locker))
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "loading migrations")
+	}
+
+	return &Migrator{db: db, provider: provider, logger: logger.With("component", "db.migrator")}, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	results, err := m.provider.Up(ctx)
+	m.logResults(ctx, results)
+	return errors.Wrap(err, "applying migrations")
+}
+
+// Down rolls back the latest applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	result, err := m.provider.Down(ctx)
+	if result != nil {
+		m.logResults(ctx, []*goose.MigrationResult{result})
+	}
+	return errors.Wrap(err, "rolling back migration")
+}
+
+// Status lists all migrations, applied or pending, ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]*goose.MigrationStatus, error) {
+	statuses, err := m.provider.Status(ctx)
+	return statuses, errors.Wrap(err, "reading migration status")
+}
+
+// Version returns the version of the latest applied migration, 0 if none is applied.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	version, err := m.provider.GetDBVersion(ctx)
+	return version, errors.Wrap(err, "reading migration version")
+}
+
+func (m *Migrator) Close() error {
+	return m.provider.Close()
+}
+
+func (m *Migrator) logResults(ctx context.Context, results []*goose.MigrationResult) {
+	for _, r := range results {
+		if r.Error != nil {
+			m.logger.ErrorContext(ctx, fmt.Sprintf("Migration %s %s failed: %v", r.Source.Path, r.Direction, r.Error))
+			continue
+		}
+		m.logger.InfoContext(ctx, fmt.Sprintf("Migration %s %s applied in %v", r.Source.Path, r.Direction, r.Duration))
+	}
+}
+
+// RunMigrations applies all pending migrations.
+func RunMigrations(ctx context.Context, connStr string, cfg config.DatabaseMigrations, logger *slog.Logger) error {
+	migrator, err := NewMigrator(connStr, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	return migrator.Up(ctx)
+}
@@ -7,15 +7,25 @@ import (
 )
 
 type CallbackMessageEntity struct {
-	ID               uuid.UUID
-	PaymentID        uuid.UUID
-	Url              string
-	Payload          string
-	CreatedAt        time.Time
-	UpdatedAt        time.Time
-	ScheduledAt      *time.Time
-	DeliveredAt      *time.Time
+	ID          uuid.UUID
+	PaymentID   uuid.UUID
+	Url         string
+	Payload     string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ScheduledAt *time.Time
+	DeliveredAt *time.Time
+	PublishedAt *time.Time
+	Sequence    int64
+	// EventTime is the time of the payment state the callback reports, callbacks of a payment supersede each other
+	// in its order.
+	EventTime        time.Time
+	SupersededBy     *uuid.UUID
+	CorrelationID    string
+	TraceID          string
 	DeliveryAttempts int
 	PublishAttempts  int
 	Error            *string
+	// Version is incremented by every update, updates of a stale entity fail with ErrConflict.
+	Version int64
 }
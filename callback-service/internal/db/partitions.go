@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// partitionLockID is the advisory lock serialising partition maintenance across instances.
+const partitionLockID = 7_305_001
+
+const partitionPrefix = "callback_message_p"
+
+// defaultPartition holds the callbacks created outside of all partitions, e.g. while partitions were not created in
+// time. Creating the partition of their day moves them out of it.
+const defaultPartition = "callback_message_default"
+
+// Partition is a partition of callback_message, holding the callbacks created in [From, To). Daily partitions are
+// named callback_message_pYYYYMMDD. The callbacks created before partitioning are kept in partitions of up to a month
+// named callback_message_pYYYYMMDD_YYYYMMDD by their first and end day. Days are UTC days.
+type Partition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// PartitionName returns the name of the daily partition of the given day, callback_message_pYYYYMMDD.
+func PartitionName(day time.Time) string {
+	return partitionPrefix + day.Format("20060102")
+}
+
+// parsePartition parses the days of a partition from its name. It reports false for partitions not named by the
+// service, like the default partition.
+func parsePartition(name string) (Partition, bool) {
+	days, ok := strings.CutPrefix(name, partitionPrefix)
+	if !ok {
+		return Partition{}, false
+	}
+	first, end, ranged := strings.Cut(days, "_")
+
+	from, err := time.Parse("20060102", first)
+	if err != nil {
+		return Partition{}, false
+	}
+	to := from.AddDate(0, 0, 1)
+	if ranged {
+		if to, err = time.Parse("20060102", end); err != nil || !to.After(from) {
+			return Partition{}, false
+		}
+	}
+	return Partition{Name: name, From: from, To: to}, true
+}
+
+// TryLockPartitions takes the transaction scoped partition maintenance lock and reports whether it got it. Statements
+// of the transaction wait at most lockTimeout for table locks, so creating or dropping a partition does not hold up
+// queries on callback_message queued behind it for long.
+func (r *CallbackRepository) TryLockPartitions(ctx context.Context, tx Tx, lockTimeout time.Duration) (bool, error) {
+	if _, err := pgxTx(tx).Exec(ctx, fmt.Sprintf("SET LOCAL lock_timeout = %d", lockTimeout.Milliseconds())); err != nil {
+		return false, errors.Wrap(err, "setting lock timeout")
+	}
+
+	var locked bool
+	if err := pgxTx(tx).QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, partitionLockID).Scan(&locked); err != nil {
+		return false, errors.Wrap(err, "locking partitions")
+	}
+	return locked, nil
+}
+
+// Partitions lists the partitions of callback_message named by the service, ordered by day.
+func (r *CallbackRepository) Partitions(ctx context.Context, tx Tx) ([]Partition, error) {
+	query := `SELECT c.relname
+	          FROM pg_inherits i
+	          JOIN pg_class c ON c.oid = i.inhrelid
+	          WHERE i.inhparent = 'callback_message'::regclass
+	          ORDER BY c.relname`
+	rows, err := pgxTx(tx).Query(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing partitions")
+	}
+	defer rows.Close()
+
+	var partitions []Partition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "scanning partition")
+		}
+		partition, ok := parsePartition(name)
+		if !ok {
+			// The default partition and partitions attached by hand are left alone.
+			continue
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, rows.Err()
+}
+
+// CreatePartition creates the partition of the given day unless it exists. The day must be a UTC midnight. Callbacks
+// of the day in the default partition are moved to the new partition: Postgres refuses to create a partition for rows
+// the default partition holds, so it is detached meanwhile.
+func (r *CallbackRepository) CreatePartition(ctx context.Context, tx Tx, day time.Time) error {
+	name := pgx.Identifier{PartitionName(day)}.Sanitize()
+	from, to := day, day.AddDate(0, 0, 1)
+
+	var stranded bool
+	query := `SELECT EXISTS (SELECT 1 FROM ` + defaultPartition + ` WHERE created_at >= $1 AND created_at < $2)`
+	if err := pgxTx(tx).QueryRow(ctx, query, from, to).Scan(&stranded); err != nil {
+		return errors.Wrapf(err, "checking default partition for %s", day.Format(time.DateOnly))
+	}
+
+	create := `CREATE TABLE IF NOT EXISTS ` + name + `
+	           PARTITION OF callback_message
+	           FOR VALUES FROM ('` + from.Format(time.RFC3339) + `') TO ('` + to.Format(time.RFC3339) + `')`
+	if !stranded {
+		if _, err := pgxTx(tx).Exec(ctx, create); err != nil {
+			return errors.Wrapf(err, "creating partition for %s", day.Format(time.DateOnly))
+		}
+		return nil
+	}
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{query: `ALTER TABLE callback_message DETACH PARTITION ` + defaultPartition},
+		{query: create},
+		{query: `INSERT INTO ` + name + ` SELECT * FROM ` + defaultPartition + ` WHERE created_at >= $1 AND created_at < $2`, args: []any{from, to}},
+		{query: `DELETE FROM ` + defaultPartition + ` WHERE created_at >= $1 AND created_at < $2`, args: []any{from, to}},
+		{query: `ALTER TABLE callback_message ATTACH PARTITION ` + defaultPartition + ` DEFAULT`},
+	}
+	for _, statement := range statements {
+		if _, err := pgxTx(tx).Exec(ctx, statement.query, statement.args...); err != nil {
+			return errors.Wrapf(err, "moving callbacks of %s out of the default partition", day.Format(time.DateOnly))
+		}
+	}
+	return nil
+}
+
+// HasUnfinishedCallbacks reports whether the partition holds callbacks that are still scheduled or published and not
+// delivered.
+func (r *CallbackRepository) HasUnfinishedCallbacks(ctx context.Context, tx Tx, partition Partition) (bool, error) {
+	query := `SELECT EXISTS (
+	              SELECT 1
+	              FROM ` + pgx.Identifier{partition.Name}.Sanitize() + `
+	              WHERE delivered_at IS NULL AND (scheduled_at IS NOT NULL OR published_at IS NOT NULL))`
+	var exists bool
+	if err := pgxTx(tx).QueryRow(ctx, query).Scan(&exists); err != nil {
+		return false, errors.Wrapf(err, "checking partition %s for unfinished callbacks", partition.Name)
+	}
+	return exists, nil
+}
+
+// DeleteKeys deletes up to limit keys of callbacks created in [from, to) and returns how many it deleted. The keys of
+// a dropped partition are deleted in batches afterwards, so no single statement holds many locks.
+func (r *CallbackRepository) DeleteKeys(ctx context.Context, from, to time.Time, limit int) (int, error) {
+	query := `DELETE FROM callback_message_key
+	          WHERE id IN (
+	              SELECT id
+	              FROM callback_message_key
+	              WHERE created_at >= $1 AND created_at < $2
+	              LIMIT $3)`
+	tag, err := r.pool.Exec(ctx, query, from, to, limit)
+	if err != nil {
+		return 0, errors.Wrap(err, "deleting callback message keys")
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// DropPartition drops the partition with all its callbacks.
+func (r *CallbackRepository) DropPartition(ctx context.Context, tx Tx, partition Partition) error {
+	if _, err := pgxTx(tx).Exec(ctx, `DROP TABLE `+pgx.Identifier{partition.Name}.Sanitize()); err != nil {
+		return errors.Wrapf(err, "dropping partition %s", partition.Name)
+	}
+	return nil
+}
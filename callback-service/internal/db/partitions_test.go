@@ -0,0 +1,38 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePartition(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		want   Partition
+		wantOk bool
+	}{
+		{name: "callback_message_p20261019", want: Partition{From: day("2026-10-19"), To: day("2026-10-20")}, wantOk: true},
+		{name: "callback_message_p20260901_20261001", want: Partition{From: day("2026-09-01"), To: day("2026-10-01")}, wantOk: true},
+		{name: "callback_message_p20261001_20261001"},
+		{name: "callback_message_default"},
+		{name: "callback_message_archive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parsePartition(tt.name)
+			assert.Equal(t, tt.wantOk, ok)
+			if tt.wantOk {
+				tt.want.Name = tt.name
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -2,23 +2,116 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
+	"callback-service/internal/clock"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pkg/errors"
 )
 
+// ErrDuplicate is returned by Create and CreateBatch when a callback message with the same ID, i.e. for the same event, exists.
+var ErrDuplicate = errors.New("callback message already exists")
+
+// ErrConflict is returned by updates when the callback message was changed or deleted since the entity was loaded.
+var ErrConflict = errors.New("callback message was changed concurrently")
+
+const callbackColumns = `id, payment_id, payload, url, delivery_attempts, publish_attempts, scheduled_at, delivered_at, error, created_at, updated_at, published_at, sequence, superseded_by, correlation_id, trace_id, version, event_time`
+
+// Every change of callback_message is recorded in callback_message_audit by the statement making it, attributed to the
+// actor of the context, see WithActor.
+const auditInsert = `INSERT INTO callback_message_audit (callback_id, version, operation, actor, before, after, changed_at)`
+
+// The columns the producer and the reaper own, i.e. the state of publishing the callback to the broker.
+var publicationColumns = []string{"publish_attempts", "scheduled_at", "published_at", "error"}
+
+// The columns the callback processor owns, the outcome of delivering the callback. A failed delivery reschedules the
+// callback, so they include the publication state.
+var deliveryColumns = []string{"delivery_attempts", "delivered_at", "publish_attempts", "scheduled_at", "published_at", "error"}
+
+// The columns superseding a callback sets, see markSuperseded.
+var supersessionColumns = []string{"superseded_by", "scheduled_at", "published_at", "error"}
+
+// createdAtByID looks up the creation time of the callback with the ID $1. Filtering by it restricts a query to the
+// partition of the callback at execution time.
+const createdAtByID = `SELECT created_at FROM callback_message_key WHERE id = $1`
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Tx is a transaction of a Repository. Rolling back a committed transaction only returns an error, so a rollback can
+// be deferred right after beginning.
+type Tx interface {
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// Repository stores callback messages. Methods taking a transaction only accept transactions begun by the same
+// repository; their changes become visible to other transactions when it is committed.
+type Repository interface {
+	// Now is the time of the repository's clock. Scheduled times are compared against it, so times written to
+	// callbacks must be derived from it.
+	Now() time.Time
+	BeginTx(ctx context.Context) (Tx, error)
+	Create(ctx context.Context, entity *CallbackMessageEntity) (*CallbackMessageEntity, error)
+	CreateTx(ctx context.Context, tx Tx, entity *CallbackMessageEntity) (*CallbackMessageEntity, error)
+	CreateBatch(ctx context.Context, tx Tx, entities []*CallbackMessageEntity) ([]error, error)
+	GetUnprocessedCallbacks(ctx context.Context, tx Tx, limit int) ([]*CallbackMessageEntity, error)
+	GetUnprocessedOrderedCallbacks(ctx context.Context, tx Tx, limit int) ([]*CallbackMessageEntity, error)
+	GetStaleCallbacks(ctx context.Context, tx Tx, publishedBefore time.Time, limit int) ([]*CallbackMessageEntity, error)
+	DeleteDelivered(ctx context.Context, tx Tx, deliveredBefore time.Time, limit int) ([]*CallbackMessageEntity, error)
+	DeleteFailed(ctx context.Context, tx Tx, updatedBefore time.Time, limit int) ([]*CallbackMessageEntity, error)
+	Supersede(ctx context.Context, tx Tx, entity *CallbackMessageEntity, includeInFlight bool) ([]uuid.UUID, error)
+	UpdatePublication(ctx context.Context, tx Tx, entity *CallbackMessageEntity) error
+	UpdateDelivery(ctx context.Context, tx Tx, entity *CallbackMessageEntity) error
+	SelectForUpdateByID(ctx context.Context, tx Tx, id uuid.UUID) (*CallbackMessageEntity, error)
+	SelectByID(ctx context.Context, id uuid.UUID) (*CallbackMessageEntity, error)
+	Audit(ctx context.Context, callbackID uuid.UUID) ([]*AuditEntity, error)
+	PurgeAudit(ctx context.Context, changedBefore time.Time, limit int) (int, error)
+}
+
+// CallbackRepository is the Postgres implementation of Repository.
 type CallbackRepository struct {
-	pool *pgxpool.Pool
+	pool              *pgxpool.Pool
+	operationTimeout  time.Duration
+	clock             clock.Clock
+	sequenceInPayload bool
+}
+
+var _ Repository = (*CallbackRepository)(nil)
+
+// NewCallbackRepository creates the repository. Every operation, including waiting for a pooled connection to begin a
+// transaction, is cancelled after operationTimeout; zero disables the timeout. All timestamps the repository writes
+// or compares against are taken from clk. With sequenceInPayload the sequence of a created callback is added to its
+// payload, which ordered delivery uses to let merchants order the callbacks of a payment.
+func NewCallbackRepository(pool *pgxpool.Pool, operationTimeout time.Duration, clk clock.Clock, sequenceInPayload bool) *CallbackRepository {
+	return &CallbackRepository{pool: pool, operationTimeout: operationTimeout, clock: clk, sequenceInPayload: sequenceInPayload}
+}
+
+func (r *CallbackRepository) Now() time.Time {
+	return r.clock.Now()
+}
+
+func (r *CallbackRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.operationTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.operationTimeout)
 }
 
-func NewCallbackRepository(pool *pgxpool.Pool) *CallbackRepository {
-	return &CallbackRepository{pool: pool}
+// pgxTx returns the pgx transaction of a Tx begun by a CallbackRepository.
+func pgxTx(tx Tx) pgx.Tx {
+	return tx.(pgx.Tx)
 }
 
-func (r *CallbackRepository) BeginTx(ctx context.Context) (pgx.Tx, error) {
+func (r *CallbackRepository) BeginTx(ctx context.Context) (Tx, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "beginning transaction")
@@ -26,26 +119,134 @@ func (r *CallbackRepository) BeginTx(ctx context.Context) (pgx.Tx, error) {
 	return tx, nil
 }
 
+// Create inserts the callback with the next sequence number of its payment. If the repository adds sequences to
+// payloads, the sequence is also added to the payload, so merchants can order callbacks of the same payment.
 func (r *CallbackRepository) Create(ctx context.Context, entity *CallbackMessageEntity) (*CallbackMessageEntity, error) {
-	now := time.Now()
+	return r.create(ctx, r.pool, entity)
+}
 
-	query := `INSERT INTO callback_message (id, payment_id, url, payload, created_at, updated_at, scheduled_at, delivery_attempts, publish_attempts) 
-	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
-	err := r.pool.QueryRow(ctx, query, entity.ID, entity.PaymentID, entity.Url, entity.Payload, now, now, entity.ScheduledAt, entity.DeliveryAttempts, entity.PublishAttempts).Scan(&entity.ID)
+func (r *CallbackRepository) CreateTx(ctx context.Context, tx Tx, entity *CallbackMessageEntity) (*CallbackMessageEntity, error) {
+	return r.create(ctx, pgxTx(tx), entity)
+}
+
+// createQuery inserts a callback unless one with the same ID exists, in which case no row is returned. The ID is
+// claimed in callback_message_key first, which enforces unique IDs across partitions; a concurrent insert of the
+// same ID waits for the other transaction. The sequence is only advanced for inserted callbacks, and only added to
+// the payload if $13 is true.
+const createQuery = `WITH claimed AS (
+	              INSERT INTO callback_message_key (id, created_at) VALUES ($1, $5)
+	              ON CONFLICT (id) DO NOTHING
+	              RETURNING id
+	          ),
+	          seq AS (
+	              INSERT INTO callback_payment_sequence (payment_id, last_sequence)
+	              SELECT $2, 1
+	              FROM claimed
+	              ON CONFLICT (payment_id) DO UPDATE SET last_sequence = callback_payment_sequence.last_sequence + 1
+	              RETURNING last_sequence
+	          ),
+	          inserted AS (
+	              INSERT INTO callback_message (id, payment_id, url, payload, created_at, updated_at, scheduled_at, delivery_attempts, publish_attempts, sequence, correlation_id, trace_id, event_time)
+	              SELECT $1, $2, $3, CASE WHEN $13::boolean THEN jsonb_set($4::jsonb, '{sequence}', to_jsonb(seq.last_sequence)) ELSE $4::jsonb END,
+	                     $5, $6, $7, $8, $9, seq.last_sequence, $10, $11, $14
+	              FROM seq
+	              RETURNING *
+	          ),
+	          audit AS (
+	              ` + auditInsert + `
+	              SELECT id, version, 'insert', $12, NULL, to_jsonb(inserted), $5
+	              FROM inserted
+	          )
+	          SELECT id, payload, sequence, created_at FROM inserted`
+
+func (r *CallbackRepository) createArgs(ctx context.Context, entity *CallbackMessageEntity, now time.Time) []any {
+	return []any{entity.ID, entity.PaymentID, entity.Url, entity.Payload, now, now, entity.ScheduledAt, entity.DeliveryAttempts, entity.PublishAttempts,
+		entity.CorrelationID, entity.TraceID, actorFrom(ctx), r.sequenceInPayload, entity.EventTime}
+}
+
+func (r *CallbackRepository) create(ctx context.Context, q querier, entity *CallbackMessageEntity) (*CallbackMessageEntity, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	err := q.QueryRow(ctx, createQuery, r.createArgs(ctx, entity, r.clock.Now())...).
+		Scan(&entity.ID, &entity.Payload, &entity.Sequence, &entity.CreatedAt)
 
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDuplicate
+		}
 		return nil, errors.Wrap(err, "inserting callback message")
 	}
 	return entity, nil
 }
 
-func (r *CallbackRepository) GetUnprocessedCallbacks(ctx context.Context, tx pgx.Tx, limit int) ([]*CallbackMessageEntity, error) {
-	query := `SELECT id, payment_id, payload, url, delivery_attempts, publish_attempts
-	          FROM callback_message 
-	          WHERE scheduled_at IS NOT NULL AND scheduled_at <= NOW()
-	          LIMIT $1 
+// CreateBatch inserts the callbacks in one round-trip within the transaction. The returned slice holds ErrDuplicate
+// for every callback that already exists, including callbacks repeated in the batch, and nil for inserted ones. If
+// any insert fails the transaction is aborted and only the error is returned.
+func (r *CallbackRepository) CreateBatch(ctx context.Context, tx Tx, entities []*CallbackMessageEntity) ([]error, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	now := r.clock.Now()
+
+	batch := &pgx.Batch{}
+	for _, entity := range entities {
+		batch.Queue(createQuery, r.createArgs(ctx, entity, now)...)
+	}
+
+	results := pgxTx(tx).SendBatch(ctx, batch)
+	defer results.Close()
+
+	errs := make([]error, len(entities))
+	for i, entity := range entities {
+		err := results.QueryRow().Scan(&entity.ID, &entity.Payload, &entity.Sequence, &entity.CreatedAt)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				errs[i] = ErrDuplicate
+				continue
+			}
+			return nil, errors.Wrap(err, "inserting callback message batch")
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, errors.Wrap(err, "inserting callback message batch")
+	}
+	return errs, nil
+}
+
+func (r *CallbackRepository) GetUnprocessedCallbacks(ctx context.Context, tx Tx, limit int) ([]*CallbackMessageEntity, error) {
+	query := `SELECT id, payment_id, payload, url, delivery_attempts, publish_attempts, correlation_id, trace_id, created_at, version
+	          FROM callback_message
+	          WHERE scheduled_at IS NOT NULL AND scheduled_at <= $1
+	          LIMIT $2
 	          FOR UPDATE SKIP LOCKED`
-	rows, err := tx.Query(ctx, query, limit)
+	return r.getUnprocessedCallbacks(ctx, tx, query, limit)
+}
+
+// GetUnprocessedOrderedCallbacks works like GetUnprocessedCallbacks but skips callbacks whose payment still has an
+// earlier callback in flight, i.e. scheduled or published and not yet delivered.
+func (r *CallbackRepository) GetUnprocessedOrderedCallbacks(ctx context.Context, tx Tx, limit int) ([]*CallbackMessageEntity, error) {
+	query := `SELECT c.id, c.payment_id, c.payload, c.url, c.delivery_attempts, c.publish_attempts, c.correlation_id, c.trace_id, c.created_at, c.version
+	          FROM callback_message c
+	          WHERE c.scheduled_at IS NOT NULL AND c.scheduled_at <= $1
+	            AND NOT EXISTS (
+	                SELECT 1
+	                FROM callback_message p
+	                WHERE p.payment_id = c.payment_id
+	                  AND p.sequence < c.sequence
+	                  AND p.delivered_at IS NULL
+	                  AND (p.scheduled_at IS NOT NULL OR p.published_at IS NOT NULL))
+	          ORDER BY c.scheduled_at
+	          LIMIT $2
+	          FOR UPDATE OF c SKIP LOCKED`
+	return r.getUnprocessedCallbacks(ctx, tx, query, limit)
+}
+
+func (r *CallbackRepository) getUnprocessedCallbacks(ctx context.Context, tx Tx, query string, limit int) ([]*CallbackMessageEntity, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := pgxTx(tx).Query(ctx, query, r.clock.Now(), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +255,8 @@ func (r *CallbackRepository) GetUnprocessedCallbacks(ctx context.Context, tx pgx
 	var callbacks []*CallbackMessageEntity
 	for rows.Next() {
 		var callback CallbackMessageEntity
-		if err := rows.Scan(&callback.ID, &callback.PaymentID, &callback.Payload, &callback.Url, &callback.DeliveryAttempts, &callback.PublishAttempts); err != nil {
+		if err := rows.Scan(&callback.ID, &callback.PaymentID, &callback.Payload, &callback.Url, &callback.DeliveryAttempts, &callback.PublishAttempts,
+			&callback.CorrelationID, &callback.TraceID, &callback.CreatedAt, &callback.Version); err != nil {
 			return nil, errors.Wrap(err, "scanning callback message")
 		}
 		callbacks = append(callbacks, &callback)
@@ -62,41 +264,324 @@ func (r *CallbackRepository) GetUnprocessedCallbacks(ctx context.Context, tx pgx
 	return callbacks, nil
 }
 
-func (r *CallbackRepository) Update(ctx context.Context, tx pgx.Tx, entity *CallbackMessageEntity) error {
-	query := `UPDATE callback_message 
-	          SET payment_id = $1, url = $2, payload = $3, updated_at = $4, 
-	              scheduled_at = $5, delivered_at = $6, delivery_attempts = $7, publish_attempts = $8, error = $9
-	          WHERE id = $10`
-	_, err := tx.Exec(ctx, query, entity.PaymentID, entity.Url, entity.Payload, time.Now(),
-		entity.ScheduledAt, entity.DeliveredAt, entity.DeliveryAttempts, entity.PublishAttempts, entity.Error, entity.ID)
-	return err
+func (r *CallbackRepository) GetStaleCallbacks(ctx context.Context, tx Tx, publishedBefore time.Time, limit int) ([]*CallbackMessageEntity, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + callbackColumns + `
+	          FROM callback_message
+	          WHERE published_at IS NOT NULL AND published_at <= $1 AND delivered_at IS NULL
+	          LIMIT $2
+	          FOR UPDATE SKIP LOCKED`
+	rows, err := pgxTx(tx).Query(ctx, query, publishedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var callbacks []*CallbackMessageEntity
+	for rows.Next() {
+		entity, err := scanCallback(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning stale callback message")
+		}
+		callbacks = append(callbacks, entity)
+	}
+	return callbacks, nil
 }
 
-func (r *CallbackRepository) SelectForUpdateByID(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*CallbackMessageEntity, error) {
-	query := `SELECT id, payment_id, payload, url, delivery_attempts, publish_attempts, scheduled_at, delivered_at, error, created_at, updated_at
+// Supersede supersedes the callbacks of the entity's payment that report an earlier payment state, i.e. that have an
+// earlier event time or the same event time and a lower sequence, and are neither delivered nor superseded. Only
+// callbacks waiting to be published are superseded, with includeInFlight also callbacks already published. The entity
+// takes over the earliest scheduled time of the callbacks it supersedes, so the events of a payment arriving while its
+// callback waits are coalesced into one callback, sent when the first one would have been.
+// If the payment already has a callback of a later state, the entity arrived late: it is superseded by the latest of
+// them instead, which sets entity.SupersededBy, and no callbacks are returned.
+func (r *CallbackRepository) Supersede(ctx context.Context, tx Tx, entity *CallbackMessageEntity, includeInFlight bool) ([]uuid.UUID, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	latestQuery := `SELECT id
+	                FROM callback_message
+	                WHERE payment_id = $1 AND (event_time, sequence) > ($2, $3)
+	                ORDER BY event_time DESC, sequence DESC
+	                LIMIT 1`
+	var latest uuid.UUID
+	err := pgxTx(tx).QueryRow(ctx, latestQuery, entity.PaymentID, entity.EventTime, entity.Sequence).Scan(&latest)
+	if err == nil {
+		// The entity may have been superseded already by a callback created in the same transaction.
+		current, err := r.SelectForUpdateByID(ctx, tx, entity.ID)
+		if err != nil {
+			return nil, err
+		}
+		*entity = *current
+		if entity.SupersededBy != nil {
+			return nil, nil
+		}
+		markSuperseded(entity, latest)
+		return nil, r.update(ctx, tx, entity, supersessionColumns)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.Wrap(err, "selecting latest callback message")
+	}
+
+	query := `WITH old AS (
+	              SELECT *
+	              FROM callback_message
+	              WHERE payment_id = $4 AND (event_time, sequence) < ($8, $5) AND delivered_at IS NULL AND superseded_by IS NULL
+	                AND (scheduled_at IS NOT NULL OR ($6 AND published_at IS NOT NULL))
+	              FOR UPDATE
+	          ),
+	          updated AS (
+	              UPDATE callback_message c
+	              SET superseded_by = $1, scheduled_at = NULL, published_at = NULL, error = $2, updated_at = $3, version = c.version + 1
+	              FROM old
+	              WHERE c.id = old.id AND c.created_at = old.created_at
+	              RETURNING c.*
+	          ),
+	          audit AS (
+	              ` + auditInsert + `
+	              SELECT u.id, u.version, 'update', $7, to_jsonb(old), to_jsonb(u), $3
+	              FROM updated u
+	              JOIN old ON old.id = u.id
+	          )
+	          SELECT u.id, old.scheduled_at
+	          FROM updated u
+	          JOIN old ON old.id = u.id`
+	errMsg := "Superseded by " + entity.ID.String()
+	rows, err := pgxTx(tx).Query(ctx, query, entity.ID, errMsg, r.clock.Now(), entity.PaymentID, entity.Sequence, includeInFlight,
+		actorFrom(ctx), entity.EventTime)
+	if err != nil {
+		return nil, errors.Wrap(err, "superseding callback messages")
+	}
+	defer rows.Close()
+
+	var (
+		ids      []uuid.UUID
+		earliest *time.Time
+	)
+	for rows.Next() {
+		var (
+			id          uuid.UUID
+			scheduledAt *time.Time
+		)
+		if err := rows.Scan(&id, &scheduledAt); err != nil {
+			return nil, errors.Wrap(err, "scanning superseded callback message id")
+		}
+		ids = append(ids, id)
+		if scheduledAt != nil && (earliest == nil || scheduledAt.Before(*earliest)) {
+			earliest = scheduledAt
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "superseding callback messages")
+	}
+
+	if earliest != nil && entity.ScheduledAt != nil && earliest.Before(*entity.ScheduledAt) {
+		entity.ScheduledAt = earliest
+		if err := r.update(ctx, tx, entity, []string{"scheduled_at"}); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// markSuperseded sets the supersession columns of a callback superseded by the callback with the ID by.
+func markSuperseded(entity *CallbackMessageEntity, by uuid.UUID) {
+	errMsg := "Superseded by " + by.String()
+	entity.SupersededBy = &by
+	entity.ScheduledAt = nil
+	entity.PublishedAt = nil
+	entity.Error = &errMsg
+}
+
+// DeleteDelivered deletes up to limit callbacks delivered before the given time, with their keys, and returns them.
+// Rows locked by other transactions are skipped, so deleting never waits for the producer or the processors.
+func (r *CallbackRepository) DeleteDelivered(ctx context.Context, tx Tx, deliveredBefore time.Time, limit int) ([]*CallbackMessageEntity, error) {
+	query := `WITH deleted AS (
+	              DELETE FROM callback_message
+	              WHERE (id, created_at) IN (
+	                  SELECT id, created_at
+	                  FROM callback_message
+	                  WHERE delivered_at IS NOT NULL AND delivered_at < $1
+	                  LIMIT $2
+	                  FOR UPDATE SKIP LOCKED)
+	              RETURNING ` + callbackColumns + `
+	          ),
+	          keys AS (
+	              DELETE FROM callback_message_key k USING deleted d WHERE k.id = d.id
+	          ),
+	          audit AS (
+	              ` + auditInsert + `
+	              SELECT id, version, 'delete', $3, to_jsonb(deleted), NULL, $4
+	              FROM deleted
+	          )
+	          SELECT ` + callbackColumns + ` FROM deleted`
+	return r.deleteCallbacks(ctx, tx, query, deliveredBefore, limit)
+}
+
+// DeleteFailed deletes up to limit callbacks that failed permanently, i.e. that are neither delivered nor scheduled
+// nor published, and were last updated before the given time. It returns the deleted callbacks.
+func (r *CallbackRepository) DeleteFailed(ctx context.Context, tx Tx, updatedBefore time.Time, limit int) ([]*CallbackMessageEntity, error) {
+	query := `WITH deleted AS (
+	              DELETE FROM callback_message
+	              WHERE (id, created_at) IN (
+	                  SELECT id, created_at
+	                  FROM callback_message
+	                  WHERE delivered_at IS NULL AND scheduled_at IS NULL AND published_at IS NULL AND updated_at < $1
+	                  LIMIT $2
+	                  FOR UPDATE SKIP LOCKED)
+	              RETURNING ` + callbackColumns + `
+	          ),
+	          keys AS (
+	              DELETE FROM callback_message_key k USING deleted d WHERE k.id = d.id
+	          ),
+	          audit AS (
+	              ` + auditInsert + `
+	              SELECT id, version, 'delete', $3, to_jsonb(deleted), NULL, $4
+	              FROM deleted
+	          )
+	          SELECT ` + callbackColumns + ` FROM deleted`
+	return r.deleteCallbacks(ctx, tx, query, updatedBefore, limit)
+}
+
+func (r *CallbackRepository) deleteCallbacks(ctx context.Context, tx Tx, query string, before time.Time, limit int) ([]*CallbackMessageEntity, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := pgxTx(tx).Query(ctx, query, before, limit, actorFrom(ctx), r.clock.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "deleting callback messages")
+	}
+	defer rows.Close()
+
+	var callbacks []*CallbackMessageEntity
+	for rows.Next() {
+		entity, err := scanCallback(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning deleted callback message")
+		}
+		callbacks = append(callbacks, entity)
+	}
+	return callbacks, rows.Err()
+}
+
+// UpdatePublication stores the publication state of the callback: its publish attempts, scheduled and published time
+// and error. The entity must have been loaded or created by the repository, ErrConflict is returned if the callback
+// changed since.
+func (r *CallbackRepository) UpdatePublication(ctx context.Context, tx Tx, entity *CallbackMessageEntity) error {
+	return r.update(ctx, tx, entity, publicationColumns)
+}
+
+// UpdateDelivery stores the delivery outcome of the callback: its delivery attempts, delivered time and the
+// publication state. The entity must have been loaded by the repository, ErrConflict is returned if the callback
+// changed since.
+func (r *CallbackRepository) UpdateDelivery(ctx context.Context, tx Tx, entity *CallbackMessageEntity) error {
+	return r.update(ctx, tx, entity, deliveryColumns)
+}
+
+// update sets the columns of the callback in the partition of its creation time if its version is still the entity's,
+// and increments the version.
+func (r *CallbackRepository) update(ctx context.Context, tx Tx, entity *CallbackMessageEntity, columns []string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	now := r.clock.Now()
+	args := []any{entity.ID, entity.CreatedAt, entity.Version, now, actorFrom(ctx)}
+	set := make([]string, len(columns))
+	for i, column := range columns {
+		args = append(args, columnValue(entity, column))
+		set[i] = fmt.Sprintf("%s = $%d", column, len(args))
+	}
+
+	query := `WITH old AS (
+	              SELECT *
+	              FROM callback_message
+	              WHERE id = $1 AND created_at = $2 AND version = $3
+	              FOR UPDATE
+	          ),
+	          updated AS (
+	              UPDATE callback_message c
+	              SET ` + strings.Join(set, ", ") + `, updated_at = $4, version = c.version + 1
+	              FROM old
+	              WHERE c.id = old.id AND c.created_at = old.created_at
+	              RETURNING c.*
+	          ),
+	          audit AS (
+	              ` + auditInsert + `
+	              SELECT u.id, u.version, 'update', $5, to_jsonb(old), to_jsonb(u), $4
+	              FROM updated u
+	              JOIN old ON old.id = u.id
+	          )
+	          SELECT version, updated_at FROM updated`
+	err := pgxTx(tx).QueryRow(ctx, query, args...).Scan(&entity.Version, &entity.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrConflict
+	}
+	if err != nil {
+		return errors.Wrap(err, "updating callback message")
+	}
+	return nil
+}
+
+func columnValue(entity *CallbackMessageEntity, column string) any {
+	switch column {
+	case "publish_attempts":
+		return entity.PublishAttempts
+	case "delivery_attempts":
+		return entity.DeliveryAttempts
+	case "scheduled_at":
+		return entity.ScheduledAt
+	case "published_at":
+		return entity.PublishedAt
+	case "delivered_at":
+		return entity.DeliveredAt
+	case "error":
+		return entity.Error
+	case "superseded_by":
+		return entity.SupersededBy
+	}
+	panic("unknown callback message column " + column)
+}
+
+func (r *CallbackRepository) SelectForUpdateByID(ctx context.Context, tx Tx, id uuid.UUID) (*CallbackMessageEntity, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + callbackColumns + `
 	          FROM callback_message
-	          WHERE id = $1
+	          WHERE id = $1 AND created_at = (` + createdAtByID + `)
 	          FOR UPDATE`
-	row := tx.QueryRow(ctx, query, id)
 
-	var entity CallbackMessageEntity
-	err := row.Scan(&entity.ID, &entity.PaymentID, &entity.Payload, &entity.Url, &entity.DeliveryAttempts, &entity.PublishAttempts, &entity.ScheduledAt, &entity.DeliveredAt, &entity.Error, &entity.CreatedAt, &entity.UpdatedAt)
+	entity, err := scanCallback(pgxTx(tx).QueryRow(ctx, query, id))
 	if err != nil {
 		return nil, errors.Wrap(err, "selecting callback message for update")
 	}
-	return &entity, nil
+	return entity, nil
 }
 
 func (r *CallbackRepository) SelectByID(ctx context.Context, id uuid.UUID) (*CallbackMessageEntity, error) {
-	query := `SELECT id, payment_id, payload, url, delivery_attempts, publish_attempts, scheduled_at, delivered_at, error, created_at, updated_at
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + callbackColumns + `
 	          FROM callback_message
-	          WHERE id = $1`
-	row := r.pool.QueryRow(ctx, query, id)
+	          WHERE id = $1 AND created_at = (` + createdAtByID + `)`
 
-	var entity CallbackMessageEntity
-	err := row.Scan(&entity.ID, &entity.PaymentID, &entity.Payload, &entity.Url, &entity.DeliveryAttempts, &entity.PublishAttempts, &entity.ScheduledAt, &entity.DeliveredAt, &entity.Error, &entity.CreatedAt, &entity.UpdatedAt)
+	entity, err := scanCallback(r.pool.QueryRow(ctx, query, id))
 	if err != nil {
 		return nil, errors.Wrap(err, "selecting callback message")
 	}
+	return entity, nil
+}
+
+func scanCallback(row pgx.Row) (*CallbackMessageEntity, error) {
+	var entity CallbackMessageEntity
+	err := row.Scan(&entity.ID, &entity.PaymentID, &entity.Payload, &entity.Url, &entity.DeliveryAttempts, &entity.PublishAttempts,
+		&entity.ScheduledAt, &entity.DeliveredAt, &entity.Error, &entity.CreatedAt, &entity.UpdatedAt, &entity.PublishedAt, &entity.Sequence, &entity.SupersededBy, &entity.CorrelationID, &entity.TraceID, &entity.Version, &entity.EventTime)
+	if err != nil {
+		return nil, err
+	}
 	return &entity, nil
 }
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"callback-service/internal/config"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+)
+
+var (
+	txSuccessCounter   = metrics.GetOrCreateCounter(`db_transactions_total{result="success"}`)
+	txFailedCounter    = metrics.GetOrCreateCounter(`db_transactions_total{result="failed"}`)
+	txExhaustedCounter = metrics.GetOrCreateCounter(`db_transactions_total{result="retries_exhausted"}`)
+	txRetriesCounter   = metrics.GetOrCreateCounter(`db_transaction_retries_total`)
+)
+
+// transientCodes are the SQLSTATEs of errors that do not depend on the statement, so running the transaction again
+// may succeed.
+var transientCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"53300": true, // too_many_connections
+}
+
+// IsTransient reports whether the error is a temporary failure of the database or the connection to it, after which
+// the transaction can be run again. Errors of the statement itself, e.g. constraint violations, are not transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection_exception.
+		return transientCodes[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08")
+	}
+
+	var netErr net.Error
+	return pgconn.SafeToRetry(err) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// RetryPolicy is how often and how long a transaction failing with a transient error is run again.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func NewRetryPolicy(cfg config.DatabaseRetry) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    cfg.MaxAttempts,
+		InitialBackoff: time.Duration(cfg.InitialBackoffMs) * time.Millisecond,
+		MaxBackoff:     time.Duration(cfg.MaxBackoffMs) * time.Millisecond,
+	}
+}
+
+// backoff returns the delay before the given retry, doubling from the initial backoff up to the maximum.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, p.MaxBackoff)
+}
+
+// InTx runs fn in a transaction and commits it. If fn or the commit fail with a transient error, or fn with
+// ErrConflict, the transaction is rolled back and run again after a backoff, up to the policy's attempts. fn must
+// therefore only change the database through the transaction and load the callbacks it updates within it. When a
+// commit fails it is unknown whether the transaction was committed, so a rerun of fn must check whether its changes
+// are already there.
+func InTx(ctx context.Context, repo Repository, policy RetryPolicy, fn func(tx Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, repo, fn)
+		if err == nil {
+			txSuccessCounter.Inc()
+			return nil
+		}
+		if !IsTransient(err) && !errors.Is(err, ErrConflict) {
+			txFailedCounter.Inc()
+			return err
+		}
+		if attempt >= policy.MaxAttempts {
+			txExhaustedCounter.Inc()
+			return errors.Wrapf(err, "giving up after %d attempts", attempt)
+		}
+
+		txRetriesCounter.Inc()
+		select {
+		case <-time.After(policy.backoff(attempt)):
+		case <-ctx.Done():
+			return errors.Wrap(err, "context done while retrying transaction")
+		}
+	}
+}
+
+func runTx(ctx context.Context, repo Repository, fn func(tx Tx) error) error {
+	tx, err := repo.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"callback-service/internal/clock"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Nil", err: nil, want: false},
+		{name: "Serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "Deadlock", err: &pgconn.PgError{Code: "40P01"}, want: true},
+		{name: "Admin shutdown", err: errors.Wrap(&pgconn.PgError{Code: "57P01"}, "updating callback"), want: true},
+		{name: "Connection failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "Unique violation", err: &pgconn.PgError{Code: "23505"}, want: false},
+		{name: "Connection reset", err: errors.Wrap(syscall.ECONNRESET, "reading"), want: true},
+		{name: "Unexpected EOF", err: io.ErrUnexpectedEOF, want: true},
+		{name: "No rows", err: errors.Wrap(pgx.ErrNoRows, "selecting"), want: false},
+		{name: "Duplicate", err: ErrDuplicate, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsTransient(tt.err))
+		})
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 300*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 300*time.Millisecond, policy.backoff(10))
+}
+
+func TestInTx(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	t.Run("Retries transient errors", func(t *testing.T) {
+		repo := NewMemoryRepository(clock.System, false)
+		entity := newCallback(uuid.New())
+
+		attempts := 0
+		err := InTx(ctx, repo, policy, func(tx Tx) error {
+			attempts++
+			if _, err := repo.CreateTx(ctx, tx, entity); err != nil {
+				return err
+			}
+			if attempts < 3 {
+				return &pgconn.PgError{Code: "40001"}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+
+		stored, err := repo.SelectByID(ctx, entity.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stored.Sequence, "failed attempts are rolled back")
+	})
+
+	t.Run("Retries conflicts", func(t *testing.T) {
+		attempts := 0
+		err := InTx(ctx, NewMemoryRepository(clock.System, false), policy, func(Tx) error {
+			attempts++
+			if attempts < 2 {
+				return errors.Wrap(ErrConflict, "updating callback")
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("Gives up after max attempts", func(t *testing.T) {
+		attempts := 0
+		err := InTx(ctx, NewMemoryRepository(clock.System, false), policy, func(Tx) error {
+			attempts++
+			return &pgconn.PgError{Code: "40P01"}
+		})
+		assert.True(t, IsTransient(err))
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("Does not retry other errors", func(t *testing.T) {
+		attempts := 0
+		err := InTx(ctx, NewMemoryRepository(clock.System, false), policy, func(Tx) error {
+			attempts++
+			return ErrDuplicate
+		})
+		assert.ErrorIs(t, err, ErrDuplicate)
+		assert.Equal(t, 1, attempts)
+	})
+}
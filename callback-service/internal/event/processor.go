@@ -7,43 +7,157 @@ import (
 	"log/slog"
 	"time"
 
+	"callback-service/internal/config"
 	"callback-service/internal/db"
 	"callback-service/internal/logging"
 	"callback-service/internal/message"
 	"callback-service/internal/payload"
+	"callback-service/internal/tracing"
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 )
 
 var (
 	successCounter = metrics.GetOrCreateCounter(`event_processor_messages_total{result="success"}`)
 	dropCounter    = metrics.GetOrCreateCounter(`event_processor_messages_total{result="drop"}`)
 	failCounter    = metrics.GetOrCreateCounter(`event_processor_messages_total{result="fail"}`)
+
+	duplicateCounter = metrics.GetOrCreateCounter(`event_processor_messages_total{result="duplicate"}`)
+	rejectedCounter  = metrics.GetOrCreateCounter(`event_processor_messages_total{result="rejected"}`)
+
+	supersededCounter = metrics.GetOrCreateCounter(`event_processor_superseded_callbacks_total`)
+
+	batchSizeHistogram = metrics.GetOrCreateHistogram(`event_processor_batch_size`)
+)
+
+// Result is the outcome of processing an event that did not fail.
+type Result string
+
+const (
+	ResultCreated   Result = "created"
+	ResultDuplicate Result = "duplicate"
+	ResultDropped   Result = "dropped"
+	ResultRejected  Result = "rejected"
 )
 
 type Processor struct {
-	repo   *db.CallbackRepository
-	logger *slog.Logger
+	repo               db.Repository
+	validator          *Validator
+	supersessionPolicy string
+	debounce           time.Duration
+	logger             *slog.Logger
 }
 
-func NewProcessor(repo *db.CallbackRepository, logger *slog.Logger) *Processor {
+func NewProcessor(repo db.Repository, cfg config.CallbackSupersession, validation config.EventValidation, logger *slog.Logger) *Processor {
 	return &Processor{
-		repo:   repo,
-		logger: logger.With("component", "event.processor"),
+		repo:               repo,
+		validator:          NewValidator(validation),
+		supersessionPolicy: cfg.Policy,
+		debounce:           time.Duration(cfg.DebounceMs) * time.Millisecond,
+		logger:             logger.With("component", "event.processor"),
 	}
 }
 
-func (p *Processor) Process(ctx context.Context, event message.PaymentEvent) error {
-	ctx = logging.AppendCtx(ctx, slog.String("correlationId", uuid.New().String()))
+// Process stores the callback for the event. An event whose callback is already stored, e.g. because the event was
+// redelivered, is reported as duplicate. Invalid events are rejected with a *ValidationError listing the invalid
+// fields, processing them again can not succeed.
+func (p *Processor) Process(ctx context.Context, event message.PaymentEvent) (Result, error) {
+	ctx = db.WithActor(ctx, "event.processor")
+
+	ctx, entity, result, err := p.prepare(ctx, event)
+	if entity == nil {
+		return result, err
+	}
+
+	return p.outcome(ctx, p.save(ctx, entity))
+}
+
+// BatchEvent is an event processed in a batch, with the context of its message.
+type BatchEvent struct {
+	Ctx   context.Context
+	Event message.PaymentEvent
+}
+
+// BatchResult is the outcome of processing an event of a batch.
+type BatchResult struct {
+	Result Result
+	Err    error
+}
+
+// ProcessBatch works like Process, but stores the callbacks of all events in one transaction. The results are in the
+// order of the events. If the transaction fails, every event with a callback to store fails with its error.
+func (p *Processor) ProcessBatch(ctx context.Context, events []BatchEvent) []BatchResult {
+	ctx = db.WithActor(ctx, "event.processor")
+
+	results := make([]BatchResult, len(events))
+
+	var (
+		indexes  []int
+		ctxs     []context.Context
+		entities []*db.CallbackMessageEntity
+	)
+	for i, e := range events {
+		eventCtx, entity, result, err := p.prepare(e.Ctx, e.Event)
+		if entity == nil {
+			results[i] = BatchResult{Result: result, Err: err}
+			continue
+		}
+		indexes = append(indexes, i)
+		ctxs = append(ctxs, eventCtx)
+		entities = append(entities, entity)
+	}
+	if len(entities) == 0 {
+		return results
+	}
+
+	batchSizeHistogram.Update(float64(len(entities)))
+
+	errs, err := p.saveBatch(ctx, ctxs, entities)
+	for j, i := range indexes {
+		saveErr := err
+		if err == nil {
+			saveErr = errs[j]
+		}
+		result, resultErr := p.outcome(ctxs[j], saveErr)
+		results[i] = BatchResult{Result: result, Err: resultErr}
+	}
+	return results
+}
+
+// prepare validates the event and maps it to the callback to store. Without a callback, the event is done with the
+// returned result and error.
+func (p *Processor) prepare(ctx context.Context, event message.PaymentEvent) (context.Context, *db.CallbackMessageEntity, Result, error) {
+	correlationID, ok := logging.CorrelationID(ctx)
+	if !ok {
+		correlationID = uuid.New().String()
+		ctx = logging.WithCorrelationID(ctx, correlationID)
+	}
 	ctx = logging.AppendCtx(ctx, slog.String("eventId", event.ID.String()))
 
+	traceParent, ok := tracing.FromContext(ctx)
+	if !ok {
+		traceParent = tracing.NewTraceParent("")
+	}
+
 	p.logger.InfoContext(ctx, fmt.Sprintf("Processing event %v", event))
 
+	var validationErr *ValidationError
+	if err := p.validator.Validate(event); errors.As(err, &validationErr) {
+		rejectedCounter.Inc()
+		for _, fieldErr := range validationErr.Errors {
+			metrics.GetOrCreateCounter(fmt.Sprintf(`event_processor_validation_errors_total{field=%q}`, fieldErr.Field)).Inc()
+		}
+
+		p.logger.WarnContext(ctx, fmt.Sprintf("Event rejected: %v", err), slog.Any("validationErrors", validationErr.Errors))
+		return ctx, nil, ResultRejected, err
+	}
+
 	if event.Payload.Status != "successful" && event.Payload.Status != "failed" {
 		dropCounter.Inc()
 
 		p.logger.InfoContext(ctx, "Event dropped due to status")
-		return nil
+		return ctx, nil, ResultDropped, nil
 	}
 
 	payloadBytes, err := json.Marshal(toPayload(event))
@@ -51,23 +165,129 @@ func (p *Processor) Process(ctx context.Context, event message.PaymentEvent) err
 		failCounter.Inc()
 
 		p.logger.ErrorContext(ctx, fmt.Sprintf("Error marshalling payload: %v", err))
-		return err
+		return ctx, nil, "", err
 	}
 
-	_, err = p.repo.Create(ctx, toEntity(event, payloadBytes, time.Now()))
+	entity := toEntity(event, payloadBytes, p.scheduledAt())
+	entity.CorrelationID = correlationID
+	entity.TraceID = traceParent.TraceID
+
+	return ctx, entity, "", nil
+}
+
+func (p *Processor) outcome(ctx context.Context, err error) (Result, error) {
+	if errors.Is(err, db.ErrDuplicate) {
+		duplicateCounter.Inc()
+		p.logger.InfoContext(ctx, "Callback for event already exists, skipping")
+		return ResultDuplicate, nil
+	}
 	if err != nil {
 		failCounter.Inc()
 		p.logger.ErrorContext(ctx, fmt.Sprintf("Error saving callback message: %v", err))
-		return err
+		return "", err
 	}
 
 	p.logger.InfoContext(ctx, "Event processed successfully")
 
 	successCounter.Inc()
 
+	return ResultCreated, nil
+}
+
+func (p *Processor) scheduledAt() time.Time {
+	if p.supersessionPolicy == config.SupersessionCoalesce {
+		return p.repo.Now().Add(p.debounce)
+	}
+	return p.repo.Now()
+}
+
+func (p *Processor) save(ctx context.Context, entity *db.CallbackMessageEntity) error {
+	if p.supersessionPolicy == config.SupersessionNone {
+		_, err := p.repo.Create(ctx, entity)
+		return err
+	}
+
+	tx, err := p.repo.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := p.repo.CreateTx(ctx, tx, entity); err != nil {
+		return err
+	}
+
+	superseded, err := p.supersede(ctx, tx, entity)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	p.logSuperseded(ctx, entity, superseded)
+
 	return nil
 }
 
+// saveBatch stores the callbacks in one transaction and returns ErrDuplicate for the callbacks already stored. The
+// callbacks of a batch supersede each other like callbacks stored one by one.
+func (p *Processor) saveBatch(ctx context.Context, ctxs []context.Context, entities []*db.CallbackMessageEntity) ([]error, error) {
+	tx, err := p.repo.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	errs, err := p.repo.CreateBatch(ctx, tx, entities)
+	if err != nil {
+		return nil, err
+	}
+
+	superseded := make([][]uuid.UUID, len(entities))
+	if p.supersessionPolicy != config.SupersessionNone {
+		for i, entity := range entities {
+			if errs[i] != nil {
+				continue
+			}
+			if superseded[i], err = p.supersede(ctx, tx, entity); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.Wrap(err, "committing transaction")
+	}
+
+	for i, entity := range entities {
+		p.logSuperseded(ctxs[i], entity, superseded[i])
+	}
+
+	return errs, nil
+}
+
+// supersede supersedes the callbacks of the entity's payment reporting an earlier payment state, or the entity itself
+// if it arrived after a callback of a later state. With the cancel policy callbacks already published to the broker
+// are superseded too, the callback processor skips them before sending. With coalesce only callbacks still waiting to
+// be published are replaced, and the entity keeps their scheduled time: the first event of a payment opens a window
+// of debounce-ms, the events arriving within it are coalesced into one callback sent when the window closes.
+func (p *Processor) supersede(ctx context.Context, tx db.Tx, entity *db.CallbackMessageEntity) ([]uuid.UUID, error) {
+	return p.repo.Supersede(ctx, tx, entity, p.supersessionPolicy == config.SupersessionCancel)
+}
+
+func (p *Processor) logSuperseded(ctx context.Context, entity *db.CallbackMessageEntity, superseded []uuid.UUID) {
+	if entity.SupersededBy != nil {
+		p.logger.InfoContext(ctx, fmt.Sprintf("Callback %s arrived after a later payment state, superseded by %s", entity.ID, entity.SupersededBy))
+		supersededCounter.Inc()
+	}
+	for _, id := range superseded {
+		p.logger.InfoContext(ctx, fmt.Sprintf("Callback %s superseded by %s", id, entity.ID))
+	}
+	supersededCounter.Add(len(superseded))
+}
+
 func toEntity(event message.PaymentEvent, payloadBytes []byte, scheduledAt time.Time) *db.CallbackMessageEntity {
 	return &db.CallbackMessageEntity{
 		ID:          event.ID,
@@ -75,6 +295,20 @@ func toEntity(event message.PaymentEvent, payloadBytes []byte, scheduledAt time.
 		Payload:     string(payloadBytes),
 		Url:         event.Payload.CallbackUrl,
 		ScheduledAt: &scheduledAt,
+		EventTime:   eventTime(event),
+	}
+}
+
+// eventTime is the time of the payment state the event reports: the payment's update time, or for payments without
+// one the time of the CloudEvent or else the payment's creation time.
+func eventTime(event message.PaymentEvent) time.Time {
+	switch {
+	case !event.Payload.UpdatedAt.IsZero():
+		return event.Payload.UpdatedAt
+	case event.Time != nil:
+		return *event.Time
+	default:
+		return event.Payload.CreatedAt
 	}
 }
 
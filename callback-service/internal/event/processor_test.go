@@ -0,0 +1,253 @@
+package event
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"callback-service/internal/clock"
+	"callback-service/internal/config"
+	"callback-service/internal/db"
+	"callback-service/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProcessor(repo db.Repository, policy string) *Processor {
+	return NewProcessor(repo, config.CallbackSupersession{Policy: policy}, config.EventValidation{EventTypes: []string{"created", "updated"}}, slog.Default())
+}
+
+func TestProcessor_Process(t *testing.T) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository(clock.System, false)
+	processor := newTestProcessor(repo, config.SupersessionNone)
+
+	event := validEvent()
+	result, err := processor.Process(ctx, event)
+	require.NoError(t, err)
+	assert.Equal(t, ResultCreated, result)
+
+	stored, err := repo.SelectByID(ctx, event.ID)
+	require.NoError(t, err)
+	assert.Equal(t, event.Payload.ID, stored.PaymentID)
+	assert.Equal(t, event.Payload.CallbackUrl, stored.Url)
+	assert.Equal(t, int64(1), stored.Sequence)
+	assert.NotNil(t, stored.ScheduledAt)
+
+	result, err = processor.Process(ctx, event)
+	require.NoError(t, err)
+	assert.Equal(t, ResultDuplicate, result)
+
+	pending := validEvent()
+	pending.Payload.Status = "pending"
+	result, err = processor.Process(ctx, pending)
+	require.NoError(t, err)
+	assert.Equal(t, ResultDropped, result)
+}
+
+func TestProcessor_Process_Supersedes(t *testing.T) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository(clock.System, false)
+	processor := newTestProcessor(repo, config.SupersessionCancel)
+
+	first := validEvent()
+	_, err := processor.Process(ctx, first)
+	require.NoError(t, err)
+
+	second := validEvent()
+	second.Payload.ID = first.Payload.ID
+	second.Payload.Status = "failed"
+	_, err = processor.Process(ctx, second)
+	require.NoError(t, err)
+
+	stored, err := repo.SelectByID(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &second.ID, stored.SupersededBy)
+	assert.Nil(t, stored.ScheduledAt)
+}
+
+// laterEvent returns an event of the same payment reporting a state updated d after the event's.
+func laterEvent(event message.PaymentEvent, d time.Duration) message.PaymentEvent {
+	later := validEvent()
+	later.Payload.ID = event.Payload.ID
+	later.Payload.CreatedAt = event.Payload.CreatedAt
+	later.Payload.UpdatedAt = event.Payload.UpdatedAt.Add(d)
+	later.Payload.Status = "failed"
+	return later
+}
+
+func TestProcessor_Process_LateArrivalIsSuperseded(t *testing.T) {
+	for _, policy := range []string{config.SupersessionCancel, config.SupersessionCoalesce} {
+		t.Run(policy, func(t *testing.T) {
+			ctx := context.Background()
+			repo := db.NewMemoryRepository(clock.System, false)
+			processor := newTestProcessor(repo, policy)
+
+			older := validEvent()
+			newer := laterEvent(older, time.Minute)
+
+			_, err := processor.Process(ctx, newer)
+			require.NoError(t, err)
+			result, err := processor.Process(ctx, older)
+			require.NoError(t, err)
+			assert.Equal(t, ResultCreated, result)
+
+			stored, err := repo.SelectByID(ctx, older.ID)
+			require.NoError(t, err)
+			assert.Equal(t, &newer.ID, stored.SupersededBy, "the older state arrived late and must not be sent")
+			assert.Nil(t, stored.ScheduledAt)
+
+			stored, err = repo.SelectByID(ctx, newer.ID)
+			require.NoError(t, err)
+			assert.Nil(t, stored.SupersededBy)
+			assert.NotNil(t, stored.ScheduledAt)
+		})
+	}
+}
+
+func TestProcessor_Process_LateArrivalAfterDelivery(t *testing.T) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository(clock.System, false)
+	processor := newTestProcessor(repo, config.SupersessionCancel)
+
+	older := validEvent()
+	newer := laterEvent(older, time.Minute)
+
+	_, err := processor.Process(ctx, newer)
+	require.NoError(t, err)
+
+	tx, err := repo.BeginTx(ctx)
+	require.NoError(t, err)
+	delivered, err := repo.SelectForUpdateByID(ctx, tx, newer.ID)
+	require.NoError(t, err)
+	deliveredAt := repo.Now()
+	delivered.DeliveredAt = &deliveredAt
+	delivered.ScheduledAt = nil
+	delivered.DeliveryAttempts = 1
+	require.NoError(t, repo.UpdateDelivery(ctx, tx, delivered))
+	require.NoError(t, tx.Commit(ctx))
+
+	_, err = processor.Process(ctx, older)
+	require.NoError(t, err)
+
+	stored, err := repo.SelectByID(ctx, older.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &newer.ID, stored.SupersededBy, "an older state must not follow the delivered later one")
+	assert.Nil(t, stored.ScheduledAt)
+}
+
+func TestProcessor_ProcessBatch_OutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository(clock.System, false)
+	processor := newTestProcessor(repo, config.SupersessionCancel)
+
+	first := validEvent()
+	second := laterEvent(first, time.Minute)
+	third := laterEvent(first, 2*time.Minute)
+
+	results := processor.ProcessBatch(ctx, []BatchEvent{
+		{Ctx: ctx, Event: third},
+		{Ctx: ctx, Event: first},
+		{Ctx: ctx, Event: second},
+	})
+	for _, result := range results {
+		require.NoError(t, result.Err)
+	}
+
+	for _, event := range []message.PaymentEvent{first, second} {
+		stored, err := repo.SelectByID(ctx, event.ID)
+		require.NoError(t, err)
+		assert.Equal(t, &third.ID, stored.SupersededBy)
+		assert.Nil(t, stored.ScheduledAt)
+	}
+
+	stored, err := repo.SelectByID(ctx, third.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.SupersededBy)
+	assert.NotNil(t, stored.ScheduledAt)
+}
+
+// manualClock is a clock that only moves when advanced.
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func TestProcessor_Process_CoalescesWithinWindow(t *testing.T) {
+	ctx := context.Background()
+	clk := &manualClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	repo := db.NewMemoryRepository(clk, false)
+	processor := NewProcessor(repo, config.CallbackSupersession{Policy: config.SupersessionCoalesce, DebounceMs: 1000},
+		config.EventValidation{}, slog.Default())
+
+	first := validEvent()
+	windowEnd := clk.now.Add(time.Second)
+	_, err := processor.Process(ctx, first)
+	require.NoError(t, err)
+
+	clk.now = clk.now.Add(600 * time.Millisecond)
+	second := laterEvent(first, time.Minute)
+	_, err = processor.Process(ctx, second)
+	require.NoError(t, err)
+
+	clk.now = clk.now.Add(300 * time.Millisecond)
+	third := laterEvent(first, 2*time.Minute)
+	_, err = processor.Process(ctx, third)
+	require.NoError(t, err)
+
+	stored, err := repo.SelectByID(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &second.ID, stored.SupersededBy)
+	stored, err = repo.SelectByID(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &third.ID, stored.SupersededBy)
+
+	stored, err = repo.SelectByID(ctx, third.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.ScheduledAt)
+	assert.True(t, windowEnd.Equal(*stored.ScheduledAt), "the window opened by the first event is not extended, got %s", stored.ScheduledAt)
+}
+
+func TestEventTime(t *testing.T) {
+	cloudEventTime := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+
+	event := validEvent()
+	event.Time = &cloudEventTime
+	assert.Equal(t, event.Payload.UpdatedAt, eventTime(event), "the payment update time comes first")
+
+	event.Payload.UpdatedAt = time.Time{}
+	assert.Equal(t, cloudEventTime, eventTime(event))
+
+	event.Time = nil
+	assert.Equal(t, event.Payload.CreatedAt, eventTime(event))
+}
+
+func TestProcessor_ProcessBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := db.NewMemoryRepository(clock.System, false)
+	processor := newTestProcessor(repo, config.SupersessionNone)
+
+	existing := validEvent()
+	_, err := processor.Process(ctx, existing)
+	require.NoError(t, err)
+
+	invalid := validEvent()
+	invalid.Payload.Amount = -1
+
+	results := processor.ProcessBatch(ctx, []BatchEvent{
+		{Ctx: ctx, Event: validEvent()},
+		{Ctx: ctx, Event: existing},
+		{Ctx: ctx, Event: invalid},
+	})
+
+	require.Len(t, results, 3)
+	assert.Equal(t, BatchResult{Result: ResultCreated}, results[0])
+	assert.Equal(t, BatchResult{Result: ResultDuplicate}, results[1])
+	assert.Equal(t, ResultRejected, results[2].Result)
+	var validationErr *ValidationError
+	assert.ErrorAs(t, results[2].Err, &validationErr)
+}
@@ -0,0 +1,110 @@
+package event
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"callback-service/internal/config"
+	"callback-service/internal/message"
+	"github.com/google/uuid"
+)
+
+// FieldError describes why a field of an event is invalid. Fields are named by their JSON path.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a rejected event.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message))
+	}
+	return strings.Join(messages, "; ")
+}
+
+type Validator struct {
+	eventTypes map[string]bool
+}
+
+// NewValidator creates a validator accepting the configured event types. Without configured event types any event
+// type is accepted.
+func NewValidator(cfg config.EventValidation) *Validator {
+	eventTypes := make(map[string]bool, len(cfg.EventTypes))
+	for _, eventType := range cfg.EventTypes {
+		eventTypes[eventType] = true
+	}
+	return &Validator{eventTypes: eventTypes}
+}
+
+// Validate returns a *ValidationError if any field of the event is invalid.
+func (v *Validator) Validate(e message.PaymentEvent) error {
+	var errs []FieldError
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if e.ID == uuid.Nil {
+		fail("id", "is required")
+	}
+	switch {
+	case e.Event == "":
+		fail("event", "is required")
+	case len(v.eventTypes) > 0 && !v.eventTypes[e.Event]:
+		fail("event", "unknown event type %q", e.Event)
+	}
+
+	p := e.Payload
+	if p.ID == uuid.Nil {
+		fail("payload.id", "is required")
+	}
+	if p.Amount < 0 {
+		fail("payload.amount", "must not be negative, got %d", p.Amount)
+	}
+	switch {
+	case p.Currency == "":
+		fail("payload.currency", "is required")
+	case !currencies[p.Currency]:
+		fail("payload.currency", "unknown ISO 4217 currency code %q", p.Currency)
+	}
+	if p.Status == "" {
+		fail("payload.status", "is required")
+	}
+	if p.CreatedAt.IsZero() {
+		fail("payload.createdAt", "is required")
+	}
+	if !p.UpdatedAt.IsZero() && p.UpdatedAt.Before(p.CreatedAt) {
+		fail("payload.updatedAt", "must not be before createdAt")
+	}
+	if err := validateCallbackURL(p.CallbackUrl); err != "" {
+		fail("payload.callbackUrl", "%s", err)
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func validateCallbackURL(callbackURL string) string {
+	if callbackURL == "" {
+		return "is required"
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Sprintf("is not a valid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Sprintf("must be an http or https URL, got scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return "must have a host"
+	}
+	return ""
+}
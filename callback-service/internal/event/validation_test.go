@@ -0,0 +1,120 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"callback-service/internal/config"
+	"callback-service/internal/message"
+	"callback-service/internal/payload"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validEvent() message.PaymentEvent {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return message.PaymentEvent{
+		ID:    uuid.New(),
+		Event: "updated",
+		Payload: payload.Payment{
+			ID:          uuid.New(),
+			Amount:      100,
+			Currency:    "EUR",
+			Status:      "successful",
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt.Add(time.Minute),
+			CallbackUrl: "https://merchant.example.com/callback",
+		},
+	}
+}
+
+func TestValidator_Validate(t *testing.T) {
+	validator := NewValidator(config.EventValidation{EventTypes: []string{"created", "updated"}})
+
+	tests := []struct {
+		name   string
+		modify func(e *message.PaymentEvent)
+		want   []FieldError
+	}{
+		{name: "Valid event", modify: func(*message.PaymentEvent) {}},
+		{name: "Zero amount", modify: func(e *message.PaymentEvent) { e.Payload.Amount = 0 }},
+		{name: "Without updatedAt", modify: func(e *message.PaymentEvent) { e.Payload.UpdatedAt = time.Time{} }},
+		{
+			name:   "Nil IDs",
+			modify: func(e *message.PaymentEvent) { e.ID = uuid.Nil; e.Payload.ID = uuid.Nil },
+			want:   []FieldError{{Field: "id", Message: "is required"}, {Field: "payload.id", Message: "is required"}},
+		},
+		{
+			name:   "Unknown event type",
+			modify: func(e *message.PaymentEvent) { e.Event = "deleted" },
+			want:   []FieldError{{Field: "event", Message: `unknown event type "deleted"`}},
+		},
+		{
+			name:   "Negative amount",
+			modify: func(e *message.PaymentEvent) { e.Payload.Amount = -1 },
+			want:   []FieldError{{Field: "payload.amount", Message: "must not be negative, got -1"}},
+		},
+		{
+			name:   "Unknown currency",
+			modify: func(e *message.PaymentEvent) { e.Payload.Currency = "eur" },
+			want:   []FieldError{{Field: "payload.currency", Message: `unknown ISO 4217 currency code "eur"`}},
+		},
+		{
+			name:   "Missing status and createdAt",
+			modify: func(e *message.PaymentEvent) { e.Payload.Status = ""; e.Payload.CreatedAt = time.Time{} },
+			want:   []FieldError{{Field: "payload.status", Message: "is required"}, {Field: "payload.createdAt", Message: "is required"}},
+		},
+		{
+			name:   "updatedAt before createdAt",
+			modify: func(e *message.PaymentEvent) { e.Payload.UpdatedAt = e.Payload.CreatedAt.Add(-time.Second) },
+			want:   []FieldError{{Field: "payload.updatedAt", Message: "must not be before createdAt"}},
+		},
+		{
+			name:   "Missing callback URL",
+			modify: func(e *message.PaymentEvent) { e.Payload.CallbackUrl = "" },
+			want:   []FieldError{{Field: "payload.callbackUrl", Message: "is required"}},
+		},
+		{
+			name:   "Callback URL without http scheme",
+			modify: func(e *message.PaymentEvent) { e.Payload.CallbackUrl = "merchant.example.com/callback" },
+			want:   []FieldError{{Field: "payload.callbackUrl", Message: `must be an http or https URL, got scheme ""`}},
+		},
+		{
+			name:   "Callback URL without host",
+			modify: func(e *message.PaymentEvent) { e.Payload.CallbackUrl = "http:///callback" },
+			want:   []FieldError{{Field: "payload.callbackUrl", Message: "must have a host"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := validEvent()
+			tt.modify(&e)
+
+			err := validator.Validate(e)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *ValidationError
+			require.True(t, errors.As(err, &validationErr), "expected a ValidationError, got %v", err)
+			assert.Equal(t, tt.want, validationErr.Errors)
+		})
+	}
+}
+
+func TestValidator_AcceptsAnyEventTypeWithoutConfiguredTypes(t *testing.T) {
+	e := validEvent()
+	e.Event = "payment.refunded"
+
+	assert.NoError(t, NewValidator(config.EventValidation{}).Validate(e))
+}
+
+func TestValidationError_Error(t *testing.T) {
+	err := &ValidationError{Errors: []FieldError{{Field: "id", Message: "is required"}, {Field: "event", Message: "is required"}}}
+
+	assert.Equal(t, "id: is required; event: is required", err.Error())
+}
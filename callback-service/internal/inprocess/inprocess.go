@@ -0,0 +1,120 @@
+package inprocess
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"callback-service/internal/broker"
+	"github.com/pkg/errors"
+)
+
+// Broker dispatches messages through buffered channels within the process. Messages are lost on shutdown, callbacks
+// published but not processed by then are rescheduled by the reaper.
+type Broker struct {
+	mu         sync.Mutex
+	queues     map[string]chan broker.Message
+	bufferSize int
+}
+
+func NewBroker(bufferSize int) *Broker {
+	return &Broker{
+		queues:     make(map[string]chan broker.Message),
+		bufferSize: max(bufferSize, 1),
+	}
+}
+
+func (b *Broker) queue(topic string) chan broker.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[topic]
+	if !ok {
+		q = make(chan broker.Message, b.bufferSize)
+		b.queues[topic] = q
+	}
+	return q
+}
+
+func (b *Broker) NewPublisher(topic string) *Publisher {
+	return &Publisher{topic: topic, queue: b.queue(topic)}
+}
+
+func (b *Broker) NewSubscriber(topic string) *Subscriber {
+	return &Subscriber{topic: topic, queue: b.queue(topic)}
+}
+
+type Publisher struct {
+	mu    sync.Mutex
+	topic string
+	queue chan broker.Message
+}
+
+// Publish blocks while the queue is full until the processor takes messages out of it or the context is done. The
+// producer fetches no more callbacks than Free reports, so it only waits when messages are published elsewhere too.
+func (p *Publisher) Publish(ctx context.Context, messages ...broker.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for i, m := range messages {
+		m.Topic = p.topic
+		m.Time = now
+		select {
+		case p.queue <- m:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "publishing %d messages to %s, %d queued", len(messages), p.topic, i)
+		}
+	}
+	return nil
+}
+
+// Free reports the free space of the queue. Only subscribers take messages out of it, so it can only shrink by
+// publishing.
+func (p *Publisher) Free() int {
+	return cap(p.queue) - len(p.queue)
+}
+
+func (p *Publisher) Close() error {
+	return nil
+}
+
+type Subscriber struct {
+	topic string
+	queue chan broker.Message
+}
+
+func (s *Subscriber) Topic() string {
+	return s.topic
+}
+
+func (s *Subscriber) Fetch(ctx context.Context) (broker.Delivery, error) {
+	select {
+	case m := <-s.queue:
+		return delivery{message: m}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Subscriber) Close() error {
+	return nil
+}
+
+// delivery has nothing to acknowledge. A message that failed to process is not queued again, the callback stays
+// published and the reaper reschedules it after the visibility timeout.
+type delivery struct {
+	message broker.Message
+}
+
+func (d delivery) Message() broker.Message {
+	return d.message
+}
+
+func (d delivery) Ack(context.Context) error {
+	return nil
+}
+
+func (d delivery) Nack(context.Context) error {
+	return nil
+}
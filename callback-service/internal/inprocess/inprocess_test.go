@@ -0,0 +1,71 @@
+package inprocess
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"callback-service/internal/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishAndFetch(t *testing.T) {
+	b := NewBroker(10)
+	publisher := b.NewPublisher("callback-messages")
+	subscriber := b.NewSubscriber("callback-messages")
+
+	err := publisher.Publish(context.Background(), broker.Message{
+		Key:     []byte("payment-1"),
+		Value:   []byte("value"),
+		Headers: map[string]string{"correlation-id": "c-1"},
+	})
+	require.NoError(t, err)
+
+	d, err := subscriber.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "callback-messages", d.Message().Topic)
+	assert.Equal(t, []byte("payment-1"), d.Message().Key)
+	assert.Equal(t, []byte("value"), d.Message().Value)
+	assert.Equal(t, "c-1", d.Message().Headers["correlation-id"])
+	assert.False(t, d.Message().Time.IsZero())
+	assert.NoError(t, d.Ack(context.Background()))
+}
+
+func TestPublish_BlocksWhileQueueFull(t *testing.T) {
+	b := NewBroker(2)
+	publisher := b.NewPublisher("callback-messages")
+	subscriber := b.NewSubscriber("callback-messages")
+
+	require.NoError(t, publisher.Publish(context.Background(), broker.Message{Value: []byte("first")}))
+	assert.Equal(t, 1, publisher.Free())
+
+	published := make(chan error)
+	go func() {
+		published <- publisher.Publish(context.Background(), broker.Message{Value: []byte("second")}, broker.Message{Value: []byte("third")})
+	}()
+
+	select {
+	case err := <-published:
+		t.Fatalf("publish returned while the queue is full: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for _, want := range []string{"first", "second", "third"} {
+		d, err := subscriber.Fetch(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []byte(want), d.Message().Value)
+	}
+	require.NoError(t, <-published)
+	assert.Equal(t, 2, publisher.Free())
+}
+
+func TestPublish_ContextDoneWhileQueueFull(t *testing.T) {
+	publisher := NewBroker(1).NewPublisher("callback-messages")
+	require.NoError(t, publisher.Publish(context.Background(), broker.Message{Value: []byte("first")}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := publisher.Publish(ctx, broker.Message{Value: []byte("second")})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,124 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"strings"
+	"time"
+
+	"callback-service/internal/config"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	SASLMechanismPlain       = "plain"
+	SASLMechanismScramSHA256 = "scram-sha-256"
+	SASLMechanismScramSHA512 = "scram-sha-512"
+)
+
+// NewDialer returns the dialer shared by all readers, configured with the TLS and SASL settings of cfg.
+func NewDialer(cfg config.Kafka) (*kafka.Dialer, error) {
+	tlsConfig, mechanism, err := security(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Dialer{
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		TLS:           tlsConfig,
+		SASLMechanism: mechanism,
+	}, nil
+}
+
+// NewTransport returns the transport shared by all writers, configured with the TLS and SASL settings of cfg.
+func NewTransport(cfg config.Kafka) (*kafka.Transport, error) {
+	tlsConfig, mechanism, err := security(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Transport{
+		TLS:  tlsConfig,
+		SASL: mechanism,
+	}, nil
+}
+
+func security(cfg config.Kafka) (*tls.Config, sasl.Mechanism, error) {
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "configuring kafka tls")
+	}
+
+	mechanism, err := newSASLMechanism(cfg.SASL)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "configuring kafka sasl")
+	}
+
+	return tlsConfig, mechanism, nil
+}
+
+func newTLSConfig(cfg config.KafkaTLS) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading ca file")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificates found in ca file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func newSASLMechanism(cfg config.KafkaSASL) (sasl.Mechanism, error) {
+	if cfg.Mechanism == "" {
+		return nil, nil
+	}
+
+	password := cfg.Password
+	if cfg.PasswordFile != "" {
+		content, err := os.ReadFile(cfg.PasswordFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading password file")
+		}
+		password = strings.TrimSpace(string(content))
+	}
+
+	switch strings.ToLower(cfg.Mechanism) {
+	case SASLMechanismPlain:
+		return plain.Mechanism{Username: cfg.Username, Password: password}, nil
+	case SASLMechanismScramSHA256:
+		return scram.Mechanism(scram.SHA256, cfg.Username, password)
+	case SASLMechanismScramSHA512:
+		return scram.Mechanism(scram.SHA512, cfg.Username, password)
+	default:
+		return nil, errors.Errorf("unknown sasl mechanism %q", cfg.Mechanism)
+	}
+}
@@ -0,0 +1,66 @@
+package kafka
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"callback-service/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSASLMechanism(t *testing.T) {
+	tests := []struct {
+		name              string
+		mechanism         string
+		expectedMechanism string
+		expectedError     bool
+	}{
+		{name: "Disabled", mechanism: ""},
+		{name: "Plain", mechanism: "plain", expectedMechanism: "PLAIN"},
+		{name: "SCRAM-SHA-256", mechanism: "scram-sha-256", expectedMechanism: "SCRAM-SHA-256"},
+		{name: "SCRAM-SHA-512", mechanism: "SCRAM-SHA-512", expectedMechanism: "SCRAM-SHA-512"},
+		{name: "Unknown", mechanism: "gssapi", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mechanism, err := newSASLMechanism(config.KafkaSASL{Mechanism: tt.mechanism, Username: "user", Password: "secret"})
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			if tt.expectedMechanism == "" {
+				assert.Nil(t, mechanism)
+			} else {
+				assert.Equal(t, tt.expectedMechanism, mechanism.Name())
+			}
+		})
+	}
+}
+
+func TestNewSASLMechanism_PasswordFile(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+
+	_, err := newSASLMechanism(config.KafkaSASL{Mechanism: "plain", Username: "user", PasswordFile: passwordFile})
+	assert.NoError(t, err)
+
+	_, err = newSASLMechanism(config.KafkaSASL{Mechanism: "plain", Username: "user", PasswordFile: passwordFile + ".missing"})
+	assert.Error(t, err)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	tlsConfig, err := newTLSConfig(config.KafkaTLS{Enabled: false})
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	tlsConfig, err = newTLSConfig(config.KafkaTLS{Enabled: true, InsecureSkipVerify: true})
+	assert.NoError(t, err)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+
+	_, err = newTLSConfig(config.KafkaTLS{Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+}
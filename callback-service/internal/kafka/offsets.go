@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsets tracks the fetched messages of each partition until they are processed. Messages are processed
+// concurrently and finish in any order, but committing an offset commits all earlier offsets of the partition as
+// well, so only the watermark, the last offset up to which all messages are processed, may be committed.
+type offsets struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	// pending are the fetched offsets that are not below the watermark yet, in the order they were fetched.
+	pending []int64
+	done    map[int64]bool
+}
+
+func newOffsets() *offsets {
+	return &offsets{partitions: make(map[int]*partitionOffsets)}
+}
+
+// fetched registers a fetched message. Fetching an offset that is not after the pending ones means the partition was
+// reassigned and is read again from its committed offset, the offsets still pending are forgotten.
+func (o *offsets) fetched(m kafka.Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p, ok := o.partitions[m.Partition]
+	if !ok || (len(p.pending) > 0 && m.Offset <= p.pending[len(p.pending)-1]) {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		o.partitions[m.Partition] = p
+	}
+	p.pending = append(p.pending, m.Offset)
+}
+
+// processed marks a message as processed and returns the message at the watermark of its partition if the watermark
+// moved, it is the message to commit.
+func (o *offsets) processed(m kafka.Message) (kafka.Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p, ok := o.partitions[m.Partition]
+	if !ok {
+		return kafka.Message{}, false
+	}
+	p.done[m.Offset] = true
+
+	n := 0
+	for n < len(p.pending) && p.done[p.pending[n]] {
+		delete(p.done, p.pending[n])
+		n++
+	}
+	if n == 0 {
+		return kafka.Message{}, false
+	}
+
+	watermark := p.pending[n-1]
+	p.pending = p.pending[n:]
+	return kafka.Message{Topic: m.Topic, Partition: m.Partition, Offset: watermark}, true
+}
@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffsets(t *testing.T) {
+	message := func(partition int, offset int64) kafka.Message {
+		return kafka.Message{Topic: "payment-events", Partition: partition, Offset: offset}
+	}
+
+	t.Run("Commits up to the contiguous processed offset", func(t *testing.T) {
+		o := newOffsets()
+		for offset := int64(10); offset < 13; offset++ {
+			o.fetched(message(0, offset))
+		}
+
+		_, ok := o.processed(message(0, 12))
+		assert.False(t, ok)
+		_, ok = o.processed(message(0, 11))
+		assert.False(t, ok)
+
+		watermark, ok := o.processed(message(0, 10))
+		assert.True(t, ok)
+		assert.Equal(t, message(0, 12), watermark)
+	})
+
+	t.Run("Unprocessed message holds the watermark", func(t *testing.T) {
+		o := newOffsets()
+		for offset := int64(0); offset < 3; offset++ {
+			o.fetched(message(0, offset))
+		}
+
+		watermark, ok := o.processed(message(0, 0))
+		assert.True(t, ok)
+		assert.Equal(t, message(0, 0), watermark)
+
+		_, ok = o.processed(message(0, 2))
+		assert.False(t, ok)
+	})
+
+	t.Run("Partitions are independent", func(t *testing.T) {
+		o := newOffsets()
+		o.fetched(message(0, 5))
+		o.fetched(message(1, 7))
+		o.fetched(message(0, 6))
+
+		watermark, ok := o.processed(message(1, 7))
+		assert.True(t, ok)
+		assert.Equal(t, message(1, 7), watermark)
+
+		_, ok = o.processed(message(0, 6))
+		assert.False(t, ok)
+	})
+
+	t.Run("Reassigned partition starts over", func(t *testing.T) {
+		o := newOffsets()
+		o.fetched(message(0, 5))
+		o.fetched(message(0, 6))
+		o.fetched(message(0, 5))
+
+		watermark, ok := o.processed(message(0, 5))
+		assert.True(t, ok)
+		assert.Equal(t, message(0, 5), watermark)
+	})
+}
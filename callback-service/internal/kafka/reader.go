@@ -2,94 +2,135 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"log/slog"
 	"strings"
+	"sync"
+	"time"
 
-	"callback-service/internal/callback"
-	"callback-service/internal/event"
-	"callback-service/internal/message"
-	"github.com/VictoriaMetrics/metrics"
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"github.com/pkg/errors"
 	"github.com/segmentio/kafka-go"
 )
 
-type Metrics struct {
-	ReadErrorCounter      *metrics.Counter
-	UnmarshalErrorCounter *metrics.Counter
-	ProcessErrorCounter   *metrics.Counter
-	SuccessCounter        *metrics.Counter
+func NewReader(kafkaURL, topic, groupID string, cfg config.KafkaReader, dialer *kafka.Dialer) (*kafka.Reader, error) {
+	readerConfig, err := newReaderConfig(kafkaURL, topic, groupID, cfg, dialer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "configuring reader for topic %s", topic)
+	}
+	return kafka.NewReader(readerConfig), nil
 }
 
-var (
-	// Define metrics for payment events
-	paymentEventMetrics = Metrics{
-		ReadErrorCounter:      metrics.GetOrCreateCounter(`kafka_reader_total{result="read_error",type="payment_event"}`),
-		UnmarshalErrorCounter: metrics.GetOrCreateCounter(`kafka_reader_total{result="unmarshal_error",type="payment_event"}`),
-		ProcessErrorCounter:   metrics.GetOrCreateCounter(`kafka_reader_total{result="process_error",type="payment_event"}`),
-		SuccessCounter:        metrics.GetOrCreateCounter(`kafka_reader_total{result="success",type="payment_event"}`),
+func newReaderConfig(kafkaURL, topic, groupID string, cfg config.KafkaReader, dialer *kafka.Dialer) (kafka.ReaderConfig, error) {
+	readerConfig := kafka.ReaderConfig{
+		Brokers:          strings.Split(kafkaURL, ","),
+		GroupID:          groupID,
+		Topic:            topic,
+		Dialer:           dialer,
+		MinBytes:         cfg.MinBytes,
+		MaxBytes:         cfg.MaxBytes,
+		MaxWait:          time.Duration(cfg.MaxWaitMs) * time.Millisecond,
+		CommitInterval:   time.Duration(cfg.CommitIntervalMs) * time.Millisecond,
+		RebalanceTimeout: time.Duration(cfg.RebalanceTimeoutMs) * time.Millisecond,
 	}
 
-	// Define metrics for callback messages
-	callbackMessageMetrics = Metrics{
-		ReadErrorCounter:      metrics.GetOrCreateCounter(`kafka_reader_total{result="read_error",type="callback_message"}`),
-		UnmarshalErrorCounter: metrics.GetOrCreateCounter(`kafka_reader_total{result="unmarshal_error",type="callback_message"}`),
-		ProcessErrorCounter:   metrics.GetOrCreateCounter(`kafka_reader_total{result="process_error",type="callback_message"}`),
-		SuccessCounter:        metrics.GetOrCreateCounter(`kafka_reader_total{result="success",type="callback_message"}`),
+	if groupID == "" {
+		return readerConfig, errors.New("consumer group is required")
+	}
+	if cfg.MinBytes < 0 || cfg.MaxBytes < 0 || cfg.MaxWaitMs < 0 || cfg.CommitIntervalMs < 0 || cfg.RebalanceTimeoutMs < 0 {
+		return readerConfig, errors.New("sizes and durations must not be negative")
+	}
+
+	switch cfg.StartOffset {
+	case "", "first":
+		readerConfig.StartOffset = kafka.FirstOffset
+	case "last":
+		readerConfig.StartOffset = kafka.LastOffset
+	default:
+		return readerConfig, errors.Errorf("unknown start-offset %q", cfg.StartOffset)
+	}
+
+	switch cfg.PartitionAssignmentStrategy {
+	case "", "range":
+		readerConfig.GroupBalancers = []kafka.GroupBalancer{kafka.RangeGroupBalancer{}}
+	case "round-robin":
+		readerConfig.GroupBalancers = []kafka.GroupBalancer{kafka.RoundRobinGroupBalancer{}}
+	default:
+		return readerConfig, errors.Errorf("unknown partition-assignment-strategy %q", cfg.PartitionAssignmentStrategy)
 	}
-)
 
-func NewReader(kafkaURL, topic, groupID string) *kafka.Reader {
-	return kafka.NewReader(kafka.ReaderConfig{
-		Brokers: strings.Split(kafkaURL, ","),
-		GroupID: groupID,
-		Topic:   topic,
-	})
+	return readerConfig, readerConfig.Validate()
 }
 
-func ReadPaymentEvents(reader *kafka.Reader, processor *event.Processor, logger *slog.Logger) {
-	readMessages(context.Background(), reader, logger, func(ctx context.Context, value []byte) error {
-		var e message.PaymentEvent
-		if err := json.Unmarshal(value, &e); err != nil {
-			logger.ErrorContext(ctx, fmt.Sprintf("Error unmarshalling message: %v", err))
-			paymentEventMetrics.UnmarshalErrorCounter.Inc()
-			return err
-		}
-		return processor.Process(ctx, e)
-	}, paymentEventMetrics)
+// Subscriber consumes a topic with a consumer group reader. Settled messages are committed up to the watermark of
+// their partition, so an offset is never committed while an earlier message of the partition is still processed.
+// Kafka has no negative acknowledgement and a consumer group reader can not seek back, so a rejected message is
+// settled like an acknowledged one: holding the watermark would stop committing the partition until a restart, which
+// then delivers every message after it again.
+type Subscriber struct {
+	reader  *kafka.Reader
+	offsets *offsets
+	// commitMu orders the commits, so a commit never moves the committed offset back.
+	commitMu sync.Mutex
+}
+
+func NewSubscriber(reader *kafka.Reader) *Subscriber {
+	return &Subscriber{reader: reader, offsets: newOffsets()}
+}
+
+func (s *Subscriber) Topic() string {
+	return s.reader.Config().Topic
+}
+
+func (s *Subscriber) Fetch(ctx context.Context) (broker.Delivery, error) {
+	m, err := s.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	observeMessage(m)
+	s.offsets.fetched(m)
+	return &delivery{subscriber: s, message: m}, nil
+}
+
+func (s *Subscriber) Close() error {
+	return s.reader.Close()
+}
+
+// commit commits the watermark of the partition of a processed message.
+func (s *Subscriber) commit(ctx context.Context, m kafka.Message) error {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	watermark, ok := s.offsets.processed(m)
+	if !ok {
+		return nil
+	}
+	return s.reader.CommitMessages(ctx, watermark)
+}
+
+type delivery struct {
+	subscriber *Subscriber
+	message    kafka.Message
+}
+
+func (d *delivery) Message() broker.Message {
+	headers := make(map[string]string, len(d.message.Headers))
+	for _, header := range d.message.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	return broker.Message{
+		Topic:   d.message.Topic,
+		Key:     d.message.Key,
+		Value:   d.message.Value,
+		Headers: headers,
+		Time:    d.message.Time,
+	}
 }
 
-func ReadCallbackMessages(reader *kafka.Reader, processor *callback.Processor, logger *slog.Logger) {
-	readMessages(context.Background(), reader, logger, func(ctx context.Context, value []byte) error {
-		var c message.Callback
-		if err := json.Unmarshal(value, &c); err != nil {
-			logger.ErrorContext(ctx, fmt.Sprintf("Error unmarshalling message: %v", err))
-			callbackMessageMetrics.UnmarshalErrorCounter.Inc()
-			return err
-		}
-		return processor.Process(ctx, c)
-	}, callbackMessageMetrics)
+func (d *delivery) Ack(ctx context.Context) error {
+	return d.subscriber.commit(ctx, d.message)
 }
 
-func readMessages(ctx context.Context, reader *kafka.Reader, logger *slog.Logger, process func(context.Context, []byte) error, kafkaMetrics Metrics) {
-	go func() {
-		for {
-			logger.InfoContext(ctx, "Waiting for messages from Kafka...")
-			m, err := reader.ReadMessage(ctx)
-			if err != nil {
-				logger.ErrorContext(ctx, fmt.Sprintf("Error reading message: %v", err))
-				kafkaMetrics.ReadErrorCounter.Inc()
-				continue
-			}
-			logger.InfoContext(ctx, fmt.Sprintf("Received message: %s from topic %s", string(m.Value), m.Topic))
-
-			err = process(ctx, m.Value)
-			if err != nil {
-				logger.ErrorContext(ctx, fmt.Sprintf("Error processing message: %v", err))
-				kafkaMetrics.ProcessErrorCounter.Inc()
-				continue
-			}
-			kafkaMetrics.SuccessCounter.Inc()
-		}
-	}()
+func (d *delivery) Nack(ctx context.Context) error {
+	return d.subscriber.commit(ctx, d.message)
 }
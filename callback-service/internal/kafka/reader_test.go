@@ -0,0 +1,51 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"callback-service/internal/config"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewReaderConfig(t *testing.T) {
+	cfg := config.KafkaReader{
+		StartOffset:                 "last",
+		MinBytes:                    1,
+		MaxBytes:                    1024,
+		MaxWaitMs:                   500,
+		CommitIntervalMs:            1000,
+		RebalanceTimeoutMs:          30000,
+		PartitionAssignmentStrategy: "round-robin",
+	}
+
+	readerConfig, err := newReaderConfig("broker-1:9092,broker-2:9092", "payment-events", "callback-service", cfg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"broker-1:9092", "broker-2:9092"}, readerConfig.Brokers)
+	assert.Equal(t, kafka.LastOffset, readerConfig.StartOffset)
+	assert.Equal(t, 500*time.Millisecond, readerConfig.MaxWait)
+	assert.Equal(t, time.Second, readerConfig.CommitInterval)
+	assert.Equal(t, []kafka.GroupBalancer{kafka.RoundRobinGroupBalancer{}}, readerConfig.GroupBalancers)
+}
+
+func TestNewReaderConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		groupID string
+		cfg     config.KafkaReader
+	}{
+		{name: "Missing group ID", cfg: config.KafkaReader{}},
+		{name: "Unknown start offset", groupID: "group", cfg: config.KafkaReader{StartOffset: "middle"}},
+		{name: "Unknown assignment strategy", groupID: "group", cfg: config.KafkaReader{PartitionAssignmentStrategy: "sticky"}},
+		{name: "Negative max wait", groupID: "group", cfg: config.KafkaReader{MaxWaitMs: -1}},
+		{name: "Min bytes greater than max bytes", groupID: "group", cfg: config.KafkaReader{MinBytes: 2048, MaxBytes: 1024}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newReaderConfig("localhost:9092", "payment-events", tt.groupID, tt.cfg, nil)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/segmentio/kafka-go"
+)
+
+// StatsCollector periodically exports reader and writer statistics. The metrics are pushed together with all other
+// metrics by metrics.Setup.
+type StatsCollector struct {
+	readers  []*kafka.Reader
+	writers  []*kafka.Writer
+	interval time.Duration
+	logger   *slog.Logger
+}
+
+func NewStatsCollector(intervalMs int, logger *slog.Logger) *StatsCollector {
+	return &StatsCollector{
+		interval: time.Duration(intervalMs) * time.Millisecond,
+		logger:   logger.With("component", "kafka.stats"),
+	}
+}
+
+func (c *StatsCollector) AddReader(reader *kafka.Reader) {
+	c.readers = append(c.readers, reader)
+}
+
+func (c *StatsCollector) AddWriter(writer *kafka.Writer) {
+	c.writers = append(c.writers, writer)
+}
+
+func (c *StatsCollector) Start(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, reader := range c.readers {
+				exportReaderStats(reader.Stats())
+			}
+			for _, writer := range c.writers {
+				exportWriterStats(writer.Stats())
+			}
+		case <-ctx.Done():
+			c.logger.InfoContext(ctx, "Context done, stopping stats collector")
+			return
+		}
+	}
+}
+
+// Reader stats are snapshots: counters like Rebalances hold the increase since the previous call.
+func exportReaderStats(stats kafka.ReaderStats) {
+	metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_reader_lag{topic="%s"}`, stats.Topic), nil).Set(float64(stats.Lag))
+	metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_reader_offset{topic="%s"}`, stats.Topic), nil).Set(float64(stats.Offset))
+	metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_reader_queue_length{topic="%s"}`, stats.Topic), nil).Set(float64(stats.QueueLength))
+	metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_reader_fetch_latency_milliseconds{topic="%s",stat="avg"}`, stats.Topic), nil).Set(float64(stats.ReadTime.Avg.Milliseconds()))
+	metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_reader_fetch_latency_milliseconds{topic="%s",stat="max"}`, stats.Topic), nil).Set(float64(stats.ReadTime.Max.Milliseconds()))
+	metrics.GetOrCreateCounter(fmt.Sprintf(`kafka_reader_rebalances_total{topic="%s"}`, stats.Topic)).Add(int(stats.Rebalances))
+	metrics.GetOrCreateCounter(fmt.Sprintf(`kafka_reader_fetches_total{topic="%s"}`, stats.Topic)).Add(int(stats.Fetches))
+	metrics.GetOrCreateCounter(fmt.Sprintf(`kafka_reader_errors_total{topic="%s"}`, stats.Topic)).Add(int(stats.Errors))
+}
+
+func exportWriterStats(stats kafka.WriterStats) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`kafka_writer_messages_total{topic="%s"}`, stats.Topic)).Add(int(stats.Messages))
+	metrics.GetOrCreateCounter(fmt.Sprintf(`kafka_writer_errors_total{topic="%s"}`, stats.Topic)).Add(int(stats.Errors))
+	metrics.GetOrCreateCounter(fmt.Sprintf(`kafka_writer_retries_total{topic="%s"}`, stats.Topic)).Add(int(stats.Retries))
+	metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_writer_write_latency_milliseconds{topic="%s",stat="avg"}`, stats.Topic), nil).Set(float64(stats.WriteTime.Avg.Milliseconds()))
+	metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_writer_write_latency_milliseconds{topic="%s",stat="max"}`, stats.Topic), nil).Set(float64(stats.WriteTime.Max.Milliseconds()))
+}
+
+// observeMessage records the lag and offset of the message's partition and the message age, i.e. the time between
+// the record timestamp and now.
+func observeMessage(m kafka.Message) {
+	partition := strconv.Itoa(m.Partition)
+
+	if m.HighWaterMark > 0 {
+		metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_reader_partition_lag{topic="%s",partition="%s"}`, m.Topic, partition), nil).Set(float64(m.HighWaterMark - m.Offset - 1))
+	}
+	metrics.GetOrCreateGauge(fmt.Sprintf(`kafka_reader_partition_offset{topic="%s",partition="%s"}`, m.Topic, partition), nil).Set(float64(m.Offset))
+
+	if !m.Time.IsZero() {
+		metrics.GetOrCreateHistogram(fmt.Sprintf(`kafka_reader_message_age_milliseconds{topic="%s"}`, m.Topic)).Update(float64(time.Since(m.Time).Milliseconds()))
+	}
+}
@@ -0,0 +1,34 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveMessage(t *testing.T) {
+	observeMessage(kafka.Message{
+		Topic:         "stats-test",
+		Partition:     3,
+		Offset:        41,
+		HighWaterMark: 50,
+		Time:          time.Now().Add(-time.Second),
+	})
+
+	lag := metrics.GetOrCreateGauge(`kafka_reader_partition_lag{topic="stats-test",partition="3"}`, nil)
+	assert.Equal(t, float64(8), lag.Get())
+
+	offset := metrics.GetOrCreateGauge(`kafka_reader_partition_offset{topic="stats-test",partition="3"}`, nil)
+	assert.Equal(t, float64(41), offset.Get())
+}
+
+func TestExportReaderStats(t *testing.T) {
+	exportReaderStats(kafka.ReaderStats{Topic: "stats-test", Lag: 12, Rebalances: 2})
+	exportReaderStats(kafka.ReaderStats{Topic: "stats-test", Lag: 5, Rebalances: 1})
+
+	assert.Equal(t, float64(5), metrics.GetOrCreateGauge(`kafka_reader_lag{topic="stats-test"}`, nil).Get())
+	assert.Equal(t, uint64(3), metrics.GetOrCreateCounter(`kafka_reader_rebalances_total{topic="stats-test"}`).Get())
+}
@@ -0,0 +1,169 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"callback-service/internal/config"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+}
+
+type topicAdmin interface {
+	Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	CreateTopics(ctx context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error)
+}
+
+// TopicSpecs lists the topics the service uses together with any additional topics, such as DLQ or retry topics,
+// configured for provisioning.
+func TopicSpecs(topics config.BrokerTopic, cfg config.KafkaProvisioning) []TopicSpec {
+	specs := []TopicSpec{
+		{
+			Name:              topics.PaymentEvents,
+			Partitions:        cfg.Topics.PaymentEvents.Partitions,
+			ReplicationFactor: cfg.Topics.PaymentEvents.ReplicationFactor,
+		},
+		{
+			Name:              topics.CallbackMessages,
+			Partitions:        cfg.Topics.CallbackMessages.Partitions,
+			ReplicationFactor: cfg.Topics.CallbackMessages.ReplicationFactor,
+		},
+	}
+
+	for _, topic := range cfg.AdditionalTopics {
+		specs = append(specs, TopicSpec{
+			Name:              topic.Name,
+			Partitions:        topic.Partitions,
+			ReplicationFactor: topic.ReplicationFactor,
+		})
+	}
+	return specs
+}
+
+// EnsureTopics verifies that the topics exist with the expected partition count and replication factor. Missing
+// topics are created when provision is set. A zero partition count or replication factor is not verified and uses the
+// broker default on creation.
+func EnsureTopics(ctx context.Context, kafkaURL string, transport *kafka.Transport, specs []TopicSpec, provision bool, logger *slog.Logger) error {
+	client := &kafka.Client{
+		Addr:      kafka.TCP(strings.Split(kafkaURL, ",")...),
+		Transport: transport,
+		Timeout:   10 * time.Second,
+	}
+	return ensureTopics(ctx, client, specs, provision, logger.With("component", "kafka.topics"))
+}
+
+func ensureTopics(ctx context.Context, admin topicAdmin, specs []TopicSpec, provision bool, logger *slog.Logger) error {
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+
+	metadata, err := admin.Metadata(ctx, &kafka.MetadataRequest{Topics: names})
+	if err != nil {
+		return errors.Wrap(err, "fetching topic metadata")
+	}
+
+	topics := make(map[string]kafka.Topic, len(metadata.Topics))
+	for _, topic := range metadata.Topics {
+		topics[topic.Name] = topic
+	}
+
+	var missing []TopicSpec
+	var problems []string
+	for _, spec := range specs {
+		topic, ok := topics[spec.Name]
+		if !ok || errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+			missing = append(missing, spec)
+			continue
+		}
+
+		if err := checkTopic(spec, topic); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		logger.InfoContext(ctx, fmt.Sprintf("Topic %s verified", spec.Name))
+	}
+
+	if len(missing) > 0 {
+		if provision {
+			if err := createTopics(ctx, admin, missing, logger); err != nil {
+				problems = append(problems, err.Error())
+			}
+		} else {
+			for _, spec := range missing {
+				problems = append(problems, fmt.Sprintf("topic %s does not exist", spec.Name))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("verifying kafka topics: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func checkTopic(spec TopicSpec, topic kafka.Topic) error {
+	if topic.Error != nil {
+		return errors.Wrapf(topic.Error, "topic %s", spec.Name)
+	}
+
+	if spec.Partitions > 0 && len(topic.Partitions) != spec.Partitions {
+		return errors.Errorf("topic %s has %d partitions, expected %d", spec.Name, len(topic.Partitions), spec.Partitions)
+	}
+
+	if spec.ReplicationFactor > 0 {
+		for _, partition := range topic.Partitions {
+			if len(partition.Replicas) != spec.ReplicationFactor {
+				return errors.Errorf("topic %s partition %d has replication factor %d, expected %d",
+					spec.Name, partition.ID, len(partition.Replicas), spec.ReplicationFactor)
+			}
+		}
+	}
+	return nil
+}
+
+func createTopics(ctx context.Context, admin topicAdmin, specs []TopicSpec, logger *slog.Logger) error {
+	topicConfigs := make([]kafka.TopicConfig, 0, len(specs))
+	for _, spec := range specs {
+		topicConfigs = append(topicConfigs, kafka.TopicConfig{
+			Topic:             spec.Name,
+			NumPartitions:     orBrokerDefault(spec.Partitions),
+			ReplicationFactor: orBrokerDefault(spec.ReplicationFactor),
+		})
+	}
+
+	resp, err := admin.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: topicConfigs})
+	if err != nil {
+		return errors.Wrap(err, "creating topics")
+	}
+
+	var problems []string
+	for _, spec := range specs {
+		if err := resp.Errors[spec.Name]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			problems = append(problems, fmt.Sprintf("creating topic %s: %v", spec.Name, err))
+			continue
+		}
+		logger.InfoContext(ctx, fmt.Sprintf("Topic %s created", spec.Name))
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func orBrokerDefault(value int) int {
+	if value <= 0 {
+		return -1
+	}
+	return value
+}
@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTopicAdmin struct {
+	topics  []kafka.Topic
+	created []kafka.TopicConfig
+}
+
+func (f *fakeTopicAdmin) Metadata(_ context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error) {
+	resp := &kafka.MetadataResponse{}
+	for _, name := range req.Topics {
+		topic := kafka.Topic{Name: name, Error: kafka.UnknownTopicOrPartition}
+		for _, existing := range f.topics {
+			if existing.Name == name {
+				topic = existing
+			}
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp, nil
+}
+
+func (f *fakeTopicAdmin) CreateTopics(_ context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error) {
+	f.created = append(f.created, req.Topics...)
+	return &kafka.CreateTopicsResponse{Errors: map[string]error{}}, nil
+}
+
+func topic(name string, partitions, replicas int) kafka.Topic {
+	t := kafka.Topic{Name: name}
+	for i := 0; i < partitions; i++ {
+		t.Partitions = append(t.Partitions, kafka.Partition{ID: i, Replicas: make([]kafka.Broker, replicas)})
+	}
+	return t
+}
+
+func TestEnsureTopics(t *testing.T) {
+	specs := []TopicSpec{
+		{Name: "payment-events", Partitions: 3, ReplicationFactor: 2},
+		{Name: "callback-messages", Partitions: 6, ReplicationFactor: 2},
+	}
+
+	tests := []struct {
+		name          string
+		topics        []kafka.Topic
+		provision     bool
+		expectedError string
+		expectCreated []string
+	}{
+		{
+			name:   "Verified",
+			topics: []kafka.Topic{topic("payment-events", 3, 2), topic("callback-messages", 6, 2)},
+		},
+		{
+			name:          "Missing",
+			topics:        []kafka.Topic{topic("payment-events", 3, 2)},
+			expectedError: "topic callback-messages does not exist",
+		},
+		{
+			name:          "Provisioned",
+			topics:        []kafka.Topic{topic("payment-events", 3, 2)},
+			provision:     true,
+			expectCreated: []string{"callback-messages"},
+		},
+		{
+			name:          "Wrong partition count",
+			topics:        []kafka.Topic{topic("payment-events", 1, 2), topic("callback-messages", 6, 2)},
+			provision:     true,
+			expectedError: "topic payment-events has 1 partitions, expected 3",
+		},
+		{
+			name:          "Wrong replication factor",
+			topics:        []kafka.Topic{topic("payment-events", 3, 2), topic("callback-messages", 6, 1)},
+			expectedError: "topic callback-messages partition 0 has replication factor 1, expected 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := &fakeTopicAdmin{topics: tt.topics}
+
+			err := ensureTopics(context.Background(), admin, specs, tt.provision, slog.Default())
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			var created []string
+			for _, topicConfig := range admin.created {
+				created = append(created, topicConfig.Topic)
+			}
+			assert.Equal(t, tt.expectCreated, created)
+		})
+	}
+}
@@ -1,20 +1,133 @@
 package kafka
 
 import (
+	"context"
+	"strings"
 	"time"
 
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"github.com/pkg/errors"
 	"github.com/segmentio/kafka-go"
 )
 
-func NewWriter(kafkaURL string, batchSize int, batchTimeoutMs int, topic string) *kafka.Writer {
-	return &kafka.Writer{
-		Addr:                   kafka.TCP(kafkaURL),
+func NewWriter(kafkaURL, topic string, cfg config.KafkaWriter, transport *kafka.Transport) (*kafka.Writer, error) {
+	writer, err := newWriter(kafkaURL, topic, cfg, transport)
+	if err != nil {
+		return nil, errors.Wrapf(err, "configuring writer for topic %s", topic)
+	}
+	return writer, nil
+}
+
+func newWriter(kafkaURL, topic string, cfg config.KafkaWriter, transport *kafka.Transport) (*kafka.Writer, error) {
+	if cfg.BatchSize < 0 || cfg.BatchTimeoutMs < 0 || cfg.MaxAttempts < 0 || cfg.WriteTimeoutMs < 0 {
+		return nil, errors.New("sizes, attempts and durations must not be negative")
+	}
+
+	compression, err := compression(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	requiredAcks, err := requiredAcks(cfg.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+
+	balancer, err := balancer(cfg.Balancer)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(strings.Split(kafkaURL, ",")...),
 		Topic:                  topic,
-		Balancer:               &kafka.ReferenceHash{},
-		BatchSize:              batchSize,
-		RequiredAcks:           kafka.RequireAll,
-		BatchTimeout:           time.Duration(batchTimeoutMs) * time.Millisecond,
+		Balancer:               balancer,
+		BatchSize:              cfg.BatchSize,
+		RequiredAcks:           requiredAcks,
+		BatchTimeout:           time.Duration(cfg.BatchTimeoutMs) * time.Millisecond,
+		MaxAttempts:            cfg.MaxAttempts,
+		WriteTimeout:           time.Duration(cfg.WriteTimeoutMs) * time.Millisecond,
+		Compression:            compression,
 		Async:                  false,
 		AllowAutoTopicCreation: false,
+		Transport:              transport,
+	}
+
+	return writer, nil
+}
+
+func compression(name string) (kafka.Compression, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, errors.Errorf("unknown compression %q", name)
 	}
 }
+
+func requiredAcks(name string) (kafka.RequiredAcks, error) {
+	switch name {
+	case "", "all":
+		return kafka.RequireAll, nil
+	case "one":
+		return kafka.RequireOne, nil
+	case "none":
+		return kafka.RequireNone, nil
+	default:
+		return 0, errors.Errorf("unknown required-acks %q", name)
+	}
+}
+
+// balancer maps the configured partitioner. Only key based balancers keep callbacks of one payment on one partition.
+func balancer(name string) (kafka.Balancer, error) {
+	switch name {
+	case "", "reference-hash":
+		return &kafka.ReferenceHash{}, nil
+	case "hash":
+		return &kafka.Hash{}, nil
+	case "murmur2":
+		return kafka.Murmur2Balancer{}, nil
+	case "crc32":
+		return kafka.CRC32Balancer{}, nil
+	case "round-robin":
+		return &kafka.RoundRobin{}, nil
+	case "least-bytes":
+		return &kafka.LeastBytes{}, nil
+	default:
+		return nil, errors.Errorf("unknown balancer %q", name)
+	}
+}
+
+// Publisher publishes to the topic of its writer.
+type Publisher struct {
+	writer *kafka.Writer
+}
+
+func NewPublisher(writer *kafka.Writer) *Publisher {
+	return &Publisher{writer: writer}
+}
+
+func (p *Publisher) Publish(ctx context.Context, messages ...broker.Message) error {
+	kafkaMessages := make([]kafka.Message, 0, len(messages))
+	for _, m := range messages {
+		headers := make([]kafka.Header, 0, len(m.Headers))
+		for key, value := range m.Headers {
+			headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+		kafkaMessages = append(kafkaMessages, kafka.Message{Key: m.Key, Value: m.Value, Headers: headers})
+	}
+	return p.writer.WriteMessages(ctx, kafkaMessages...)
+}
+
+func (p *Publisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"callback-service/internal/config"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWriter(t *testing.T) {
+	cfg := config.KafkaWriter{
+		BatchSize:      100,
+		BatchTimeoutMs: 100,
+		Compression:    "zstd",
+		MaxAttempts:    5,
+		WriteTimeoutMs: 2000,
+		RequiredAcks:   "one",
+		Balancer:       "murmur2",
+	}
+
+	writer, err := NewWriter("broker-1:9092,broker-2:9092", "callback-messages", cfg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, kafka.TCP("broker-1:9092", "broker-2:9092"), writer.Addr)
+	assert.Equal(t, kafka.Zstd, writer.Compression)
+	assert.Equal(t, kafka.RequireOne, writer.RequiredAcks)
+	assert.Equal(t, kafka.Murmur2Balancer{}, writer.Balancer)
+	assert.Equal(t, 2*time.Second, writer.WriteTimeout)
+}
+
+func TestNewWriter_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.KafkaWriter
+	}{
+		{name: "Unknown compression", cfg: config.KafkaWriter{Compression: "brotli"}},
+		{name: "Unknown required acks", cfg: config.KafkaWriter{RequiredAcks: "two"}},
+		{name: "Unknown balancer", cfg: config.KafkaWriter{Balancer: "sticky"}},
+		{name: "Negative max attempts", cfg: config.KafkaWriter{MaxAttempts: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWriter("localhost:9092", "callback-messages", tt.cfg, nil)
+			assert.Error(t, err)
+		})
+	}
+}
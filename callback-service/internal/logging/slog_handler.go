@@ -8,7 +8,8 @@ import (
 type ctxKey string
 
 const (
-	slogFields ctxKey = "slog_fields"
+	slogFields    ctxKey = "slog_fields"
+	correlationID ctxKey = "correlation_id"
 )
 
 type ContextHandler struct {
@@ -39,3 +40,16 @@ func AppendCtx(parent context.Context, attr slog.Attr) context.Context {
 	v = append(v, attr)
 	return context.WithValue(parent, slogFields, v)
 }
+
+func WithCorrelationID(parent context.Context, id string) context.Context {
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	return AppendCtx(context.WithValue(parent, correlationID, id), slog.String("correlationId", id))
+}
+
+func CorrelationID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationID).(string)
+	return id, ok && id != ""
+}
@@ -0,0 +1,18 @@
+package message
+
+// Message headers carried by messages on the callback-messages topic. The correlation ID and traceparent headers are
+// also read from the payment-events topic when upstream producers set them. The claim-check header is set to
+// ClaimCheckEnabled on claim-check messages. The attempt header carries the same number as the attempts field of the
+// message body, the delivery attempts made before the message was published, so it is 0 for the first delivery.
+const (
+	HeaderCorrelationID = "correlation-id"
+	HeaderTraceParent   = "traceparent"
+	HeaderSourceEventID = "source-event-id"
+	HeaderSchemaVersion = "schema-version"
+	HeaderAttempt       = "attempt"
+	HeaderClaimCheck    = "claim-check"
+	HeaderContentType   = "content-type"
+
+	CallbackSchemaVersion = "1"
+	ClaimCheckEnabled     = "true"
+)
@@ -1,6 +1,8 @@
 package message
 
 import (
+	"time"
+
 	"callback-service/internal/payload"
 	"github.com/google/uuid"
 )
@@ -9,12 +11,20 @@ type PaymentEvent struct {
 	ID      uuid.UUID       `json:"id"`
 	Event   string          `json:"event"`
 	Payload payload.Payment `json:"payload"`
+
+	// Source and Time are only set for events received as CloudEvents.
+	Source string     `json:"source,omitempty"`
+	Time   *time.Time `json:"time,omitempty"`
 }
 
+// Callback is a message of the callback-messages topic. Claim-check messages have no URL and payload.
 type Callback struct {
 	ID        uuid.UUID `json:"id"`
 	PaymentID uuid.UUID `json:"paymentId"`
-	Url       string    `json:"url"`
-	Payload   string    `json:"payload"`
+	Url       string    `json:"url,omitempty"`
+	Payload   string    `json:"payload,omitempty"`
 	Attempts  int       `json:"attempts"`
+
+	// ClaimCheck is taken from the claim-check header, it is not part of the encoded message.
+	ClaimCheck bool `json:"-"`
 }
@@ -0,0 +1,235 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pkg/errors"
+)
+
+// keyHeader carries the message key, JetStream messages have no key of their own.
+const keyHeader = "message-key"
+
+// Client connects to NATS and maps every topic to a subject of one JetStream stream.
+type Client struct {
+	conn   *nats.Conn
+	js     jetstream.JetStream
+	cfg    config.Nats
+	logger *slog.Logger
+}
+
+func NewClient(cfg config.Nats, logger *slog.Logger) (*Client, error) {
+	conn, err := nats.Connect(cfg.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to NATS")
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "creating JetStream context")
+	}
+
+	return &Client{conn: conn, js: js, cfg: cfg, logger: logger.With("component", "nats.client")}, nil
+}
+
+// EnsureStream creates the stream for the given subjects, or adds subjects missing from an existing stream.
+func (c *Client) EnsureStream(ctx context.Context, subjects ...string) error {
+	stream, err := c.js.Stream(ctx, c.cfg.Stream.Name)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		_, err := c.js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     c.cfg.Stream.Name,
+			Subjects: subjects,
+			Replicas: c.cfg.Stream.Replicas,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "creating stream %s", c.cfg.Stream.Name)
+		}
+		c.logger.InfoContext(ctx, fmt.Sprintf("Stream %s created", c.cfg.Stream.Name))
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "loading stream %s", c.cfg.Stream.Name)
+	}
+
+	streamConfig := stream.CachedInfo().Config
+	missing := missingSubjects(streamConfig.Subjects, subjects)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	streamConfig.Subjects = append(streamConfig.Subjects, missing...)
+	if _, err := c.js.UpdateStream(ctx, streamConfig); err != nil {
+		return errors.Wrapf(err, "adding subjects to stream %s", c.cfg.Stream.Name)
+	}
+	c.logger.InfoContext(ctx, fmt.Sprintf("Subjects %v added to stream %s", missing, c.cfg.Stream.Name))
+	return nil
+}
+
+func missingSubjects(existing, subjects []string) []string {
+	var missing []string
+	for _, subject := range subjects {
+		found := false
+		for _, e := range existing {
+			if e == subject {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, subject)
+		}
+	}
+	return missing
+}
+
+func (c *Client) NewPublisher(topic string) *Publisher {
+	return &Publisher{js: c.js, subject: topic}
+}
+
+// NewSubscriber creates or updates a durable pull consumer of the topic. The consumer name is derived from the group
+// and the topic, so all instances of the service share one consumer per topic.
+func (c *Client) NewSubscriber(ctx context.Context, topic, group string) (*Subscriber, error) {
+	if group == "" {
+		return nil, errors.New("consumer group is required")
+	}
+
+	consumer, err := c.js.CreateOrUpdateConsumer(ctx, c.cfg.Stream.Name, jetstream.ConsumerConfig{
+		Durable:       consumerName(group, topic),
+		FilterSubject: topic,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Duration(c.cfg.AckWaitMs) * time.Millisecond,
+		MaxDeliver:    c.cfg.MaxDeliver,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating consumer for topic %s", topic)
+	}
+
+	messages, err := consumer.Messages(jetstream.PullMaxMessages(max(c.cfg.FetchBatchSize, 1)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "subscribing to topic %s", topic)
+	}
+
+	return &Subscriber{topic: topic, messages: messages, nakDelay: time.Duration(c.cfg.AckWaitMs) * time.Millisecond}, nil
+}
+
+// consumerName replaces the characters not allowed in consumer names.
+func consumerName(group, topic string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(group + "-" + topic)
+}
+
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+type Publisher struct {
+	js      jetstream.JetStream
+	subject string
+}
+
+// Publish publishes the messages asynchronously and waits until the stream acknowledged all of them.
+func (p *Publisher) Publish(ctx context.Context, messages ...broker.Message) error {
+	futures := make([]jetstream.PubAckFuture, 0, len(messages))
+	for _, m := range messages {
+		msg := nats.NewMsg(p.subject)
+		msg.Data = m.Value
+		for key, value := range m.Headers {
+			msg.Header.Set(key, value)
+		}
+		if len(m.Key) > 0 {
+			msg.Header.Set(keyHeader, string(m.Key))
+		}
+
+		future, err := p.js.PublishMsgAsync(msg)
+		if err != nil {
+			return errors.Wrap(err, "publishing message")
+		}
+		futures = append(futures, future)
+	}
+
+	for _, future := range futures {
+		select {
+		case <-future.Ok():
+		case err := <-future.Err():
+			return errors.Wrap(err, "publishing message")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) Close() error {
+	return nil
+}
+
+// Subscriber receives messages of a durable pull consumer. Nacked messages are redelivered after the ack wait.
+type Subscriber struct {
+	topic    string
+	messages jetstream.MessagesContext
+	nakDelay time.Duration
+}
+
+func (s *Subscriber) Topic() string {
+	return s.topic
+}
+
+func (s *Subscriber) Fetch(ctx context.Context) (broker.Delivery, error) {
+	// The iterator does not take a context, stopping it unblocks Next once the context is done.
+	stop := context.AfterFunc(ctx, s.messages.Stop)
+	defer stop()
+
+	msg, err := s.messages.Next()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return &delivery{msg: msg, nakDelay: s.nakDelay}, nil
+}
+
+func (s *Subscriber) Close() error {
+	s.messages.Stop()
+	return nil
+}
+
+type delivery struct {
+	msg      jetstream.Msg
+	nakDelay time.Duration
+}
+
+func (d *delivery) Message() broker.Message {
+	m := broker.Message{
+		Topic:   d.msg.Subject(),
+		Value:   d.msg.Data(),
+		Headers: make(map[string]string, len(d.msg.Headers())),
+	}
+	for key := range d.msg.Headers() {
+		if key == keyHeader {
+			m.Key = []byte(d.msg.Headers().Get(key))
+			continue
+		}
+		m.Headers[key] = d.msg.Headers().Get(key)
+	}
+	if metadata, err := d.msg.Metadata(); err == nil {
+		m.Time = metadata.Timestamp
+	}
+	return m
+}
+
+func (d *delivery) Ack(context.Context) error {
+	return d.msg.Ack()
+}
+
+func (d *delivery) Nack(context.Context) error {
+	return d.msg.NakWithDelay(d.nakDelay)
+}
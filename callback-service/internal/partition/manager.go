@@ -0,0 +1,198 @@
+package partition
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"callback-service/internal/config"
+	"callback-service/internal/db"
+	"callback-service/internal/logging"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	runSuccessCounter = metrics.GetOrCreateCounter(`partition_manager_total{result="success"}`)
+	runFailedCounter  = metrics.GetOrCreateCounter(`partition_manager_total{result="failed"}`)
+	runSkippedCounter = metrics.GetOrCreateCounter(`partition_manager_total{result="skipped"}`)
+
+	createdCounter = metrics.GetOrCreateCounter(`partition_manager_partitions_total{action="created"}`)
+	droppedCounter = metrics.GetOrCreateCounter(`partition_manager_partitions_total{action="dropped"}`)
+	keptCounter    = metrics.GetOrCreateCounter(`partition_manager_partitions_total{action="kept_unfinished"}`)
+)
+
+// Manager maintains the partitions of callback_message: it creates the daily partitions of the coming days ahead of
+// time and drops the partitions whose days are all older than the retention period. Partitions still holding callbacks that are not
+// finished are kept. Instances coordinate with an advisory lock, only one of them maintains partitions at a time.
+type Manager struct {
+	repo          *db.CallbackRepository
+	interval      time.Duration
+	premakeDays   int
+	retentionDays int
+	lockTimeout   time.Duration
+	keyBatchSize  int
+	logger        *slog.Logger
+}
+
+func NewManager(repo *db.CallbackRepository, cfg config.DatabasePartitions, logger *slog.Logger) *Manager {
+	return &Manager{
+		repo:          repo,
+		interval:      time.Duration(cfg.IntervalMs) * time.Millisecond,
+		premakeDays:   cfg.PremakeDays,
+		retentionDays: cfg.RetentionDays,
+		lockTimeout:   time.Duration(cfg.LockTimeoutMs) * time.Millisecond,
+		keyBatchSize:  cfg.KeyBatchSize,
+		logger:        logger.With("component", "partition.manager"),
+	}
+}
+
+func (m *Manager) Start(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Run(ctx); err != nil {
+				m.logger.ErrorContext(ctx, fmt.Sprintf("Error maintaining partitions: %v", err))
+			}
+		case <-ctx.Done():
+			m.logger.InfoContext(ctx, "Context done, stopping partition manager")
+			return
+		}
+	}
+}
+
+// Run creates the missing partitions from today until the premake days ahead and drops expired partitions.
+func (m *Manager) Run(ctx context.Context) error {
+	ctx = logging.AppendCtx(ctx, slog.String("correlationId", uuid.New().String()))
+
+	err := m.run(ctx)
+	if err != nil {
+		runFailedCounter.Inc()
+	}
+	return err
+}
+
+func (m *Manager) run(ctx context.Context) error {
+	tx, err := m.repo.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	locked, err := m.repo.TryLockPartitions(ctx, tx, m.lockTimeout)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		m.logger.DebugContext(ctx, "Partitions are maintained by another instance, skipping")
+		runSkippedCounter.Inc()
+		return nil
+	}
+
+	partitions, err := m.repo.Partitions(ctx, tx)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(partitions))
+	for _, p := range partitions {
+		existing[p.Name] = true
+	}
+
+	today := today(m.repo.Now())
+	for i := 0; i <= m.premakeDays; i++ {
+		day := today.AddDate(0, 0, i)
+		if existing[db.PartitionName(day)] {
+			continue
+		}
+		if err := m.repo.CreatePartition(ctx, tx, day); err != nil {
+			return err
+		}
+		m.logger.InfoContext(ctx, fmt.Sprintf("Created partition %s", db.PartitionName(day)))
+		createdCounter.Inc()
+	}
+
+	var dropped []db.Partition
+	if m.retentionDays > 0 {
+		expiredBefore := today.AddDate(0, 0, -m.retentionDays)
+		for _, p := range partitions {
+			if p.To.After(expiredBefore) {
+				break
+			}
+
+			unfinished, err := m.repo.HasUnfinishedCallbacks(ctx, tx, p)
+			if err != nil {
+				return err
+			}
+			if unfinished {
+				m.logger.WarnContext(ctx, fmt.Sprintf("Partition %s is expired but has unfinished callbacks, keeping it", p.Name))
+				keptCounter.Inc()
+				continue
+			}
+
+			if err := m.repo.DropPartition(ctx, tx, p); err != nil {
+				return err
+			}
+			dropped = append(dropped, p)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "committing partition changes")
+	}
+
+	for _, p := range dropped {
+		m.logger.InfoContext(ctx, fmt.Sprintf("Dropped partition %s", p.Name))
+		droppedCounter.Inc()
+
+		if err := m.deleteKeys(ctx, p.From, p.To); err != nil {
+			return err
+		}
+	}
+
+	// Keys older than the oldest partition are left over when deleting the keys of a dropped partition failed.
+	if m.retentionDays > 0 && len(partitions) > len(dropped) {
+		oldest := oldestKept(partitions, dropped)
+		if err := m.deleteKeys(ctx, time.Time{}, oldest.From); err != nil {
+			return err
+		}
+	}
+
+	runSuccessCounter.Inc()
+	return nil
+}
+
+func (m *Manager) deleteKeys(ctx context.Context, from, to time.Time) error {
+	for {
+		n, err := m.repo.DeleteKeys(ctx, from, to, m.keyBatchSize)
+		if err != nil {
+			return err
+		}
+		if n < m.keyBatchSize {
+			return nil
+		}
+	}
+}
+
+func oldestKept(partitions, dropped []db.Partition) db.Partition {
+	isDropped := make(map[string]bool, len(dropped))
+	for _, p := range dropped {
+		isDropped[p.Name] = true
+	}
+	for _, p := range partitions {
+		if !isDropped[p.Name] {
+			return p
+		}
+	}
+	return db.Partition{}
+}
+
+// today is the UTC day of now, the day of the partition callbacks created now are stored in.
+func today(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -20,4 +20,5 @@ type Callback struct {
 	ID        uuid.UUID `json:"id"`
 	PaymentId uuid.UUID `json:"paymentId"`
 	Status    string    `json:"status"`
+	Sequence  int64     `json:"sequence,omitempty"`
 }
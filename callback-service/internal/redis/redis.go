@@ -0,0 +1,297 @@
+package redis
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Stream entry fields. Headers are stored as one field per header with the headerPrefix.
+const (
+	keyField     = "key"
+	valueField   = "value"
+	headerPrefix = "header:"
+)
+
+// closeTimeout bounds removing the consumer of a closed subscriber.
+const closeTimeout = 5 * time.Second
+
+// deleteIdleConsumer removes a consumer from the group unless it has pending messages. Checking and deleting in one
+// script keeps a consumer from reading a message in between, which would be lost with the consumer.
+var deleteIdleConsumer = redis.NewScript(`
+if #redis.call('XPENDING', KEYS[1], ARGV[1], '-', '+', 1, ARGV[2]) > 0 then
+	return 0
+end
+return redis.call('XGROUP', 'DELCONSUMER', KEYS[1], ARGV[1], ARGV[2])
+`)
+
+// Client maps every topic to a Redis stream of the same name.
+type Client struct {
+	rdb *redis.Client
+	cfg config.Redis
+}
+
+func NewClient(ctx context.Context, cfg config.Redis) (*Client, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.Address,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, errors.Wrap(err, "connecting to Redis")
+	}
+	return &Client{rdb: rdb, cfg: cfg}, nil
+}
+
+func (c *Client) NewPublisher(topic string) *Publisher {
+	return &Publisher{rdb: c.rdb, stream: topic, maxLen: c.cfg.MaxLen}
+}
+
+// NewSubscriber creates the consumer group of the stream if it does not exist yet. Every subscriber is a consumer of
+// its own, named by consumerName, so replicas sharing a hostname do not read each other's pending messages. Messages a
+// stopped instance left pending are claimed by the others once they are idle for claim-min-idle, and consumers left
+// without pending messages are removed, so restarts do not grow the group.
+func (c *Client) NewSubscriber(ctx context.Context, topic, group string) (*Subscriber, error) {
+	if group == "" {
+		return nil, errors.New("consumer group is required")
+	}
+
+	err := c.rdb.XGroupCreateMkStream(ctx, topic, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, errors.Wrapf(err, "creating consumer group for stream %s", topic)
+	}
+
+	return &Subscriber{
+		rdb:          c.rdb,
+		stream:       topic,
+		group:        group,
+		consumer:     consumerName(),
+		block:        time.Duration(c.cfg.BlockMs) * time.Millisecond,
+		claimMinIdle: time.Duration(c.cfg.ClaimMinIdleMs) * time.Millisecond,
+		batchSize:    int64(max(c.cfg.FetchBatchSize, 1)),
+		claimCursor:  "0-0",
+	}, nil
+}
+
+// consumerName returns a consumer name unique to the subscriber: the hostname, the process ID and a random ID.
+func consumerName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid()) + "-" + uuid.New().String()[:8]
+}
+
+func (c *Client) Close() error {
+	return c.rdb.Close()
+}
+
+type Publisher struct {
+	rdb    *redis.Client
+	stream string
+	maxLen int64
+}
+
+// Publish adds the messages in one pipeline. The stream is trimmed to about max-len entries, a zero max-len keeps
+// all entries.
+func (p *Publisher) Publish(ctx context.Context, messages ...broker.Message) error {
+	pipe := p.rdb.Pipeline()
+	for _, m := range messages {
+		values := make(map[string]any, len(m.Headers)+2)
+		values[keyField] = m.Key
+		values[valueField] = m.Value
+		for key, value := range m.Headers {
+			values[headerPrefix+key] = value
+		}
+
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: p.stream,
+			MaxLen: p.maxLen,
+			Approx: p.maxLen > 0,
+			Values: values,
+		})
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "publishing messages")
+	}
+	return nil
+}
+
+func (p *Publisher) Close() error {
+	return nil
+}
+
+// Subscriber reads a stream as a member of a consumer group. Messages that are not acknowledged, because they were
+// nacked or their consumer died, stay pending and are claimed again once they are idle for claim-min-idle.
+// Fetch must not be called concurrently.
+type Subscriber struct {
+	rdb          *redis.Client
+	stream       string
+	group        string
+	consumer     string
+	block        time.Duration
+	claimMinIdle time.Duration
+	batchSize    int64
+
+	buffered    []redis.XMessage
+	claimCursor string
+	lastClaim   time.Time
+}
+
+func (s *Subscriber) Topic() string {
+	return s.stream
+}
+
+func (s *Subscriber) Fetch(ctx context.Context) (broker.Delivery, error) {
+	for {
+		if len(s.buffered) > 0 {
+			m := s.buffered[0]
+			s.buffered = s.buffered[1:]
+			return &delivery{rdb: s.rdb, stream: s.stream, group: s.group, message: m}, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if time.Since(s.lastClaim) >= s.claimMinIdle {
+			if err := s.claim(ctx); err != nil {
+				return nil, err
+			}
+			if len(s.buffered) > 0 {
+				continue
+			}
+		}
+
+		streams, err := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{s.stream, ">"},
+			Count:    s.batchSize,
+			Block:    s.block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading stream %s", s.stream)
+		}
+		for _, stream := range streams {
+			s.buffered = append(s.buffered, stream.Messages...)
+		}
+	}
+}
+
+// claim takes over pending messages idle for longer than claimMinIdle, continuing where the previous call stopped.
+func (s *Subscriber) claim(ctx context.Context) error {
+	messages, cursor, err := s.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.stream,
+		Group:    s.group,
+		Consumer: s.consumer,
+		MinIdle:  s.claimMinIdle,
+		Start:    s.claimCursor,
+		Count:    s.batchSize,
+	}).Result()
+	if err != nil {
+		return errors.Wrapf(err, "claiming pending messages of stream %s", s.stream)
+	}
+
+	s.buffered = append(s.buffered, messages...)
+	s.claimCursor = cursor
+	// A full scan of the pending entries is complete once the cursor wraps around.
+	if cursor == "0-0" {
+		s.lastClaim = time.Now()
+		return s.deleteIdleConsumers(ctx)
+	}
+	return nil
+}
+
+// deleteIdleConsumers removes the consumers of crashed instances once their pending messages are claimed. A live
+// consumer reads at least every block interval, so it is never idle for claim-min-idle.
+func (s *Subscriber) deleteIdleConsumers(ctx context.Context) error {
+	consumers, err := s.rdb.XInfoConsumers(ctx, s.stream, s.group).Result()
+	if err != nil {
+		return errors.Wrapf(err, "listing consumers of stream %s", s.stream)
+	}
+
+	for _, consumer := range consumers {
+		if consumer.Name == s.consumer || consumer.Pending > 0 || consumer.Idle < s.claimMinIdle {
+			continue
+		}
+		if err := s.deleteConsumer(ctx, consumer.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Subscriber) deleteConsumer(ctx context.Context, consumer string) error {
+	err := deleteIdleConsumer.Run(ctx, s.rdb, []string{s.stream}, s.group, consumer).Err()
+	return errors.Wrapf(err, "deleting consumer %s of stream %s", consumer, s.stream)
+}
+
+// Close removes the consumer from the group. A consumer with pending messages is kept, the messages are claimed by
+// another consumer, which then removes it.
+func (s *Subscriber) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	return s.deleteConsumer(ctx, s.consumer)
+}
+
+type delivery struct {
+	rdb     *redis.Client
+	stream  string
+	group   string
+	message redis.XMessage
+}
+
+func (d *delivery) Message() broker.Message {
+	m := broker.Message{
+		Topic:   d.stream,
+		Headers: make(map[string]string),
+		Time:    idTime(d.message.ID),
+	}
+	for field, value := range d.message.Values {
+		s, _ := value.(string)
+		switch {
+		case field == keyField:
+			m.Key = []byte(s)
+		case field == valueField:
+			m.Value = []byte(s)
+		case strings.HasPrefix(field, headerPrefix):
+			m.Headers[strings.TrimPrefix(field, headerPrefix)] = s
+		}
+	}
+	return m
+}
+
+func (d *delivery) Ack(ctx context.Context) error {
+	return d.rdb.XAck(ctx, d.stream, d.group, d.message.ID).Err()
+}
+
+// Nack leaves the message pending, it is claimed again once it is idle for claim-min-idle.
+func (d *delivery) Nack(context.Context) error {
+	return nil
+}
+
+// idTime returns the time encoded in the millisecond part of a stream entry ID.
+func idTime(id string) time.Time {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
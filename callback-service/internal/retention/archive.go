@@ -0,0 +1,96 @@
+package retention
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"callback-service/internal/db"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Record is an archived callback, one JSON object per line of an archive file.
+type Record struct {
+	ID               uuid.UUID       `json:"id"`
+	PaymentID        uuid.UUID       `json:"paymentId"`
+	Outcome          string          `json:"outcome"`
+	Url              string          `json:"url"`
+	Payload          json.RawMessage `json:"payload"`
+	Sequence         int64           `json:"sequence"`
+	DeliveryAttempts int             `json:"deliveryAttempts"`
+	PublishAttempts  int             `json:"publishAttempts"`
+	Error            *string         `json:"error,omitempty"`
+	SupersededBy     *uuid.UUID      `json:"supersededBy,omitempty"`
+	CorrelationID    string          `json:"correlationId,omitempty"`
+	TraceID          string          `json:"traceId,omitempty"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
+	DeliveredAt      *time.Time      `json:"deliveredAt,omitempty"`
+}
+
+func toRecord(entity *db.CallbackMessageEntity, outcome string) Record {
+	return Record{
+		ID:               entity.ID,
+		PaymentID:        entity.PaymentID,
+		Outcome:          outcome,
+		Url:              entity.Url,
+		Payload:          json.RawMessage(entity.Payload),
+		Sequence:         entity.Sequence,
+		DeliveryAttempts: entity.DeliveryAttempts,
+		PublishAttempts:  entity.PublishAttempts,
+		Error:            entity.Error,
+		SupersededBy:     entity.SupersededBy,
+		CorrelationID:    entity.CorrelationID,
+		TraceID:          entity.TraceID,
+		CreatedAt:        entity.CreatedAt,
+		UpdatedAt:        entity.UpdatedAt,
+		DeliveredAt:      entity.DeliveredAt,
+	}
+}
+
+// Archive writes records as gzip compressed NDJSON to one file per UTC day, named callback_message-YYYY-MM-DD.ndjson.gz.
+// Every write appends a complete gzip member to the file of its day, so a file stays readable if the service stops
+// between writes; gzip readers decompress concatenated members as one stream.
+type Archive struct {
+	dir string
+}
+
+func NewArchive(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "creating archive directory")
+	}
+	return &Archive{dir: dir}, nil
+}
+
+// Path returns the file the records of the given day are written to.
+func (a *Archive) Path(day time.Time) string {
+	return filepath.Join(a.dir, "callback_message-"+day.UTC().Format(time.DateOnly)+".ndjson.gz")
+}
+
+// Write appends the records to the file of the given day and syncs it to disk.
+func (a *Archive) Write(day time.Time, records []Record) (err error) {
+	f, err := os.OpenFile(a.Path(day), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "opening archive file")
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "closing archive file")
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	encoder := json.NewEncoder(gz)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return errors.Wrap(err, "writing archive record")
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "compressing archive records")
+	}
+	return errors.Wrap(f.Sync(), "syncing archive file")
+}
@@ -0,0 +1,62 @@
+package retention
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"callback-service/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readArchive(t *testing.T, path string) []Record {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	var records []Record
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestArchive_Write(t *testing.T) {
+	archive, err := NewArchive(t.TempDir())
+	require.NoError(t, err)
+
+	day := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	deliveredAt := day.Add(-time.Hour)
+	delivered := &db.CallbackMessageEntity{ID: uuid.New(), PaymentID: uuid.New(), Url: "http://merchant/callback", Payload: `{"status":"successful"}`, DeliveredAt: &deliveredAt}
+	failed := &db.CallbackMessageEntity{ID: uuid.New(), PaymentID: uuid.New(), Url: "http://merchant/callback", Payload: `{"status":"failed"}`}
+
+	require.NoError(t, archive.Write(day, []Record{toRecord(delivered, OutcomeDelivered)}))
+	require.NoError(t, archive.Write(day.Add(30*time.Minute), []Record{toRecord(failed, OutcomeFailed)}))
+	require.NoError(t, archive.Write(day.Add(2*time.Hour), []Record{toRecord(delivered, OutcomeDelivered)}))
+
+	assert.Equal(t, filepath.Join(archive.dir, "callback_message-2024-05-01.ndjson.gz"), archive.Path(day))
+
+	records := readArchive(t, archive.Path(day))
+	require.Len(t, records, 2, "writes of the same day are appended to one file")
+	assert.Equal(t, delivered.ID, records[0].ID)
+	assert.Equal(t, OutcomeDelivered, records[0].Outcome)
+	assert.JSONEq(t, delivered.Payload, string(records[0].Payload))
+	assert.True(t, deliveredAt.Equal(*records[0].DeliveredAt))
+	assert.Equal(t, failed.ID, records[1].ID)
+	assert.Equal(t, OutcomeFailed, records[1].Outcome)
+
+	assert.Len(t, readArchive(t, archive.Path(day.Add(2*time.Hour))), 1, "the next day is written to a new file")
+}
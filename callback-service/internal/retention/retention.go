@@ -0,0 +1,184 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"callback-service/internal/config"
+	"callback-service/internal/db"
+	"callback-service/internal/logging"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	OutcomeDelivered = "delivered"
+	OutcomeFailed    = "failed"
+)
+
+var (
+	runSuccessCounter = metrics.GetOrCreateCounter(`callback_retention_total{result="success"}`)
+	runFailedCounter  = metrics.GetOrCreateCounter(`callback_retention_total{result="failed"}`)
+
+	auditPurgedCounter = metrics.GetOrCreateCounter(`callback_retention_audit_rows_total{result="purged"}`)
+)
+
+func rowsCounter(outcome, result string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`callback_retention_rows_total{outcome=%q,result=%q}`, outcome, result))
+}
+
+type deleteFunc func(ctx context.Context, tx db.Tx, before time.Time, limit int) ([]*db.CallbackMessageEntity, error)
+
+type policy struct {
+	outcome   string
+	retention time.Duration
+	delete    deleteFunc
+}
+
+// Job purges delivered and permanently failed callbacks once their retention period has passed, optionally archiving
+// them first, and the audit of changes older than the audit retention period. Callbacks are deleted in batches, each in its own short transaction, so the job neither holds locks
+// for long nor waits for rows the producer or the processors are working on.
+type Job struct {
+	repo           db.Repository
+	archive        *Archive
+	policies       []policy
+	auditRetention time.Duration
+	interval       time.Duration
+	batchSize      int
+	logger         *slog.Logger
+}
+
+// NewJob creates the retention job. A retention period of zero keeps callbacks of that outcome, or the audit, forever. Without an
+// archive callbacks are deleted without being archived.
+func NewJob(repo db.Repository, archive *Archive, cfg config.CallbackRetention, logger *slog.Logger) *Job {
+	j := &Job{
+		repo:           repo,
+		archive:        archive,
+		auditRetention: time.Duration(cfg.AuditHours) * time.Hour,
+		interval:       time.Duration(cfg.IntervalMs) * time.Millisecond,
+		batchSize:      cfg.BatchSize,
+		logger:         logger.With("component", "retention.job"),
+	}
+	if cfg.DeliveredHours > 0 {
+		j.policies = append(j.policies, policy{outcome: OutcomeDelivered, retention: time.Duration(cfg.DeliveredHours) * time.Hour, delete: repo.DeleteDelivered})
+	}
+	if cfg.FailedHours > 0 {
+		j.policies = append(j.policies, policy{outcome: OutcomeFailed, retention: time.Duration(cfg.FailedHours) * time.Hour, delete: repo.DeleteFailed})
+	}
+	return j
+}
+
+func (j *Job) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.Run(ctx)
+		case <-ctx.Done():
+			j.logger.InfoContext(ctx, "Context done, stopping retention job")
+			return
+		}
+	}
+}
+
+// Run purges the expired callbacks of every outcome and then the expired audit, batch by batch until none are left.
+func (j *Job) Run(ctx context.Context) {
+	ctx = db.WithActor(ctx, "retention.job")
+	ctx = logging.AppendCtx(ctx, slog.String("correlationId", uuid.New().String()))
+
+	for _, p := range j.policies {
+		before := j.repo.Now().Add(-p.retention)
+
+		purged := 0
+		for ctx.Err() == nil {
+			n, err := j.purgeBatch(ctx, p, before)
+			if err != nil {
+				j.logger.ErrorContext(ctx, fmt.Sprintf("Error purging %s callbacks: %v", p.outcome, err))
+				runFailedCounter.Inc()
+				return
+			}
+			purged += n
+			if n < j.batchSize {
+				break
+			}
+		}
+
+		if purged > 0 {
+			j.logger.InfoContext(ctx, fmt.Sprintf("Purged %d %s callbacks older than %v", purged, p.outcome, p.retention))
+		}
+	}
+
+	if j.auditRetention > 0 {
+		if err := j.purgeAudit(ctx, j.repo.Now().Add(-j.auditRetention)); err != nil {
+			j.logger.ErrorContext(ctx, fmt.Sprintf("Error purging callback audit: %v", err))
+			runFailedCounter.Inc()
+			return
+		}
+	}
+
+	runSuccessCounter.Inc()
+}
+
+// purgeAudit deletes the audit of changes made before the given time. The audit is kept after its callbacks are
+// purged, so it outlives them by up to the audit retention period.
+func (j *Job) purgeAudit(ctx context.Context, before time.Time) error {
+	purged := 0
+	for ctx.Err() == nil {
+		n, err := j.repo.PurgeAudit(ctx, before, j.batchSize)
+		if err != nil {
+			return err
+		}
+		auditPurgedCounter.Add(n)
+		purged += n
+		if n < j.batchSize {
+			break
+		}
+	}
+
+	if purged > 0 {
+		j.logger.InfoContext(ctx, fmt.Sprintf("Purged %d audit changes older than %v", purged, j.auditRetention))
+	}
+	return nil
+}
+
+// purgeBatch deletes one batch and archives it before committing, so callbacks are only deleted once archived. If the
+// commit fails the batch is archived again by the next run.
+func (j *Job) purgeBatch(ctx context.Context, p policy, before time.Time) (int, error) {
+	tx, err := j.repo.BeginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	callbacks, err := p.delete(ctx, tx, before, j.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(callbacks) == 0 {
+		return 0, nil
+	}
+
+	if j.archive != nil {
+		records := make([]Record, len(callbacks))
+		for i, callback := range callbacks {
+			records[i] = toRecord(callback, p.outcome)
+		}
+		if err := j.archive.Write(j.repo.Now(), records); err != nil {
+			rowsCounter(p.outcome, "archive_failed").Add(len(callbacks))
+			return 0, err
+		}
+		rowsCounter(p.outcome, "archived").Add(len(callbacks))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.Wrap(err, "committing transaction")
+	}
+	rowsCounter(p.outcome, "purged").Add(len(callbacks))
+
+	return len(callbacks), nil
+}
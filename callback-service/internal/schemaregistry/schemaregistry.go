@@ -0,0 +1,207 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"callback-service/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	TypeAvro     = "AVRO"
+	TypeProtobuf = "PROTOBUF"
+
+	contentType = "application/vnd.schemaregistry.v1+json"
+
+	// errorCodeSubjectNotFound is returned when checking the compatibility with a subject without versions.
+	errorCodeSubjectNotFound = 40401
+)
+
+type Schema struct {
+	Type   string
+	Schema string
+}
+
+// Error is an error response of the registry.
+type Error struct {
+	Status  int    `json:"-"`
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("schema registry error %d: %s", e.Code, e.Message)
+}
+
+// Client is a client of the Confluent Schema Registry REST API. Schemas and IDs never change once registered, so they
+// are cached.
+type Client struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+
+	mu      sync.Mutex
+	schemas map[int]Schema
+	ids     map[string]int
+}
+
+func NewClient(cfg config.SchemaRegistry) *Client {
+	return &Client{
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   &http.Client{Timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond},
+		schemas:  make(map[int]Schema),
+		ids:      make(map[string]int),
+	}
+}
+
+type schemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+type schemaResponse struct {
+	ID         int    `json:"id"`
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+// Register registers the schema under the subject and returns its ID. Registering a schema that is already registered
+// returns the existing ID.
+func (c *Client) Register(ctx context.Context, subject string, schema Schema) (int, error) {
+	return c.id(ctx, "/subjects/"+url.PathEscape(subject)+"/versions", subject, schema)
+}
+
+// Lookup returns the ID of a schema that is already registered under the subject.
+func (c *Client) Lookup(ctx context.Context, subject string, schema Schema) (int, error) {
+	return c.id(ctx, "/subjects/"+url.PathEscape(subject), subject, schema)
+}
+
+func (c *Client) id(ctx context.Context, path, subject string, schema Schema) (int, error) {
+	key := subject + "\x00" + schema.Type + "\x00" + schema.Schema
+
+	c.mu.Lock()
+	id, ok := c.ids[key]
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	var resp schemaResponse
+	if err := c.do(ctx, http.MethodPost, path, toRequest(schema), &resp); err != nil {
+		return 0, errors.Wrapf(err, "registering schema for subject %s", subject)
+	}
+
+	c.mu.Lock()
+	c.ids[key] = resp.ID
+	c.schemas[resp.ID] = schema
+	c.mu.Unlock()
+
+	return resp.ID, nil
+}
+
+// SchemaByID returns the schema with the ID.
+func (c *Client) SchemaByID(ctx context.Context, id int) (Schema, error) {
+	c.mu.Lock()
+	schema, ok := c.schemas[id]
+	c.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	var resp schemaResponse
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &resp); err != nil {
+		return Schema{}, errors.Wrapf(err, "fetching schema %d", id)
+	}
+
+	// The schema type is omitted for Avro schemas.
+	schema = Schema{Type: resp.SchemaType, Schema: resp.Schema}
+	if schema.Type == "" {
+		schema.Type = TypeAvro
+	}
+
+	c.mu.Lock()
+	c.schemas[id] = schema
+	c.mu.Unlock()
+
+	return schema, nil
+}
+
+// CheckCompatibility checks the schema against the latest version of the subject with the compatibility level of the
+// subject. Any schema is compatible with a subject without versions. The messages explain incompatibilities.
+func (c *Client) CheckCompatibility(ctx context.Context, subject string, schema Schema) (bool, []string, error) {
+	var resp struct {
+		IsCompatible bool     `json:"is_compatible"`
+		Messages     []string `json:"messages"`
+	}
+	path := "/compatibility/subjects/" + url.PathEscape(subject) + "/versions/latest?verbose=true"
+
+	err := c.do(ctx, http.MethodPost, path, toRequest(schema), &resp)
+	var registryErr *Error
+	if errors.As(err, &registryErr) && registryErr.Code == errorCodeSubjectNotFound {
+		return true, nil, nil
+	}
+	if err != nil {
+		return false, nil, errors.Wrapf(err, "checking compatibility for subject %s", subject)
+	}
+
+	return resp.IsCompatible, resp.Messages, nil
+}
+
+func toRequest(schema Schema) schemaRequest {
+	req := schemaRequest{Schema: schema.Schema}
+	if schema.Type != TypeAvro {
+		req.SchemaType = schema.Type
+	}
+	return req
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, result any) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return errors.Wrap(err, "encoding request")
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, &reqBody)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sending request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		registryErr := &Error{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(registryErr); err != nil || registryErr.Message == "" {
+			registryErr.Message = resp.Status
+		}
+		return registryErr
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.Wrap(err, "decoding response")
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package schemaregistry_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"callback-service/internal/config"
+	"callback-service/internal/schemaregistry"
+	"callback-service/internal/schemaregistry/schemaregistrytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	schemaV1 = `{"type":"record","name":"Callback","fields":[{"name":"id","type":"string"}]}`
+	// schemaV2 adds a field with a default, which is backward compatible.
+	schemaV2 = `{"type":"record","name":"Callback","fields":[{"name":"id","type":"string"},{"name":"url","type":"string","default":""}]}`
+	// schemaV3 adds a field without a default, which is not backward compatible.
+	schemaV3 = `{"type":"record","name":"Callback","fields":[{"name":"id","type":"string"},{"name":"attempts","type":"int"}]}`
+)
+
+func newServer(t *testing.T) *schemaregistrytest.Server {
+	server := schemaregistrytest.NewServer()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newClient(url string) *schemaregistry.Client {
+	return schemaregistry.NewClient(config.SchemaRegistry{URL: url, TimeoutMs: 1000})
+}
+
+func TestClient_RegisterAndFetch(t *testing.T) {
+	ctx := context.Background()
+	server := newServer(t)
+	client := newClient(server.URL)
+
+	avroSchema := schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: schemaV1}
+	id, err := client.Register(ctx, "callbacks-value", avroSchema)
+	require.NoError(t, err)
+
+	again, err := client.Register(ctx, "callbacks-value", avroSchema)
+	require.NoError(t, err)
+	assert.Equal(t, id, again)
+
+	protobufSchema := schemaregistry.Schema{Type: schemaregistry.TypeProtobuf, Schema: `syntax = "proto3"; message Callback { string id = 1; }`}
+	protobufID, err := client.Register(ctx, "events-value", protobufSchema)
+	require.NoError(t, err)
+	assert.NotEqual(t, id, protobufID)
+
+	// A new client has no schemas cached and fetches them from the registry.
+	fetchingClient := newClient(server.URL)
+
+	looked, err := fetchingClient.Lookup(ctx, "callbacks-value", avroSchema)
+	require.NoError(t, err)
+	assert.Equal(t, id, looked)
+
+	schema, err := fetchingClient.SchemaByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, avroSchema, schema)
+
+	schema, err = fetchingClient.SchemaByID(ctx, protobufID)
+	require.NoError(t, err)
+	assert.Equal(t, protobufSchema, schema)
+}
+
+func TestClient_Errors(t *testing.T) {
+	ctx := context.Background()
+	client := newClient(newServer(t).URL)
+
+	_, err := client.Lookup(ctx, "callbacks-value", schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: schemaV1})
+	var registryErr *schemaregistry.Error
+	require.ErrorAs(t, err, &registryErr)
+	assert.Equal(t, http.StatusNotFound, registryErr.Status)
+	assert.Equal(t, 40401, registryErr.Code)
+
+	_, err = client.SchemaByID(ctx, 42)
+	require.ErrorAs(t, err, &registryErr)
+	assert.Equal(t, 40403, registryErr.Code)
+}
+
+func TestClient_CheckCompatibility(t *testing.T) {
+	ctx := context.Background()
+	client := newClient(newServer(t).URL)
+
+	compatible, _, err := client.CheckCompatibility(ctx, "callbacks-value", schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: schemaV1})
+	require.NoError(t, err)
+	assert.True(t, compatible, "any schema is compatible with a new subject")
+
+	_, err = client.Register(ctx, "callbacks-value", schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: schemaV1})
+	require.NoError(t, err)
+
+	compatible, _, err = client.CheckCompatibility(ctx, "callbacks-value", schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: schemaV2})
+	require.NoError(t, err)
+	assert.True(t, compatible)
+
+	compatible, messages, err := client.CheckCompatibility(ctx, "callbacks-value", schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: schemaV3})
+	require.NoError(t, err)
+	assert.False(t, compatible)
+	assert.NotEmpty(t, messages)
+
+	_, err = client.Register(ctx, "callbacks-value", schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: schemaV3})
+	var registryErr *schemaregistry.Error
+	require.ErrorAs(t, err, &registryErr)
+	assert.Equal(t, http.StatusConflict, registryErr.Status)
+}
+
+func TestClient_SendsCredentials(t *testing.T) {
+	var username, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ = r.BasicAuth()
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	defer server.Close()
+
+	client := schemaregistry.NewClient(config.SchemaRegistry{URL: server.URL, Username: "user", Password: "secret", TimeoutMs: 1000})
+	_, err := client.Register(context.Background(), "callbacks-value", schemaregistry.Schema{Type: schemaregistry.TypeAvro, Schema: schemaV1})
+	require.NoError(t, err)
+
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "secret", password)
+}
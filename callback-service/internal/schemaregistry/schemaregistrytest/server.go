@@ -0,0 +1,182 @@
+package schemaregistrytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"callback-service/internal/schemaregistry"
+	"github.com/hamba/avro/v2"
+)
+
+// Server is a local stand-in for the schema registry implementing the endpoints used by the client. Subjects use the
+// backward compatibility level, which is only checked for Avro schemas.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	schemas  []schemaregistry.Schema
+	subjects map[string][]int
+}
+
+func NewServer() *Server {
+	s := &Server{subjects: make(map[string][]int)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /subjects/{subject}/versions", s.register)
+	mux.HandleFunc("POST /subjects/{subject}", s.lookup)
+	mux.HandleFunc("GET /schemas/ids/{id}", s.schemaByID)
+	mux.HandleFunc("POST /compatibility/subjects/{subject}/versions/latest", s.compatibility)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+type schemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+func decodeSchema(r *http.Request) (schemaregistry.Schema, error) {
+	var req schemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return schemaregistry.Schema{}, err
+	}
+	if req.SchemaType == "" {
+		req.SchemaType = schemaregistry.TypeAvro
+	}
+	return schemaregistry.Schema{Type: req.SchemaType, Schema: req.Schema}, nil
+}
+
+func (s *Server) register(w http.ResponseWriter, r *http.Request) {
+	schema, err := decodeSchema(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subject := r.PathValue("subject")
+	if id, ok := s.find(subject, schema); ok {
+		writeJSON(w, map[string]int{"id": id})
+		return
+	}
+	if versions := s.subjects[subject]; len(versions) > 0 {
+		if compatible(schema, s.schemas[versions[len(versions)-1]-1]) != nil {
+			writeError(w, http.StatusConflict, 409, "schema being registered is incompatible with an earlier schema")
+			return
+		}
+	}
+
+	s.schemas = append(s.schemas, schema)
+	id := len(s.schemas)
+	s.subjects[subject] = append(s.subjects[subject], id)
+	writeJSON(w, map[string]int{"id": id})
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) {
+	schema, err := decodeSchema(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subject := r.PathValue("subject")
+	if _, ok := s.subjects[subject]; !ok {
+		writeError(w, http.StatusNotFound, 40401, "subject not found")
+		return
+	}
+	id, ok := s.find(subject, schema)
+	if !ok {
+		writeError(w, http.StatusNotFound, 40403, "schema not found")
+		return
+	}
+	writeJSON(w, map[string]any{"subject": subject, "id": id, "schema": schema.Schema})
+}
+
+func (s *Server) schemaByID(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 || id > len(s.schemas) {
+		writeError(w, http.StatusNotFound, 40403, "schema not found")
+		return
+	}
+
+	resp := map[string]any{"schema": s.schemas[id-1].Schema}
+	if s.schemas[id-1].Type != schemaregistry.TypeAvro {
+		resp["schemaType"] = s.schemas[id-1].Type
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) compatibility(w http.ResponseWriter, r *http.Request) {
+	schema, err := decodeSchema(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.subjects[r.PathValue("subject")]
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, 40401, "subject not found")
+		return
+	}
+
+	resp := map[string]any{"is_compatible": true}
+	if err := compatible(schema, s.schemas[versions[len(versions)-1]-1]); err != nil {
+		resp = map[string]any{"is_compatible": false, "messages": []string{err.Error()}}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) find(subject string, schema schemaregistry.Schema) (int, bool) {
+	for _, id := range s.subjects[subject] {
+		if s.schemas[id-1] == schema {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// compatible checks that data written with the latest schema can be read with the new one.
+func compatible(schema, latest schemaregistry.Schema) error {
+	if schema.Type != latest.Type {
+		return &schemaregistry.Error{Code: 409, Message: "schema type changed"}
+	}
+	if schema.Type != schemaregistry.TypeAvro {
+		return nil
+	}
+
+	reader, err := avro.ParseWithCache(schema.Schema, "", &avro.SchemaCache{})
+	if err != nil {
+		return err
+	}
+	writer, err := avro.ParseWithCache(latest.Schema, "", &avro.SchemaCache{})
+	if err != nil {
+		return err
+	}
+	return avro.NewSchemaCompatibility().Compatible(reader, writer)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error_code": code, "message": message})
+}
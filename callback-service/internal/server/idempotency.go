@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const maxIdempotencyKeyLength = 255
+
+type idempotencyState int
+
+const (
+	idempotencyStarted idempotencyState = iota
+	idempotencyReplay
+	idempotencyInProgress
+	idempotencyMismatch
+)
+
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	done        bool
+	status      int
+	body        []byte
+	expiresAt   time.Time
+}
+
+// idempotencyStore remembers the responses to requests with an Idempotency-Key header, so a retried request gets the
+// same response without being processed again. Entries live in memory for the TTL, so they are neither shared between
+// instances nor kept across restarts; the event ID still prevents duplicate callbacks in those cases.
+type idempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// begin registers a request for the key. It returns the stored entry for a completed request with the same body, and
+// reports requests that are still in progress or reuse the key with a different body.
+func (s *idempotencyStore) begin(key string, body []byte, now time.Time) (*idempotencyEntry, idempotencyState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	fingerprint := sha256.Sum256(body)
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		switch {
+		case entry.fingerprint != fingerprint:
+			return nil, idempotencyMismatch
+		case !entry.done:
+			return nil, idempotencyInProgress
+		default:
+			return entry, idempotencyReplay
+		}
+	}
+
+	s.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expiresAt: now.Add(s.ttl)}
+	return nil, idempotencyStarted
+}
+
+func (s *idempotencyStore) complete(key string, status int, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		entry.done = true
+		entry.status = status
+		entry.body = body
+	}
+}
+
+// release forgets the key, so the request can be retried with it, e.g. after a processing failure.
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+func (s *idempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
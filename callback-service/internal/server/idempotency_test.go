@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	store := newIdempotencyStore(time.Hour)
+	now := time.Now()
+
+	_, state := store.begin("key", []byte("body"), now)
+	assert.Equal(t, idempotencyStarted, state)
+
+	_, state = store.begin("key", []byte("body"), now)
+	assert.Equal(t, idempotencyInProgress, state)
+
+	store.complete("key", 201, []byte("response"))
+
+	entry, state := store.begin("key", []byte("body"), now)
+	assert.Equal(t, idempotencyReplay, state)
+	assert.Equal(t, 201, entry.status)
+	assert.Equal(t, []byte("response"), entry.body)
+
+	_, state = store.begin("key", []byte("other body"), now)
+	assert.Equal(t, idempotencyMismatch, state)
+}
+
+func TestIdempotencyStore_Release(t *testing.T) {
+	store := newIdempotencyStore(time.Hour)
+	now := time.Now()
+
+	store.begin("key", []byte("body"), now)
+	store.release("key")
+
+	_, state := store.begin("key", []byte("body"), now)
+	assert.Equal(t, idempotencyStarted, state)
+}
+
+func TestIdempotencyStore_Expiry(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	now := time.Now()
+
+	store.begin("key", []byte("body"), now)
+	store.complete("key", 201, []byte("response"))
+
+	_, state := store.begin("key", []byte("other body"), now.Add(2*time.Minute))
+	assert.Equal(t, idempotencyStarted, state)
+}
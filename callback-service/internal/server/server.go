@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"callback-service/internal/config"
+	"callback-service/internal/event"
+	"callback-service/internal/logging"
+	"callback-service/internal/message"
+	"callback-service/internal/tracing"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/pkg/errors"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// Per event results in addition to the results of event.Processor.
+const (
+	resultInvalid = "invalid"
+	resultFailed  = "failed"
+)
+
+var (
+	eventCreatedCounter   = metrics.GetOrCreateCounter(`http_events_total{result="created"}`)
+	eventDuplicateCounter = metrics.GetOrCreateCounter(`http_events_total{result="duplicate"}`)
+	eventDroppedCounter   = metrics.GetOrCreateCounter(`http_events_total{result="dropped"}`)
+	eventInvalidCounter   = metrics.GetOrCreateCounter(`http_events_total{result="invalid"}`)
+	eventFailedCounter    = metrics.GetOrCreateCounter(`http_events_total{result="failed"}`)
+
+	requestReplayedCounter = metrics.GetOrCreateCounter(`http_events_requests_total{result="replayed"}`)
+	requestConflictCounter = metrics.GetOrCreateCounter(`http_events_requests_total{result="idempotency_conflict"}`)
+	requestTooLargeCounter = metrics.GetOrCreateCounter(`http_events_requests_total{result="too_large"}`)
+)
+
+type eventResult struct {
+	Index  int                `json:"index"`
+	ID     string             `json:"id,omitempty"`
+	Result string             `json:"result"`
+	Error  string             `json:"error,omitempty"`
+	Errors []event.FieldError `json:"errors,omitempty"`
+}
+
+type batchResponse struct {
+	Results []eventResult `json:"results"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server ingests payment events over HTTP, as an alternative to consuming the payment-events topic.
+type Server struct {
+	server       *http.Server
+	processor    *event.Processor
+	maxBodyBytes int64
+	maxBatchSize int
+	idempotency  *idempotencyStore
+	logger       *slog.Logger
+}
+
+func NewServer(cfg config.Server, processor *event.Processor, logger *slog.Logger) *Server {
+	s := &Server{
+		processor:    processor,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		maxBatchSize: cfg.MaxBatchSize,
+		idempotency:  newIdempotencyStore(time.Duration(cfg.IdempotencyTTLMs) * time.Millisecond),
+		logger:       logger.With("component", "server"),
+	}
+
+	s.server = &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/events", s.handleEvents)
+	return mux
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+func (s *Server) Start(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		s.server.Shutdown(shutdownCtx)
+	}()
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("Listening on %s", s.server.Addr))
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("Error serving HTTP: %v", err))
+	}
+}
+
+// handleEvents processes a single event or a JSON array of events synchronously, so every event reported as created
+// has its callback stored. Responses to requests with an Idempotency-Key are replayed for retries with the same key
+// and body, unless processing failed and the request should be retried.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := contextFromHeaders(r.Context(), r.Header)
+
+	body, err := s.readBody(w, r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			requestTooLargeCounter.Inc()
+			writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: fmt.Sprintf("body exceeds %d bytes", maxBytesErr.Limit)})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "reading body failed"})
+		return
+	}
+
+	key := r.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("%s exceeds %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)})
+		return
+	}
+
+	if key != "" {
+		entry, state := s.idempotency.begin(key, body, time.Now())
+		switch state {
+		case idempotencyReplay:
+			s.logger.InfoContext(ctx, fmt.Sprintf("Replaying response for idempotency key %s", key))
+			requestReplayedCounter.Inc()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		case idempotencyInProgress:
+			requestConflictCounter.Inc()
+			writeJSON(w, http.StatusConflict, errorResponse{Error: "a request with this idempotency key is in progress"})
+			return
+		case idempotencyMismatch:
+			requestConflictCounter.Inc()
+			writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: "idempotency key was used with a different body"})
+			return
+		}
+	}
+
+	status, response, retryable := s.process(ctx, body)
+
+	responseBody, _ := json.Marshal(response)
+	if key != "" {
+		if retryable {
+			s.idempotency.release(key)
+		} else {
+			s.idempotency.complete(key, status, responseBody)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseBody)
+}
+
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	reader := r.Body
+	if s.maxBodyBytes > 0 {
+		reader = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	}
+	return io.ReadAll(reader)
+}
+
+// process returns the status and body of the response, and whether the request failed in a way that a retry can fix.
+func (s *Server) process(ctx context.Context, body []byte) (int, any, bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		result := s.processEvent(ctx, 0, body)
+		return singleStatus(result.Result), result, result.Result == resultFailed
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(body, &events); err != nil {
+		return http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid batch: %v", err)}, false
+	}
+	if len(events) == 0 {
+		return http.StatusBadRequest, errorResponse{Error: "empty batch"}, false
+	}
+	if s.maxBatchSize > 0 && len(events) > s.maxBatchSize {
+		requestTooLargeCounter.Inc()
+		return http.StatusRequestEntityTooLarge, errorResponse{Error: fmt.Sprintf("batch exceeds %d events", s.maxBatchSize)}, false
+	}
+
+	response := batchResponse{Results: make([]eventResult, 0, len(events))}
+	retryable := false
+	for i, raw := range events {
+		result := s.processEvent(ctx, i, raw)
+		retryable = retryable || result.Result == resultFailed
+		response.Results = append(response.Results, result)
+	}
+	return http.StatusOK, response, retryable
+}
+
+func (s *Server) processEvent(ctx context.Context, index int, raw []byte) eventResult {
+	var e message.PaymentEvent
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&e); err != nil {
+		return s.invalid(ctx, index, "", err)
+	}
+
+	result, err := s.processor.Process(ctx, e)
+	var validationErr *event.ValidationError
+	if errors.As(err, &validationErr) {
+		invalid := s.invalid(ctx, index, e.ID.String(), err)
+		invalid.Errors = validationErr.Errors
+		return invalid
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("Error processing event %s: %v", e.ID, err))
+		eventFailedCounter.Inc()
+		return eventResult{Index: index, ID: e.ID.String(), Result: resultFailed, Error: "processing failed"}
+	}
+
+	switch result {
+	case event.ResultCreated:
+		eventCreatedCounter.Inc()
+	case event.ResultDuplicate:
+		eventDuplicateCounter.Inc()
+	case event.ResultDropped:
+		eventDroppedCounter.Inc()
+	}
+	return eventResult{Index: index, ID: e.ID.String(), Result: string(result)}
+}
+
+func (s *Server) invalid(ctx context.Context, index int, id string, err error) eventResult {
+	s.logger.WarnContext(ctx, fmt.Sprintf("Invalid event at index %d: %v", index, err))
+	eventInvalidCounter.Inc()
+	return eventResult{Index: index, ID: id, Result: resultInvalid, Error: err.Error()}
+}
+
+func singleStatus(result string) int {
+	switch result {
+	case string(event.ResultCreated):
+		return http.StatusCreated
+	case resultInvalid:
+		return http.StatusBadRequest
+	case resultFailed:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusOK
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, response any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// contextFromHeaders continues the correlation ID and trace of the caller, like the consumer does for message headers.
+func contextFromHeaders(ctx context.Context, header http.Header) context.Context {
+	if correlationID := header.Get(message.HeaderCorrelationID); correlationID != "" {
+		ctx = logging.WithCorrelationID(ctx, correlationID)
+	}
+	if tp, err := tracing.ParseTraceParent(header.Get(message.HeaderTraceParent)); err == nil {
+		ctx = tracing.WithTraceParent(ctx, tp)
+		ctx = logging.AppendCtx(ctx, slog.String("traceId", tp.TraceID))
+	}
+	return ctx
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"callback-service/internal/config"
+	"callback-service/internal/event"
+	"callback-service/internal/logging"
+	"callback-service/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer creates a server whose processor has no repository, so only requests with invalid events can be
+// tested.
+func newTestServer() *Server {
+	processor := event.NewProcessor(nil, config.CallbackSupersession{}, config.EventValidation{EventTypes: []string{"created"}}, slog.Default())
+	return NewServer(config.Server{Port: "0", MaxBodyBytes: 1024, MaxBatchSize: 2, IdempotencyTTLMs: 60000}, processor, slog.Default())
+}
+
+func post(s *Server, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/events", strings.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandleEvents_InvalidEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		error string
+	}{
+		{name: "Malformed JSON", body: "{invalid", error: "invalid character"},
+		{name: "Unknown field", body: `{"id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446","amount":1}`, error: "unknown field"},
+		{name: "Missing ID", body: `{"payload":{"id":"e3814f7f-b6ba-4cf8-923b-f7064c8b614c"}}`, error: "id: is required"},
+		{name: "Missing payment ID", body: `{"id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446"}`, error: "payload.id: is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(newTestServer(), tt.body, nil)
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			var result eventResult
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+			assert.Equal(t, resultInvalid, result.Result)
+			assert.Contains(t, result.Error, tt.error)
+		})
+	}
+}
+
+func TestHandleEvents_RejectedEventListsFieldErrors(t *testing.T) {
+	body := `{"id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446","event":"deleted","payload":{"id":"e3814f7f-b6ba-4cf8-923b-f7064c8b614c",` +
+		`"amount":-1,"currency":"EUR","status":"successful","createdAt":"2024-05-01T12:00:00Z","callbackUrl":"http://merchant/callback"}}`
+	rec := post(newTestServer(), body, nil)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var result eventResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []event.FieldError{
+		{Field: "event", Message: `unknown event type "deleted"`},
+		{Field: "payload.amount", Message: "must not be negative, got -1"},
+	}, result.Errors)
+}
+
+func TestHandleEvents_BatchWithInvalidEvents(t *testing.T) {
+	rec := post(newTestServer(), `[{"id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446"}, "not an event"]`, nil)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response batchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.Len(t, response.Results, 2)
+	assert.Equal(t, "99d2aa54-7dc6-487e-a3eb-77a5c6135446", response.Results[0].ID)
+	assert.Equal(t, resultInvalid, response.Results[0].Result)
+	assert.Contains(t, response.Results[0].Errors, event.FieldError{Field: "payload.id", Message: "is required"})
+	assert.Equal(t, 1, response.Results[1].Index)
+	assert.Equal(t, resultInvalid, response.Results[1].Result)
+}
+
+func TestHandleEvents_Limits(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "Body too large", body: `{"id":"` + strings.Repeat("a", 2048) + `"}`, status: http.StatusRequestEntityTooLarge},
+		{name: "Batch too large", body: `[{}, {}, {}]`, status: http.StatusRequestEntityTooLarge},
+		{name: "Empty batch", body: `[]`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(newTestServer(), tt.body, nil)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestHandleEvents_IdempotencyKey(t *testing.T) {
+	s := newTestServer()
+	header := http.Header{idempotencyKeyHeader: []string{"key-1"}}
+
+	first := post(s, `[{"id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446"}]`, header)
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	replayed := post(s, `[{"id":"99d2aa54-7dc6-487e-a3eb-77a5c6135446"}]`, header)
+	assert.Equal(t, http.StatusOK, replayed.Code)
+	assert.Equal(t, first.Body.String(), replayed.Body.String())
+
+	mismatch := post(s, `[{"id":"e3814f7f-b6ba-4cf8-923b-f7064c8b614c"}]`, header)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+
+	tooLong := post(s, `[{}]`, http.Header{idempotencyKeyHeader: []string{strings.Repeat("k", maxIdempotencyKeyLength+1)}})
+	assert.Equal(t, http.StatusBadRequest, tooLong.Code)
+}
+
+func TestHandleEvents_MethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestServer().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/events", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestContextFromHeaders(t *testing.T) {
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	header := http.Header{}
+	header.Set("Correlation-Id", "correlation")
+	header.Set("Traceparent", traceParent)
+
+	ctx := contextFromHeaders(context.Background(), header)
+
+	correlationID, ok := logging.CorrelationID(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "correlation", correlationID)
+
+	tp, ok := tracing.FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, traceParent, tp.String())
+}
@@ -0,0 +1,191 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const fileName = "callback-outcomes.ndjson"
+
+// Record is the outcome of sending a callback that could not be stored in the database.
+type Record struct {
+	ID uuid.UUID `json:"id"`
+	// Attempts is the number of delivery attempts of the callback before it was sent.
+	Attempts  int       `json:"attempts"`
+	SendError *string   `json:"sendError,omitempty"`
+	SentAt    time.Time `json:"sentAt"`
+}
+
+// Spool keeps records in a local NDJSON file until they are applied to the database. Appended records are synced to
+// disk before Append returns, so they survive a crash of the service.
+type Spool struct {
+	path string
+	mu   sync.Mutex
+	// drainMu makes sure only one drain removes records at a time.
+	drainMu sync.Mutex
+}
+
+func New(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "creating spool directory")
+	}
+	return &Spool{path: filepath.Join(dir, fileName)}, nil
+}
+
+// Append adds the record to the spool.
+func (s *Spool) Append(record Record) (err error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "encoding spool record")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "opening spool file")
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "closing spool file")
+		}
+	}()
+
+	// A crash while appending may have left a partial line, which must not swallow this record.
+	complete, err := endsWithNewline(f)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		line = append([]byte{'\n'}, line...)
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "writing spool record")
+	}
+	return errors.Wrap(f.Sync(), "syncing spool file")
+}
+
+func endsWithNewline(f *os.File) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, errors.Wrap(err, "reading spool file")
+	}
+	if info.Size() == 0 {
+		return true, nil
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return false, errors.Wrap(err, "reading spool file")
+	}
+	return last[0] == '\n', nil
+}
+
+// Drain applies the spooled records in the order they were appended and removes the applied ones. It stops at the
+// first record apply fails for, which stays in the spool with all later records, and returns the number of applied
+// records with the error. Records appended while draining are kept for the next drain.
+func (s *Spool) Drain(apply func(Record) error) (int, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	s.mu.Lock()
+	records, err := s.read()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	var applyErr error
+	for _, record := range records {
+		if applyErr = apply(record); applyErr != nil {
+			break
+		}
+		applied++
+	}
+	if applied == 0 {
+		return 0, applyErr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only Drain removes records, so the applied records are still the first ones.
+	records, err = s.read()
+	if err != nil {
+		return 0, err
+	}
+	if err := s.rewrite(records[applied:]); err != nil {
+		return 0, err
+	}
+	return applied, applyErr
+}
+
+// Len returns the number of spooled records.
+func (s *Spool) Len() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.read()
+	return len(records), err
+}
+
+func (s *Spool) read() ([]Record, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "opening spool file")
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A line cut off by a crash while appending, its outcome was never acknowledged.
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, errors.Wrap(scanner.Err(), "reading spool file")
+}
+
+// rewrite replaces the spool file with the records, atomically through a temporary file.
+func (s *Spool) rewrite(records []Record) (err error) {
+	if len(records) == 0 {
+		return errors.Wrap(os.Remove(s.path), "removing spool file")
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "creating spool file")
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "closing spool file")
+		}
+	}()
+
+	encoder := json.NewEncoder(f)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return errors.Wrap(err, "writing spool record")
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Wrap(err, "syncing spool file")
+	}
+	return errors.Wrap(os.Rename(tmp, s.path), "replacing spool file")
+}
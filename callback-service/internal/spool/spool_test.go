@@ -0,0 +1,109 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool_Drain(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	require.NoError(t, err)
+
+	errMsg := "503 Service Unavailable"
+	records := []Record{
+		{ID: uuid.New(), Attempts: 0, SentAt: time.Now().UTC()},
+		{ID: uuid.New(), Attempts: 2, SendError: &errMsg, SentAt: time.Now().UTC()},
+		{ID: uuid.New(), Attempts: 1, SentAt: time.Now().UTC()},
+	}
+	for _, r := range records {
+		require.NoError(t, s.Append(r))
+	}
+
+	var applied []Record
+	n, err := s.Drain(func(r Record) error {
+		if r.ID == records[1].ID {
+			return errors.New("database unavailable")
+		}
+		applied = append(applied, r)
+		return nil
+	})
+	assert.EqualError(t, err, "database unavailable")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, records[:1], applied)
+
+	// The records from the failed one on stay spooled, also for a new spool on the same directory.
+	s, err = New(dir)
+	require.NoError(t, err)
+	remaining, err := s.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 2, remaining)
+
+	applied = nil
+	n, err = s.Drain(func(r Record) error {
+		applied = append(applied, r)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, records[1:], applied)
+
+	_, err = os.Stat(filepath.Join(dir, fileName))
+	assert.True(t, os.IsNotExist(err), "an empty spool removes its file")
+}
+
+func TestSpool_Drain_KeepsRecordsAppendedWhileDraining(t *testing.T) {
+	s, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	first := Record{ID: uuid.New(), SentAt: time.Now().UTC()}
+	appended := Record{ID: uuid.New(), SentAt: time.Now().UTC()}
+	require.NoError(t, s.Append(first))
+
+	n, err := s.Drain(func(Record) error {
+		return s.Append(appended)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	var applied []Record
+	_, err = s.Drain(func(r Record) error {
+		applied = append(applied, r)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Record{appended}, applied)
+}
+
+func TestSpool_SkipsPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	require.NoError(t, err)
+
+	record := Record{ID: uuid.New(), SentAt: time.Now().UTC()}
+	require.NoError(t, s.Append(record))
+
+	f, err := os.OpenFile(filepath.Join(dir, fileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"id":"`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	appended := Record{ID: uuid.New(), SentAt: time.Now().UTC()}
+	require.NoError(t, s.Append(appended))
+
+	var applied []Record
+	_, err = s.Drain(func(r Record) error {
+		applied = append(applied, r)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Record{record, appended}, applied)
+}
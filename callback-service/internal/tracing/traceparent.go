@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type ctxKey string
+
+const (
+	traceParentKey ctxKey = "trace_parent"
+
+	version      = "00"
+	sampledFlags = "01"
+)
+
+// TraceParent is a W3C Trace Context traceparent value, see https://www.w3.org/TR/trace-context/#traceparent-header.
+type TraceParent struct {
+	TraceID string
+	SpanID  string
+	Flags   string
+}
+
+func ParseTraceParent(value string) (TraceParent, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 {
+		return TraceParent{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	tp := TraceParent{TraceID: parts[1], SpanID: parts[2], Flags: parts[3]}
+	if parts[0] != version || !isHex(tp.TraceID, 32) || !isHex(tp.SpanID, 16) || !isHex(tp.Flags, 2) ||
+		strings.Trim(tp.TraceID, "0") == "" || strings.Trim(tp.SpanID, "0") == "" {
+		return TraceParent{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	return tp, nil
+}
+
+// NewTraceParent starts a new span within the given trace. A new trace is started if traceID is not a valid trace ID.
+func NewTraceParent(traceID string) TraceParent {
+	if !isHex(traceID, 32) || strings.Trim(traceID, "0") == "" {
+		traceID = randomHex(16)
+	}
+	return TraceParent{TraceID: traceID, SpanID: randomHex(8), Flags: sampledFlags}
+}
+
+func (t TraceParent) String() string {
+	return fmt.Sprintf("%s-%s-%s-%s", version, t.TraceID, t.SpanID, t.Flags)
+}
+
+func WithTraceParent(ctx context.Context, tp TraceParent) context.Context {
+	return context.WithValue(ctx, traceParentKey, tp)
+}
+
+func FromContext(ctx context.Context) (TraceParent, bool) {
+	tp, ok := ctx.Value(traceParentKey).(TraceParent)
+	return tp, ok
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		expectedError bool
+	}{
+		{name: "Valid", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "Unsupported version", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", expectedError: true},
+		{name: "Zero trace ID", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", expectedError: true},
+		{name: "Upper case", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", expectedError: true},
+		{name: "Malformed", value: "not-a-traceparent", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp, err := ParseTraceParent(tt.value)
+			if tt.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.value, tp.String())
+			}
+		})
+	}
+}
+
+func TestNewTraceParent(t *testing.T) {
+	tp := NewTraceParent("4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tp.TraceID)
+
+	parsed, err := ParseTraceParent(tp.String())
+	assert.NoError(t, err)
+	assert.Equal(t, tp, parsed)
+
+	assert.NotEqual(t, tp.TraceID, NewTraceParent("").TraceID)
+}
@@ -2,57 +2,327 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"log/slog"
+	"os"
 	"time"
 
+	"callback-service/internal/broker"
 	"callback-service/internal/callback"
+	"callback-service/internal/clock"
+	"callback-service/internal/codec"
 	"callback-service/internal/config"
+	"callback-service/internal/consumer"
 	"callback-service/internal/db"
 	"callback-service/internal/event"
+	"callback-service/internal/inprocess"
 	"callback-service/internal/kafka"
 	"callback-service/internal/logging"
 	"callback-service/internal/metrics"
+	"callback-service/internal/nats"
+	"callback-service/internal/partition"
+	"callback-service/internal/redis"
+	"callback-service/internal/retention"
+	"callback-service/internal/schemaregistry"
+	"callback-service/internal/server"
+	"callback-service/internal/spool"
+	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/joho/godotenv/autoload"
 )
 
 func main() {
-	time.Local = time.UTC
-
 	cfg := config.MustLoadConfig("./")
 
 	logger := logging.GetLogger(cfg.Logs)
 	slog.SetDefault(logger)
 
-	dbConnStr := db.GetConnStr(cfg.Database)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:], logger); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	db.RunMigrations(dbConnStr, "migrations")
+	repo, closeRepo := newRepository(cfg, logger)
+	defer closeRepo()
 
-	dbpool, err := db.GetPool(dbConnStr)
+	processor := event.NewProcessor(repo, cfg.Callback.Supersession, cfg.Event.Validation, logger)
+
+	brokers, err := newBrokers(cfg, logger)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer dbpool.Close()
+	defer brokers.close()
 
-	repo := db.NewCallbackRepository(dbpool)
+	registry := schemaregistry.NewClient(cfg.SchemaRegistry)
 
-	processor := event.NewProcessor(repo, logger)
+	paymentEventCodec, err := codec.NewPaymentEventCodec(cfg.Broker.Codec.PaymentEvents, registry, cfg.Broker.Topic.PaymentEvents, cfg.SchemaRegistry.AutoRegister)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	eventReader := kafka.NewReader(cfg.Kafka.Broker.URL, cfg.Kafka.Topic.PaymentEvents, cfg.Kafka.Reader.GroupID)
-	defer eventReader.Close()
+	callbackCodec, err := codec.NewCallbackCodec(cfg.Broker.Codec.CallbackMessages, registry, cfg.Broker.Topic.CallbackMessages, cfg.SchemaRegistry.AutoRegister)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	kafka.ReadPaymentEvents(eventReader, processor, logger)
+	// Without a payment-events subscriber, events are only ingested over HTTP.
+	if brokers.paymentEvents != nil {
+		consumer.ReadPaymentEvents(brokers.paymentEvents, cfg.Broker.Consumer.PaymentEvents, paymentEventCodec, processor, logger)
+	}
 
-	callbackWriter := kafka.NewWriter(cfg.Kafka.Broker.URL, cfg.Kafka.Writer.BatchSize, cfg.Kafka.Writer.BatchTimeoutMs, cfg.Kafka.Topic.CallbackMessages)
-	defer callbackWriter.Close()
+	eventServer := server.NewServer(cfg.Server, processor, logger)
+	go eventServer.Start(context.Background())
 
 	callbackSender := callback.NewSender(cfg.Callback.Sender, logger)
-	callbackProcessor := callback.NewCallbackProcessor(repo, callbackSender, cfg.Callback.Processor, logger)
+	var outcomes *spool.Spool
+	if cfg.Callback.Spool.Enabled {
+		outcomes, err = spool.New(cfg.Callback.Spool.Dir)
+		if err != nil {
+			log.Fatalf("Failed to create outcome spool: %v", err)
+		}
+		logger.Info(fmt.Sprintf("Spooling outcomes the database can not store to %s", cfg.Callback.Spool.Dir))
+	}
 
-	kafka.ReadCallbackMessages(kafka.NewReader(cfg.Kafka.Broker.URL, cfg.Kafka.Topic.CallbackMessages, cfg.Kafka.Reader.GroupID), callbackProcessor, logger)
+	callbackProcessor := callback.NewCallbackProcessor(repo, callbackSender, cfg.Callback.Processor, db.NewRetryPolicy(cfg.Database.Retry),
+		outcomes, logger)
+
+	if outcomes != nil {
+		// Outcomes spooled before a restart are stored before new callbacks are delivered.
+		reconciler := callback.NewReconciler(callbackProcessor, cfg.Callback.Spool.ReconcileIntervalMs, logger)
+		reconciler.Run(context.Background())
+		go reconciler.Start(context.Background())
+	}
+
+	consumer.ReadCallbackMessages(brokers.callbackMessages, cfg.Broker.Consumer.CallbackMessages, callbackCodec, callbackProcessor, logger)
 
 	metrics.Setup(cfg.Metrics)
 
-	callbackProducer := callback.NewProducer(repo, callbackWriter, cfg.Callback.Producer, logger)
+	callbackReaper := callback.NewReaper(repo, cfg.Callback.Reaper, logger)
+	go callbackReaper.Start(context.Background())
+
+	if cfg.Callback.Retention.Enabled {
+		var archive *retention.Archive
+		if cfg.Callback.Retention.Archive.Enabled {
+			archive, err = retention.NewArchive(cfg.Callback.Retention.Archive.Dir)
+			if err != nil {
+				log.Fatalf("Failed to create retention archive: %v", err)
+			}
+			logger.Info(fmt.Sprintf("Archiving purged callbacks to %s", cfg.Callback.Retention.Archive.Dir))
+		}
+		retentionJob := retention.NewJob(repo, archive, cfg.Callback.Retention, logger)
+		go retentionJob.Start(context.Background())
+	}
+
+	callbackProducer := callback.NewProducer(repo, brokers.publisher, callbackCodec, cfg.Callback.Producer, logger)
 	callbackProducer.Start(context.Background())
 }
+
+// newRepository connects to Postgres, migrates it and starts the partition manager, or keeps callbacks in memory
+// for single instance development.
+func newRepository(cfg *config.Config, logger *slog.Logger) (db.Repository, func()) {
+	if cfg.Database.Type == config.DatabaseMemory {
+		logger.Warn("Callbacks are kept in memory and lost on restart")
+		return db.NewMemoryRepository(clock.System, cfg.Callback.Producer.OrderedDelivery), func() {}
+	}
+
+	dbConnStr := db.GetConnStr(cfg.Database)
+
+	// Without auto-migration the schema is migrated by running the migrate subcommand before deploying.
+	if cfg.Database.Migrations.Auto {
+		if err := db.RunMigrations(context.Background(), dbConnStr, cfg.Database.Migrations, logger); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	dbpool, err := db.GetPool(dbConnStr, cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.ExportPoolStats(dbpool)
+
+	repo := db.NewCallbackRepository(dbpool, time.Duration(cfg.Database.OperationTimeoutMs)*time.Millisecond, newClock(cfg, dbpool, logger), cfg.Callback.Producer.OrderedDelivery)
+
+	// Partitions for today and the coming days must exist before callbacks are stored.
+	partitionManager := partition.NewManager(repo, cfg.Database.Partitions, logger)
+	if err := partitionManager.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	go partitionManager.Start(context.Background())
+
+	return repo, dbpool.Close
+}
+
+// newClock returns the clock timestamps of callbacks are taken from. With the database clock all instances schedule
+// callbacks by the database server's time, whatever the skew between their hosts.
+func newClock(cfg *config.Config, dbpool *pgxpool.Pool, logger *slog.Logger) clock.Clock {
+	if cfg.Database.Clock.Source == config.ClockSystem {
+		return clock.System
+	}
+
+	synced := clock.NewSynced(db.ServerTime(dbpool), cfg.Database.Clock.SyncIntervalMs, logger)
+	if err := synced.Sync(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	logger.Info(fmt.Sprintf("Synced clock to the database, offset %s", synced.Offset()))
+	go synced.Start(context.Background())
+	return synced
+}
+
+// brokers holds the publisher of callback messages and the subscribers of both topics of the configured broker. The
+// payment-events subscriber is nil when payment events are only ingested over HTTP.
+type brokers struct {
+	publisher        broker.Publisher
+	paymentEvents    broker.Subscriber
+	callbackMessages broker.Subscriber
+	close            func()
+}
+
+func newBrokers(cfg *config.Config, logger *slog.Logger) (*brokers, error) {
+	switch cfg.Broker.Type {
+	case config.BrokerNats:
+		return newNatsBrokers(cfg, logger)
+	case config.BrokerRedis:
+		return newRedisBrokers(cfg)
+	case config.BrokerInProcess:
+		return newInProcessBrokers(cfg), nil
+	default:
+		return newKafkaBrokers(cfg, logger)
+	}
+}
+
+func newKafkaBrokers(cfg *config.Config, logger *slog.Logger) (*brokers, error) {
+	kafkaDialer, err := kafka.NewDialer(cfg.Kafka)
+	if err != nil {
+		return nil, err
+	}
+
+	kafkaTransport, err := kafka.NewTransport(cfg.Kafka)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Kafka.Provisioning.Verify || cfg.Kafka.Provisioning.Provision {
+		specs := kafka.TopicSpecs(cfg.Broker.Topic, cfg.Kafka.Provisioning)
+		if err := kafka.EnsureTopics(context.Background(), cfg.Kafka.Broker.URL, kafkaTransport, specs, cfg.Kafka.Provisioning.Provision, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	eventReader, err := kafka.NewReader(cfg.Kafka.Broker.URL, cfg.Broker.Topic.PaymentEvents, cfg.Broker.Consumer.PaymentEvents.Group, cfg.Kafka.Reader.PaymentEvents, kafkaDialer)
+	if err != nil {
+		return nil, err
+	}
+
+	callbackReader, err := kafka.NewReader(cfg.Kafka.Broker.URL, cfg.Broker.Topic.CallbackMessages, cfg.Broker.Consumer.CallbackMessages.Group, cfg.Kafka.Reader.CallbackMessages, kafkaDialer)
+	if err != nil {
+		eventReader.Close()
+		return nil, err
+	}
+
+	callbackWriter, err := kafka.NewWriter(cfg.Kafka.Broker.URL, cfg.Broker.Topic.CallbackMessages, cfg.Kafka.Writer, kafkaTransport)
+	if err != nil {
+		eventReader.Close()
+		callbackReader.Close()
+		return nil, err
+	}
+
+	kafkaStats := kafka.NewStatsCollector(cfg.Kafka.StatsIntervalMs, logger)
+	kafkaStats.AddReader(eventReader)
+	kafkaStats.AddReader(callbackReader)
+	kafkaStats.AddWriter(callbackWriter)
+	go kafkaStats.Start(context.Background())
+
+	return &brokers{
+		publisher:        kafka.NewPublisher(callbackWriter),
+		paymentEvents:    kafka.NewSubscriber(eventReader),
+		callbackMessages: kafka.NewSubscriber(callbackReader),
+		close: func() {
+			callbackWriter.Close()
+			eventReader.Close()
+			callbackReader.Close()
+		},
+	}, nil
+}
+
+func newNatsBrokers(cfg *config.Config, logger *slog.Logger) (*brokers, error) {
+	ctx := context.Background()
+
+	client, err := nats.NewClient(cfg.Nats, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.EnsureStream(ctx, cfg.Broker.Topic.PaymentEvents, cfg.Broker.Topic.CallbackMessages); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	eventSubscriber, err := client.NewSubscriber(ctx, cfg.Broker.Topic.PaymentEvents, cfg.Broker.Consumer.PaymentEvents.Group)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	callbackSubscriber, err := client.NewSubscriber(ctx, cfg.Broker.Topic.CallbackMessages, cfg.Broker.Consumer.CallbackMessages.Group)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &brokers{
+		publisher:        client.NewPublisher(cfg.Broker.Topic.CallbackMessages),
+		paymentEvents:    eventSubscriber,
+		callbackMessages: callbackSubscriber,
+		close: func() {
+			eventSubscriber.Close()
+			callbackSubscriber.Close()
+			client.Close()
+		},
+	}, nil
+}
+
+func newRedisBrokers(cfg *config.Config) (*brokers, error) {
+	ctx := context.Background()
+
+	client, err := redis.NewClient(ctx, cfg.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	eventSubscriber, err := client.NewSubscriber(ctx, cfg.Broker.Topic.PaymentEvents, cfg.Broker.Consumer.PaymentEvents.Group)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	callbackSubscriber, err := client.NewSubscriber(ctx, cfg.Broker.Topic.CallbackMessages, cfg.Broker.Consumer.CallbackMessages.Group)
+	if err != nil {
+		eventSubscriber.Close()
+		client.Close()
+		return nil, err
+	}
+
+	return &brokers{
+		publisher:        client.NewPublisher(cfg.Broker.Topic.CallbackMessages),
+		paymentEvents:    eventSubscriber,
+		callbackMessages: callbackSubscriber,
+		close: func() {
+			eventSubscriber.Close()
+			callbackSubscriber.Close()
+			client.Close()
+		},
+	}, nil
+}
+
+// newInProcessBrokers dispatches callback messages from the producer to the processor within the process.
+func newInProcessBrokers(cfg *config.Config) *brokers {
+	b := inprocess.NewBroker(cfg.InProcess.BufferSize)
+
+	return &brokers{
+		publisher:        b.NewPublisher(cfg.Broker.Topic.CallbackMessages),
+		callbackMessages: b.NewSubscriber(cfg.Broker.Topic.CallbackMessages),
+		close:            func() {},
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"callback-service/internal/config"
+	"callback-service/internal/db"
+	"github.com/pkg/errors"
+	"github.com/pressly/goose/v3"
+)
+
+const migrateUsage = "usage: callback-service migrate up|down|status|version"
+
+// runMigrate runs the migrate subcommand against the configured database:
+//   - up applies all pending migrations,
+//   - down rolls back the latest applied migration,
+//   - status lists all migrations with the time they were applied,
+//   - version prints the version of the latest applied migration.
+func runMigrate(cfg *config.Config, args []string, logger *slog.Logger) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+	if cfg.Database.Type == config.DatabaseMemory {
+		return errors.Errorf("database type %q has no migrations", config.DatabaseMemory)
+	}
+
+	migrator, err := db.NewMigrator(db.GetConnStr(cfg.Database), cfg.Database.Migrations, logger)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		return migrator.Down(ctx)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return printStatus(statuses)
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(version)
+		return nil
+	default:
+		return errors.Errorf("unknown migrate command %q, %s", args[0], migrateUsage)
+	}
+}
+
+func printStatus(statuses []*goose.MigrationStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APPLIED AT\tMIGRATION")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.State == goose.StateApplied {
+			appliedAt = s.AppliedAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", appliedAt, s.Source.Path)
+	}
+	return w.Flush()
+}
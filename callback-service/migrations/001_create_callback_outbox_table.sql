@@ -12,4 +12,7 @@ CREATE TABLE callback_message
     delivery_attempts INT           NOT NULL DEFAULT 0,
     publish_attempts  INT           NOT NULL DEFAULT 0,
     error             TEXT          NULL
-);
\ No newline at end of file
+);
+
+-- +goose Down
+DROP TABLE callback_message;
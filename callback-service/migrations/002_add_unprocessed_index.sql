@@ -1,4 +1,7 @@
 -- +goose Up
 CREATE INDEX idx_callback_message_scheduled_at
     ON callback_message (scheduled_at)
-    WHERE scheduled_at IS NOT NULL;
\ No newline at end of file
+    WHERE scheduled_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_callback_message_scheduled_at;
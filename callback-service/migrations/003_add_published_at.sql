@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE callback_message
+    ADD COLUMN published_at TIMESTAMP;
+
+CREATE INDEX idx_callback_message_published_at
+    ON callback_message (published_at)
+    WHERE published_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_callback_message_published_at;
+
+ALTER TABLE callback_message
+    DROP COLUMN published_at;
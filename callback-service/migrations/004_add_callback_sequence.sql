@@ -0,0 +1,31 @@
+-- +goose Up
+ALTER TABLE callback_message
+    ADD COLUMN sequence BIGINT NOT NULL DEFAULT 0;
+
+UPDATE callback_message c
+SET sequence = s.sequence
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY payment_id ORDER BY created_at, id) AS sequence
+      FROM callback_message) s
+WHERE c.id = s.id;
+
+CREATE TABLE callback_payment_sequence
+(
+    payment_id    UUID PRIMARY KEY,
+    last_sequence BIGINT NOT NULL
+);
+
+INSERT INTO callback_payment_sequence (payment_id, last_sequence)
+SELECT payment_id, MAX(sequence)
+FROM callback_message
+GROUP BY payment_id;
+
+CREATE INDEX idx_callback_message_payment_id_sequence
+    ON callback_message (payment_id, sequence);
+
+-- +goose Down
+DROP INDEX idx_callback_message_payment_id_sequence;
+
+DROP TABLE callback_payment_sequence;
+
+ALTER TABLE callback_message
+    DROP COLUMN sequence;
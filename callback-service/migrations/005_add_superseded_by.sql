@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE callback_message
+    ADD COLUMN superseded_by UUID;
+
+-- The time of the payment state a callback reports. Callbacks of a payment supersede each other in this order, so an
+-- event of an earlier state that arrives late does not replace the callback of a later state.
+ALTER TABLE callback_message
+    ADD COLUMN event_time TIMESTAMP;
+
+UPDATE callback_message
+SET event_time = created_at;
+
+ALTER TABLE callback_message
+    ALTER COLUMN event_time SET NOT NULL;
+
+CREATE INDEX idx_callback_message_payment_id_event_time
+    ON callback_message (payment_id, event_time, sequence);
+
+-- +goose Down
+DROP INDEX idx_callback_message_payment_id_event_time;
+
+ALTER TABLE callback_message
+    DROP COLUMN event_time;
+
+ALTER TABLE callback_message
+    DROP COLUMN superseded_by;
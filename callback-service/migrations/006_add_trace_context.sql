@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE callback_message
+    ADD COLUMN correlation_id VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN trace_id       VARCHAR(32)  NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE callback_message
+    DROP COLUMN correlation_id,
+    DROP COLUMN trace_id;
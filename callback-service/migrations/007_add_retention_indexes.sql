@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE INDEX idx_callback_message_delivered_at
+    ON callback_message (delivered_at)
+    WHERE delivered_at IS NOT NULL;
+
+CREATE INDEX idx_callback_message_failed_updated_at
+    ON callback_message (updated_at)
+    WHERE delivered_at IS NULL AND scheduled_at IS NULL AND published_at IS NULL;
+
+-- +goose Down
+DROP INDEX idx_callback_message_delivered_at;
+
+DROP INDEX idx_callback_message_failed_updated_at;
@@ -0,0 +1,183 @@
+-- +goose Up
+-- The timestamps were stored as UTC wall clock time without a time zone and become TIMESTAMPTZ, as Postgres cannot
+-- change the type of a partition key later. Existing callbacks are copied within the migration's transaction, which
+-- blocks writes to callback_message until it commits: on large tables purge finished callbacks first, see
+-- callback.retention, and migrate in a maintenance window with the migrate subcommand.
+ALTER TABLE callback_message RENAME TO callback_message_unpartitioned;
+ALTER INDEX callback_message_pkey RENAME TO callback_message_unpartitioned_pkey;
+ALTER INDEX idx_callback_message_scheduled_at RENAME TO idx_callback_message_unpartitioned_scheduled_at;
+ALTER INDEX idx_callback_message_published_at RENAME TO idx_callback_message_unpartitioned_published_at;
+ALTER INDEX idx_callback_message_payment_id_sequence RENAME TO idx_callback_message_unpartitioned_payment_id_sequence;
+ALTER INDEX idx_callback_message_delivered_at RENAME TO idx_callback_message_unpartitioned_delivered_at;
+ALTER INDEX idx_callback_message_failed_updated_at RENAME TO idx_callback_message_unpartitioned_failed_updated_at;
+ALTER INDEX idx_callback_message_payment_id_event_time RENAME TO idx_callback_message_unpartitioned_payment_id_event_time;
+
+CREATE TABLE callback_message
+(
+    id                UUID          NOT NULL,
+    payment_id        UUID          NOT NULL,
+    payload           JSONB         NOT NULL,
+    url               VARCHAR(2048) NOT NULL,
+    created_at        TIMESTAMPTZ   NOT NULL,
+    updated_at        TIMESTAMPTZ   NOT NULL,
+    scheduled_at      TIMESTAMPTZ,
+    delivered_at      TIMESTAMPTZ,
+    delivery_attempts INT           NOT NULL DEFAULT 0,
+    publish_attempts  INT           NOT NULL DEFAULT 0,
+    error             TEXT          NULL,
+    published_at      TIMESTAMPTZ,
+    sequence          BIGINT        NOT NULL DEFAULT 0,
+    superseded_by     UUID,
+    correlation_id    VARCHAR(255)  NOT NULL DEFAULT '',
+    trace_id          VARCHAR(32)   NOT NULL DEFAULT '',
+    event_time        TIMESTAMPTZ   NOT NULL,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX idx_callback_message_scheduled_at
+    ON callback_message (scheduled_at)
+    WHERE scheduled_at IS NOT NULL;
+
+CREATE INDEX idx_callback_message_published_at
+    ON callback_message (published_at)
+    WHERE published_at IS NOT NULL;
+
+CREATE INDEX idx_callback_message_payment_id_sequence
+    ON callback_message (payment_id, sequence);
+
+CREATE INDEX idx_callback_message_payment_id_event_time
+    ON callback_message (payment_id, event_time, sequence);
+
+CREATE INDEX idx_callback_message_delivered_at
+    ON callback_message (delivered_at)
+    WHERE delivered_at IS NOT NULL;
+
+CREATE INDEX idx_callback_message_failed_updated_at
+    ON callback_message (updated_at)
+    WHERE delivered_at IS NULL AND scheduled_at IS NULL AND published_at IS NULL;
+
+-- The primary key of a partitioned table must contain the partition key, so the uniqueness of callback IDs is
+-- enforced by this table. It also maps IDs to the partition of their callback.
+CREATE TABLE callback_message_key
+(
+    id         UUID PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_callback_message_key_created_at
+    ON callback_message_key (created_at);
+
+-- Callbacks created before today are kept in partitions of a calendar month each, the last one ending today, named
+-- callback_message_pYYYYMMDD_YYYYMMDD by their first and end day. Today and the coming week get daily partitions named
+-- callback_message_pYYYYMMDD, later ones are created by the partition manager. Days are UTC days.
+-- +goose StatementBegin
+DO
+$$
+    DECLARE
+        today       DATE := (NOW() AT TIME ZONE 'UTC')::DATE;
+        first_month DATE;
+        month       DATE;
+        month_end   DATE;
+        day         DATE;
+    BEGIN
+        SELECT date_trunc('month', MIN(created_at))::DATE
+        INTO first_month
+        FROM callback_message_unpartitioned
+        WHERE created_at < today;
+
+        IF first_month IS NOT NULL THEN
+            FOR month IN SELECT generate_series(first_month, today - 1, INTERVAL '1 month')::DATE
+                LOOP
+                    month_end := LEAST((month + INTERVAL '1 month')::DATE, today);
+                    EXECUTE format('CREATE TABLE %I PARTITION OF callback_message FOR VALUES FROM (%L) TO (%L)',
+                                   'callback_message_p' || to_char(month, 'YYYYMMDD') || '_' || to_char(month_end, 'YYYYMMDD'),
+                                   month::TIMESTAMP AT TIME ZONE 'UTC', month_end::TIMESTAMP AT TIME ZONE 'UTC');
+                END LOOP;
+        END IF;
+
+        FOR day IN SELECT generate_series(today, today + 7, INTERVAL '1 day')::DATE
+            LOOP
+                EXECUTE format('CREATE TABLE %I PARTITION OF callback_message FOR VALUES FROM (%L) TO (%L)',
+                               'callback_message_p' || to_char(day, 'YYYYMMDD'),
+                               day::TIMESTAMP AT TIME ZONE 'UTC', (day + 1)::TIMESTAMP AT TIME ZONE 'UTC');
+            END LOOP;
+    END
+$$;
+-- +goose StatementEnd
+
+-- Holds callbacks created outside of all partitions, e.g. while the partition manager is not running, until the
+-- partition of their day is created.
+CREATE TABLE callback_message_default PARTITION OF callback_message DEFAULT;
+
+INSERT INTO callback_message (id, payment_id, payload, url, created_at, updated_at, scheduled_at, delivered_at,
+                              delivery_attempts, publish_attempts, error, published_at, sequence, superseded_by,
+                              correlation_id, trace_id, event_time)
+SELECT id, payment_id, payload, url, created_at AT TIME ZONE 'UTC', updated_at AT TIME ZONE 'UTC',
+       scheduled_at AT TIME ZONE 'UTC', delivered_at AT TIME ZONE 'UTC', delivery_attempts, publish_attempts, error,
+       published_at AT TIME ZONE 'UTC', sequence, superseded_by, correlation_id, trace_id, event_time AT TIME ZONE 'UTC'
+FROM callback_message_unpartitioned;
+
+INSERT INTO callback_message_key (id, created_at)
+SELECT id, created_at AT TIME ZONE 'UTC'
+FROM callback_message_unpartitioned;
+
+DROP TABLE callback_message_unpartitioned;
+
+-- +goose Down
+CREATE TABLE callback_message_unpartitioned
+(
+    id                UUID PRIMARY KEY,
+    payment_id        UUID          NOT NULL,
+    payload           JSONB         NOT NULL,
+    url               VARCHAR(2048) NOT NULL,
+    created_at        TIMESTAMP     NOT NULL,
+    updated_at        TIMESTAMP     NOT NULL,
+    scheduled_at      TIMESTAMP,
+    delivered_at      TIMESTAMP,
+    delivery_attempts INT           NOT NULL DEFAULT 0,
+    publish_attempts  INT           NOT NULL DEFAULT 0,
+    error             TEXT          NULL,
+    published_at      TIMESTAMP,
+    sequence          BIGINT        NOT NULL DEFAULT 0,
+    superseded_by     UUID,
+    correlation_id    VARCHAR(255)  NOT NULL DEFAULT '',
+    trace_id          VARCHAR(32)   NOT NULL DEFAULT '',
+    event_time        TIMESTAMP     NOT NULL
+);
+
+INSERT INTO callback_message_unpartitioned (id, payment_id, payload, url, created_at, updated_at, scheduled_at,
+                                            delivered_at, delivery_attempts, publish_attempts, error, published_at,
+                                            sequence, superseded_by, correlation_id, trace_id, event_time)
+SELECT id, payment_id, payload, url, created_at AT TIME ZONE 'UTC', updated_at AT TIME ZONE 'UTC',
+       scheduled_at AT TIME ZONE 'UTC', delivered_at AT TIME ZONE 'UTC', delivery_attempts, publish_attempts, error,
+       published_at AT TIME ZONE 'UTC', sequence, superseded_by, correlation_id, trace_id, event_time AT TIME ZONE 'UTC'
+FROM callback_message;
+
+-- Drops the partitions as well.
+DROP TABLE callback_message;
+DROP TABLE callback_message_key;
+
+ALTER TABLE callback_message_unpartitioned RENAME TO callback_message;
+ALTER INDEX callback_message_unpartitioned_pkey RENAME TO callback_message_pkey;
+
+CREATE INDEX idx_callback_message_scheduled_at
+    ON callback_message (scheduled_at)
+    WHERE scheduled_at IS NOT NULL;
+
+CREATE INDEX idx_callback_message_published_at
+    ON callback_message (published_at)
+    WHERE published_at IS NOT NULL;
+
+CREATE INDEX idx_callback_message_payment_id_sequence
+    ON callback_message (payment_id, sequence);
+
+CREATE INDEX idx_callback_message_payment_id_event_time
+    ON callback_message (payment_id, event_time, sequence);
+
+CREATE INDEX idx_callback_message_delivered_at
+    ON callback_message (delivered_at)
+    WHERE delivered_at IS NOT NULL;
+
+CREATE INDEX idx_callback_message_failed_updated_at
+    ON callback_message (updated_at)
+    WHERE delivered_at IS NULL AND scheduled_at IS NULL AND published_at IS NULL;
@@ -0,0 +1,46 @@
+-- +goose Up
+ALTER TABLE callback_message
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE callback_message_audit
+(
+    id          BIGSERIAL PRIMARY KEY,
+    callback_id UUID        NOT NULL,
+    version     BIGINT      NOT NULL,
+    operation   VARCHAR(16) NOT NULL,
+    actor       VARCHAR(64) NOT NULL,
+    before      JSONB,
+    after       JSONB,
+    changed_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_callback_message_audit_callback_id
+    ON callback_message_audit (callback_id, id);
+
+-- +goose StatementBegin
+CREATE FUNCTION callback_message_audit_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'callback_message_audit is append-only';
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER callback_message_audit_append_only
+    BEFORE UPDATE OR DELETE
+    ON callback_message_audit
+    FOR EACH ROW
+EXECUTE FUNCTION callback_message_audit_append_only();
+
+CREATE TRIGGER callback_message_audit_no_truncate
+    BEFORE TRUNCATE
+    ON callback_message_audit
+    FOR EACH STATEMENT
+EXECUTE FUNCTION callback_message_audit_append_only();
+
+-- +goose Down
+DROP TABLE callback_message_audit;
+DROP FUNCTION callback_message_audit_append_only();
+
+ALTER TABLE callback_message
+    DROP COLUMN version;
@@ -0,0 +1,62 @@
+-- +goose Up
+-- The audit is append-only for the service, old changes are only deleted by purge_callback_message_audit. It runs
+-- with the rights of the table owner and marks its transaction, so the trigger lets its deletes through; a role that
+-- does not own the table can not delete audit rows even when it sets the mark itself.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION callback_message_audit_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'DELETE' AND current_setting('callback.audit_purge', true) = 'on' AND
+       pg_has_role(current_user, (SELECT relowner FROM pg_class WHERE oid = TG_RELID), 'USAGE') THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'callback_message_audit is append-only';
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE INDEX idx_callback_message_audit_changed_at
+    ON callback_message_audit (changed_at);
+
+-- Deletes up to batch_size of the oldest changes made before changed_before and returns how many it deleted.
+-- +goose StatementBegin
+CREATE FUNCTION purge_callback_message_audit(changed_before TIMESTAMPTZ, batch_size INT) RETURNS INT
+    SECURITY DEFINER
+    SET search_path = public
+AS
+$$
+DECLARE
+    purged INT;
+BEGIN
+    PERFORM set_config('callback.audit_purge', 'on', true);
+
+    DELETE
+    FROM callback_message_audit
+    WHERE id IN (SELECT id
+                 FROM callback_message_audit
+                 WHERE changed_at < changed_before
+                 ORDER BY changed_at
+                 LIMIT batch_size);
+    GET DIAGNOSTICS purged = ROW_COUNT;
+
+    PERFORM set_config('callback.audit_purge', 'off', true);
+    RETURN purged;
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+REVOKE EXECUTE ON FUNCTION purge_callback_message_audit(TIMESTAMPTZ, INT) FROM PUBLIC;
+
+-- +goose Down
+DROP FUNCTION purge_callback_message_audit(TIMESTAMPTZ, INT);
+
+DROP INDEX idx_callback_message_audit_changed_at;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION callback_message_audit_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'callback_message_audit is append-only';
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
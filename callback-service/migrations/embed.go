@@ -0,0 +1,8 @@
+// Package migrations embeds the goose SQL migrations of the database schema, so the binary runs them from any
+// working directory.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
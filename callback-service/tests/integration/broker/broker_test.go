@@ -0,0 +1,113 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"callback-service/internal/broker"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// brokerSuite holds the tests every broker implementation must pass. The suites of the implementations embed it and
+// set the factories once their container is running.
+type brokerSuite struct {
+	suite.Suite
+	ctx           context.Context
+	newPublisher  func(topic string) broker.Publisher
+	newSubscriber func(topic, group string) broker.Subscriber
+	// redelivers is set for brokers that redeliver a nacked message without a restart.
+	redelivers bool
+}
+
+// topic creates a new topic, so tests do not see messages of each other.
+func (s *brokerSuite) topic() string {
+	return fmt.Sprintf("test-%s", uuid.New().String())
+}
+
+func (s *brokerSuite) fetch(subscriber broker.Subscriber) broker.Delivery {
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	d, err := subscriber.Fetch(ctx)
+	require.NoError(s.T(), err)
+	return d
+}
+
+func (s *brokerSuite) TestPublishAndFetch() {
+	t := s.T()
+	topic := s.topic()
+
+	publisher := s.newPublisher(topic)
+	defer publisher.Close()
+	subscriber := s.newSubscriber(topic, "test-group")
+	defer subscriber.Close()
+
+	err := publisher.Publish(s.ctx,
+		broker.Message{Key: []byte("payment-1"), Value: []byte(`{"n":1}`), Headers: map[string]string{"correlation-id": "c-1"}},
+		broker.Message{Key: []byte("payment-1"), Value: []byte(`{"n":2}`), Headers: map[string]string{"correlation-id": "c-2"}},
+	)
+	require.NoError(t, err)
+
+	first := s.fetch(subscriber)
+	assert.Equal(t, topic, first.Message().Topic)
+	assert.Equal(t, []byte("payment-1"), first.Message().Key)
+	assert.Equal(t, []byte(`{"n":1}`), first.Message().Value)
+	assert.Equal(t, "c-1", first.Message().Headers["correlation-id"])
+	assert.False(t, first.Message().Time.IsZero())
+	assert.NoError(t, first.Ack(s.ctx))
+
+	second := s.fetch(subscriber)
+	assert.Equal(t, []byte(`{"n":2}`), second.Message().Value)
+	assert.Equal(t, "c-2", second.Message().Headers["correlation-id"])
+	assert.NoError(t, second.Ack(s.ctx))
+}
+
+func (s *brokerSuite) TestNackRedelivers() {
+	t := s.T()
+	if !s.redelivers {
+		t.Skip("broker only redelivers nacked messages after a restart")
+	}
+	topic := s.topic()
+
+	publisher := s.newPublisher(topic)
+	defer publisher.Close()
+	subscriber := s.newSubscriber(topic, "test-group")
+	defer subscriber.Close()
+
+	require.NoError(t, publisher.Publish(s.ctx, broker.Message{Key: []byte("payment-1"), Value: []byte("retry me")}))
+
+	first := s.fetch(subscriber)
+	assert.Equal(t, []byte("retry me"), first.Message().Value)
+	assert.NoError(t, first.Nack(s.ctx))
+
+	redelivered := s.fetch(subscriber)
+	assert.Equal(t, []byte("retry me"), redelivered.Message().Value)
+	assert.NoError(t, redelivered.Ack(s.ctx))
+}
+
+func (s *brokerSuite) TestAckedMessageIsNotRedelivered() {
+	t := s.T()
+	topic := s.topic()
+
+	publisher := s.newPublisher(topic)
+	defer publisher.Close()
+	subscriber := s.newSubscriber(topic, "test-group")
+
+	require.NoError(t, publisher.Publish(s.ctx, broker.Message{Value: []byte("first")}))
+	assert.NoError(t, s.fetch(subscriber).Ack(s.ctx))
+	require.NoError(t, subscriber.Close())
+
+	require.NoError(t, publisher.Publish(s.ctx, broker.Message{Value: []byte("second")}))
+
+	// A new member of the same group continues after the acknowledged message.
+	next := s.newSubscriber(topic, "test-group")
+	defer next.Close()
+
+	d := s.fetch(next)
+	assert.Equal(t, []byte("second"), d.Message().Value)
+	assert.NoError(t, d.Ack(s.ctx))
+}
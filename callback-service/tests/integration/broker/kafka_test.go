@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"testing"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"callback-service/internal/kafka"
+	"callback-service/tests/testhelpers"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type KafkaTestSuite struct {
+	brokerSuite
+	kafkaContainer *testhelpers.KafkaContainer
+}
+
+func (s *KafkaTestSuite) SetupSuite() {
+	s.ctx = context.Background()
+	kafkaContainer, err := testhelpers.CreateKafkaContainer(s.ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.kafkaContainer = kafkaContainer
+
+	transport, err := kafka.NewTransport(config.Kafka{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	dialer, err := kafka.NewDialer(config.Kafka{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.newPublisher = func(topic string) broker.Publisher {
+		specs := []kafka.TopicSpec{{Name: topic, Partitions: 1, ReplicationFactor: 1}}
+		require.NoError(s.T(), kafka.EnsureTopics(s.ctx, kafkaContainer.Brokers, transport, specs, true, slog.Default()))
+
+		writer, err := kafka.NewWriter(kafkaContainer.Brokers, topic, config.KafkaWriter{BatchSize: 1, RequiredAcks: "all"}, transport)
+		require.NoError(s.T(), err)
+		return kafka.NewPublisher(writer)
+	}
+	s.newSubscriber = func(topic, group string) broker.Subscriber {
+		reader, err := kafka.NewReader(kafkaContainer.Brokers, topic, group, config.KafkaReader{StartOffset: "first", MinBytes: 1, MaxBytes: 10e6, MaxWaitMs: 500}, dialer)
+		require.NoError(s.T(), err)
+		return kafka.NewSubscriber(reader)
+	}
+}
+
+func (s *KafkaTestSuite) TearDownSuite() {
+	if err := s.kafkaContainer.Terminate(s.ctx); err != nil {
+		log.Fatalf("error terminating kafka container: %s", err)
+	}
+}
+
+func TestKafkaTestSuite(t *testing.T) {
+	suite.Run(t, new(KafkaTestSuite))
+}
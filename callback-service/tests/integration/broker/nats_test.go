@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"testing"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"callback-service/internal/nats"
+	"callback-service/tests/testhelpers"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type NatsTestSuite struct {
+	brokerSuite
+	natsContainer *testhelpers.NatsContainer
+	client        *nats.Client
+}
+
+func (s *NatsTestSuite) SetupSuite() {
+	s.ctx = context.Background()
+	s.redelivers = true
+
+	natsContainer, err := testhelpers.CreateNatsContainer(s.ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.natsContainer = natsContainer
+
+	client, err := nats.NewClient(config.Nats{
+		URL:            natsContainer.URL,
+		Stream:         config.NatsStream{Name: "test", Replicas: 1},
+		AckWaitMs:      1000,
+		MaxDeliver:     -1,
+		FetchBatchSize: 10,
+	}, slog.Default())
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.client = client
+
+	s.newPublisher = func(topic string) broker.Publisher {
+		require.NoError(s.T(), client.EnsureStream(s.ctx, topic))
+		return client.NewPublisher(topic)
+	}
+	s.newSubscriber = func(topic, group string) broker.Subscriber {
+		require.NoError(s.T(), client.EnsureStream(s.ctx, topic))
+		subscriber, err := client.NewSubscriber(s.ctx, topic, group)
+		require.NoError(s.T(), err)
+		return subscriber
+	}
+}
+
+func (s *NatsTestSuite) TearDownSuite() {
+	s.client.Close()
+
+	if err := s.natsContainer.Terminate(s.ctx); err != nil {
+		log.Fatalf("error terminating nats container: %s", err)
+	}
+}
+
+func TestNatsTestSuite(t *testing.T) {
+	suite.Run(t, new(NatsTestSuite))
+}
@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"callback-service/internal/broker"
+	"callback-service/internal/config"
+	"callback-service/internal/redis"
+	"callback-service/tests/testhelpers"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type RedisTestSuite struct {
+	brokerSuite
+	redisContainer *testhelpers.RedisContainer
+	client         *redis.Client
+}
+
+func (s *RedisTestSuite) SetupSuite() {
+	s.ctx = context.Background()
+	s.redelivers = true
+
+	redisContainer, err := testhelpers.CreateRedisContainer(s.ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.redisContainer = redisContainer
+
+	client, err := redis.NewClient(s.ctx, config.Redis{
+		Address:        redisContainer.Address,
+		MaxLen:         1000,
+		BlockMs:        200,
+		ClaimMinIdleMs: 500,
+		FetchBatchSize: 10,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.client = client
+
+	s.newPublisher = func(topic string) broker.Publisher {
+		return client.NewPublisher(topic)
+	}
+	s.newSubscriber = func(topic, group string) broker.Subscriber {
+		subscriber, err := client.NewSubscriber(s.ctx, topic, group)
+		require.NoError(s.T(), err)
+		return subscriber
+	}
+}
+
+func (s *RedisTestSuite) TearDownSuite() {
+	s.client.Close()
+
+	if err := s.redisContainer.Terminate(s.ctx); err != nil {
+		log.Fatalf("error terminating redis container: %s", err)
+	}
+}
+
+func (s *RedisTestSuite) TestCloseLeavesGroup() {
+	t := s.T()
+	topic := s.topic()
+
+	rdb := goredis.NewClient(&goredis.Options{Addr: s.redisContainer.Address})
+	defer rdb.Close()
+	consumers := func() int {
+		consumers, err := rdb.XInfoConsumers(s.ctx, topic, "test-group").Result()
+		require.NoError(t, err)
+		return len(consumers)
+	}
+
+	publisher := s.newPublisher(topic)
+	defer publisher.Close()
+	subscriber := s.newSubscriber(topic, "test-group")
+
+	require.NoError(t, publisher.Publish(s.ctx, broker.Message{Value: []byte("pending")}))
+	d := s.fetch(subscriber)
+
+	require.NoError(t, subscriber.Close())
+	assert.Equal(t, 1, consumers(), "a consumer with pending messages stays in the group")
+
+	require.NoError(t, d.Ack(s.ctx))
+	require.NoError(t, subscriber.Close())
+	assert.Equal(t, 0, consumers())
+}
+
+func TestRedisTestSuite(t *testing.T) {
+	suite.Run(t, new(RedisTestSuite))
+}
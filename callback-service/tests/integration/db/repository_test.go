@@ -2,15 +2,21 @@ package customer
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"log/slog"
 	"testing"
 	"time"
 
+	"callback-service/internal/clock"
+	"callback-service/internal/config"
 	"callback-service/internal/db"
 	"callback-service/tests/testhelpers"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -23,8 +29,6 @@ type CallbackRepositoryTestSuite struct {
 }
 
 func (s *CallbackRepositoryTestSuite) SetupSuite() {
-	time.Local = time.UTC
-
 	s.ctx = context.Background()
 	pgContainer, err := testhelpers.CreatePostgresContainer(s.ctx)
 	if err != nil {
@@ -32,15 +36,17 @@ func (s *CallbackRepositoryTestSuite) SetupSuite() {
 	}
 	s.pgContainer = pgContainer
 
-	db.RunMigrations(pgContainer.ConnectionString, "../../../migrations")
+	if err := db.RunMigrations(s.ctx, pgContainer.ConnectionString, config.DatabaseMigrations{}, slog.Default()); err != nil {
+		log.Fatal(err)
+	}
 
-	pool, err := db.GetPool(pgContainer.ConnectionString)
+	pool, err := db.GetPool(pgContainer.ConnectionString, config.Database{})
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	s.pool = pool
-	s.sut = db.NewCallbackRepository(pool)
+	s.sut = db.NewCallbackRepository(pool, 0, clock.System, false)
 }
 
 func (s *CallbackRepositoryTestSuite) TearDownSuite() {
@@ -56,6 +62,16 @@ func (s *CallbackRepositoryTestSuite) SetupTest() {
 	if err != nil {
 		log.Fatalf("error truncating callback_message table: %s", err)
 	}
+
+	_, err = s.pool.Exec(s.ctx, "DELETE FROM callback_message_key")
+	if err != nil {
+		log.Fatalf("error truncating callback_message_key table: %s", err)
+	}
+
+	_, err = s.pool.Exec(s.ctx, "DELETE FROM callback_payment_sequence")
+	if err != nil {
+		log.Fatalf("error truncating callback_payment_sequence table: %s", err)
+	}
 }
 
 func (s *CallbackRepositoryTestSuite) TestBeginTx() {
@@ -90,6 +106,29 @@ func (s *CallbackRepositoryTestSuite) TestCreate() {
 	assert.Equal(t, entity.ID, createdEntity.ID)
 }
 
+func (s *CallbackRepositoryTestSuite) TestCreate_Duplicate() {
+	t := s.T()
+
+	entity := &db.CallbackMessageEntity{
+		ID:        uuid.New(),
+		PaymentID: uuid.New(),
+		Url:       "http://example.com",
+		Payload:   `{"key": "value"}`,
+	}
+
+	_, err := s.sut.Create(s.ctx, entity)
+	assert.NoError(t, err)
+
+	duplicate := *entity
+	_, err = s.sut.Create(s.ctx, &duplicate)
+	assert.ErrorIs(t, err, db.ErrDuplicate)
+
+	var sequence int64
+	err = s.pool.QueryRow(s.ctx, "SELECT last_sequence FROM callback_payment_sequence WHERE payment_id = $1", entity.PaymentID).Scan(&sequence)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), sequence, "duplicates do not advance the sequence")
+}
+
 func (s *CallbackRepositoryTestSuite) TestGetUnprocessedCallbacks() {
 	t := s.T()
 
@@ -117,6 +156,397 @@ func (s *CallbackRepositoryTestSuite) TestGetUnprocessedCallbacks() {
 	assert.Equal(t, entity.ID, callbacks[0].ID)
 }
 
+func (s *CallbackRepositoryTestSuite) TestGetUnprocessedCallbacks_UsesRepositoryClock() {
+	t := s.T()
+
+	ahead := clock.NewSynced(func(context.Context) (time.Time, error) {
+		return time.Now().Add(time.Hour), nil
+	}, 1000, slog.Default())
+	require.NoError(t, ahead.Sync(s.ctx))
+	sut := db.NewCallbackRepository(s.pool, 0, ahead, false)
+
+	scheduledAt := time.Now().Add(30 * time.Minute)
+	entity := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   uuid.New(),
+		Url:         "http://example.com",
+		Payload:     `{"key": "value"}`,
+		ScheduledAt: &scheduledAt,
+	}
+	_, err := sut.Create(s.ctx, entity)
+	require.NoError(t, err)
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	require.NoError(t, err)
+	callbacks, err := s.sut.GetUnprocessedCallbacks(s.ctx, tx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, callbacks, "not due by the host clock")
+	require.NoError(t, tx.Rollback(s.ctx))
+
+	tx, err = sut.BeginTx(s.ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+	callbacks, err = sut.GetUnprocessedCallbacks(s.ctx, tx, 10)
+	require.NoError(t, err)
+	require.Len(t, callbacks, 1)
+	assert.Equal(t, entity.ID, callbacks[0].ID)
+}
+
+func (s *CallbackRepositoryTestSuite) TestTimestampsKeepTheInstant() {
+	t := s.T()
+
+	zone := time.FixedZone("UTC+5", 5*60*60)
+	scheduledAt := time.Now().In(zone).Truncate(time.Microsecond)
+	entity := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   uuid.New(),
+		Url:         "http://example.com",
+		Payload:     `{"key": "value"}`,
+		ScheduledAt: &scheduledAt,
+	}
+	_, err := s.sut.Create(s.ctx, entity)
+	require.NoError(t, err)
+
+	stored, err := s.sut.SelectByID(s.ctx, entity.ID)
+	require.NoError(t, err)
+	assert.True(t, scheduledAt.Equal(*stored.ScheduledAt), "stored %v, want %v", *stored.ScheduledAt, scheduledAt)
+
+	serverTime, err := db.ServerTime(s.pool)(s.ctx)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), serverTime, 5*time.Second)
+}
+
+func (s *CallbackRepositoryTestSuite) TestCreate_AssignsSequencePerPayment() {
+	t := s.T()
+
+	now := time.Now()
+	paymentID := uuid.New()
+
+	for i := 1; i <= 2; i++ {
+		entity := &db.CallbackMessageEntity{
+			ID:          uuid.New(),
+			PaymentID:   paymentID,
+			Url:         "http://example.com",
+			Payload:     `{"key": "value"}`,
+			ScheduledAt: &now,
+		}
+
+		createdEntity, err := s.sut.Create(s.ctx, entity)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(i), createdEntity.Sequence)
+		assert.JSONEq(t, `{"key": "value"}`, createdEntity.Payload)
+	}
+}
+
+func (s *CallbackRepositoryTestSuite) TestCreate_AddsSequenceToPayloadForOrderedDelivery() {
+	t := s.T()
+
+	sut := db.NewCallbackRepository(s.pool, 0, clock.System, true)
+	now := time.Now()
+	paymentID := uuid.New()
+
+	for i := 1; i <= 2; i++ {
+		entity := &db.CallbackMessageEntity{
+			ID:          uuid.New(),
+			PaymentID:   paymentID,
+			Url:         "http://example.com",
+			Payload:     `{"key": "value"}`,
+			ScheduledAt: &now,
+		}
+
+		createdEntity, err := sut.Create(s.ctx, entity)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(i), createdEntity.Sequence)
+		assert.JSONEq(t, fmt.Sprintf(`{"key": "value", "sequence": %d}`, i), createdEntity.Payload)
+
+		stored, err := sut.SelectByID(s.ctx, createdEntity.ID)
+		assert.NoError(t, err)
+		assert.JSONEq(t, createdEntity.Payload, stored.Payload)
+	}
+}
+
+func (s *CallbackRepositoryTestSuite) TestGetUnprocessedOrderedCallbacks() {
+	t := s.T()
+
+	past := time.Now().Add(-time.Hour)
+	paymentID := uuid.New()
+
+	first := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Url:         "http://example.com",
+		Payload:     `{"status": "failed"}`,
+		ScheduledAt: &past,
+	}
+	second := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Url:         "http://example.com",
+		Payload:     `{"status": "successful"}`,
+		ScheduledAt: &past,
+	}
+	for _, entity := range []*db.CallbackMessageEntity{first, second} {
+		_, err := s.sut.Create(s.ctx, entity)
+		assert.NoError(t, err)
+	}
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	assert.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+
+	callbacks, err := s.sut.GetUnprocessedOrderedCallbacks(s.ctx, tx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, callbacks, 1)
+	assert.Equal(t, first.ID, callbacks[0].ID)
+
+	now := time.Now()
+	first.ScheduledAt = nil
+	first.DeliveredAt = &now
+	assert.NoError(t, s.sut.UpdateDelivery(s.ctx, tx, first))
+
+	callbacks, err = s.sut.GetUnprocessedOrderedCallbacks(s.ctx, tx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, callbacks, 1)
+	assert.Equal(t, second.ID, callbacks[0].ID)
+}
+
+func (s *CallbackRepositoryTestSuite) TestSupersede() {
+	t := s.T()
+
+	now := time.Now()
+	paymentID := uuid.New()
+
+	pending := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Url:         "http://example.com",
+		Payload:     `{"status": "failed"}`,
+		ScheduledAt: &now,
+	}
+	published := &db.CallbackMessageEntity{
+		ID:        uuid.New(),
+		PaymentID: paymentID,
+		Url:       "http://example.com",
+		Payload:   `{"status": "failed"}`,
+	}
+	for _, entity := range []*db.CallbackMessageEntity{pending, published} {
+		_, err := s.sut.Create(s.ctx, entity)
+		assert.NoError(t, err)
+	}
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	assert.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+
+	published.PublishedAt = &now
+	assert.NoError(t, s.sut.UpdatePublication(s.ctx, tx, published))
+
+	latest := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Url:         "http://example.com",
+		Payload:     `{"status": "successful"}`,
+		ScheduledAt: &now,
+	}
+	_, err = s.sut.CreateTx(s.ctx, tx, latest)
+	assert.NoError(t, err)
+
+	superseded, err := s.sut.Supersede(s.ctx, tx, latest, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{pending.ID}, superseded)
+
+	superseded, err = s.sut.Supersede(s.ctx, tx, latest, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{published.ID}, superseded)
+
+	entity, err := s.sut.SelectForUpdateByID(s.ctx, tx, pending.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, entity.ScheduledAt)
+	assert.Equal(t, latest.ID, *entity.SupersededBy)
+}
+
+func (s *CallbackRepositoryTestSuite) TestSupersede_LateArrival() {
+	t := s.T()
+
+	now := time.Now()
+	paymentID := uuid.New()
+	eventTime := now.Add(-time.Minute)
+
+	newer := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Url:         "http://example.com",
+		Payload:     `{"status": "failed"}`,
+		ScheduledAt: &now,
+		EventTime:   eventTime,
+	}
+	_, err := s.sut.Create(s.ctx, newer)
+	assert.NoError(t, err)
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	assert.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+
+	older := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Url:         "http://example.com",
+		Payload:     `{"status": "successful"}`,
+		ScheduledAt: &now,
+		EventTime:   eventTime.Add(-time.Second),
+	}
+	_, err = s.sut.CreateTx(s.ctx, tx, older)
+	assert.NoError(t, err)
+
+	superseded, err := s.sut.Supersede(s.ctx, tx, older, true)
+	assert.NoError(t, err)
+	assert.Empty(t, superseded)
+	assert.Equal(t, &newer.ID, older.SupersededBy)
+
+	entity, err := s.sut.SelectForUpdateByID(s.ctx, tx, older.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, entity.ScheduledAt)
+	assert.Equal(t, newer.ID, *entity.SupersededBy)
+
+	entity, err = s.sut.SelectForUpdateByID(s.ctx, tx, newer.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, entity.SupersededBy)
+	assert.NotNil(t, entity.ScheduledAt)
+}
+
+func (s *CallbackRepositoryTestSuite) TestSupersede_KeepsEarliestScheduledTime() {
+	t := s.T()
+
+	now := time.Now().Truncate(time.Microsecond)
+	later := now.Add(time.Second)
+	paymentID := uuid.New()
+
+	pending := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Url:         "http://example.com",
+		Payload:     `{"status": "successful"}`,
+		ScheduledAt: &now,
+		EventTime:   now,
+	}
+	_, err := s.sut.Create(s.ctx, pending)
+	assert.NoError(t, err)
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	assert.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+
+	latest := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Url:         "http://example.com",
+		Payload:     `{"status": "failed"}`,
+		ScheduledAt: &later,
+		EventTime:   later,
+	}
+	_, err = s.sut.CreateTx(s.ctx, tx, latest)
+	assert.NoError(t, err)
+
+	superseded, err := s.sut.Supersede(s.ctx, tx, latest, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{pending.ID}, superseded)
+
+	entity, err := s.sut.SelectForUpdateByID(s.ctx, tx, latest.ID)
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(*entity.ScheduledAt), "the window of the superseded callback is kept")
+	assert.Equal(t, latest.Version, entity.Version)
+}
+
+func (s *CallbackRepositoryTestSuite) TestGetStaleCallbacks() {
+	t := s.T()
+
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	payload := `{"key": "value"}`
+
+	stale := &db.CallbackMessageEntity{
+		ID:        uuid.New(),
+		PaymentID: uuid.New(),
+		Url:       "http://example.com",
+		Payload:   payload,
+	}
+	recent := &db.CallbackMessageEntity{
+		ID:        uuid.New(),
+		PaymentID: uuid.New(),
+		Url:       "http://example.com",
+		Payload:   payload,
+	}
+	for _, entity := range []*db.CallbackMessageEntity{stale, recent} {
+		_, err := s.sut.Create(s.ctx, entity)
+		assert.NoError(t, err)
+	}
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	assert.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+
+	stale.PublishedAt = &past
+	recent.PublishedAt = &now
+	assert.NoError(t, s.sut.UpdateDelivery(s.ctx, tx, stale))
+	assert.NoError(t, s.sut.UpdateDelivery(s.ctx, tx, recent))
+
+	callbacks, err := s.sut.GetStaleCallbacks(s.ctx, tx, now.Add(-time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Len(t, callbacks, 1)
+	assert.Equal(t, stale.ID, callbacks[0].ID)
+}
+
+func (s *CallbackRepositoryTestSuite) TestDeleteDeliveredAndFailed() {
+	t := s.T()
+
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	newEntity := func() *db.CallbackMessageEntity {
+		return &db.CallbackMessageEntity{
+			ID:          uuid.New(),
+			PaymentID:   uuid.New(),
+			Url:         "http://example.com",
+			Payload:     `{"key": "value"}`,
+			ScheduledAt: &now,
+		}
+	}
+
+	oldDelivered, recentDelivered, failed, scheduled := newEntity(), newEntity(), newEntity(), newEntity()
+	for _, entity := range []*db.CallbackMessageEntity{oldDelivered, recentDelivered, failed, scheduled} {
+		_, err := s.sut.Create(s.ctx, entity)
+		assert.NoError(t, err)
+	}
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	assert.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+
+	errMsg := "Max delivery attempts reached"
+	oldDelivered.ScheduledAt, oldDelivered.DeliveredAt = nil, &past
+	recentDelivered.ScheduledAt, recentDelivered.DeliveredAt = nil, &now
+	failed.ScheduledAt, failed.Error = nil, &errMsg
+	for _, entity := range []*db.CallbackMessageEntity{oldDelivered, recentDelivered, failed} {
+		assert.NoError(t, s.sut.UpdateDelivery(s.ctx, tx, entity))
+	}
+
+	deleted, err := s.sut.DeleteDelivered(s.ctx, tx, now.Add(-time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.Equal(t, oldDelivered.ID, deleted[0].ID)
+	assert.Equal(t, oldDelivered.Payload, deleted[0].Payload)
+
+	deleted, err = s.sut.DeleteFailed(s.ctx, tx, time.Now().Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.Equal(t, failed.ID, deleted[0].ID)
+	assert.Equal(t, &errMsg, deleted[0].Error)
+
+	var count int
+	err = tx.(pgx.Tx).QueryRow(s.ctx, "SELECT COUNT(*) FROM callback_message").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
 func (s *CallbackRepositoryTestSuite) TestUpdate() {
 	t := s.T()
 
@@ -140,12 +570,218 @@ func (s *CallbackRepositoryTestSuite) TestUpdate() {
 	assert.NoError(t, err)
 
 	entity.DeliveryAttempts = 1
-	err = s.sut.Update(s.ctx, tx, entity)
+	err = s.sut.UpdateDelivery(s.ctx, tx, entity)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), entity.Version)
 
 	updatedEntity, err := s.sut.SelectForUpdateByID(s.ctx, tx, entity.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, updatedEntity.DeliveryAttempts)
+	assert.Equal(t, int64(1), updatedEntity.Version)
+}
+
+func (s *CallbackRepositoryTestSuite) TestUpdate_ConflictsWithStaleEntity() {
+	t := s.T()
+
+	now := time.Now()
+	entity := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   uuid.New(),
+		Url:         "http://example.com",
+		Payload:     `{"key": "value"}`,
+		ScheduledAt: &now,
+	}
+	_, err := s.sut.Create(s.ctx, entity)
+	require.NoError(t, err)
+	stale, err := s.sut.SelectByID(s.ctx, entity.ID)
+	require.NoError(t, err)
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+
+	entity.PublishAttempts = 1
+	require.NoError(t, s.sut.UpdatePublication(s.ctx, tx, entity))
+
+	stale.DeliveryAttempts = 1
+	assert.ErrorIs(t, s.sut.UpdateDelivery(s.ctx, tx, stale), db.ErrConflict)
+}
+
+func (s *CallbackRepositoryTestSuite) TestUpdatePublication_OnlySetsPublicationColumns() {
+	t := s.T()
+
+	now := time.Now()
+	entity := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   uuid.New(),
+		Url:         "http://example.com",
+		Payload:     `{"key": "value"}`,
+		ScheduledAt: &now,
+	}
+	_, err := s.sut.Create(s.ctx, entity)
+	require.NoError(t, err)
+
+	tx, err := s.sut.BeginTx(s.ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(s.ctx)
+
+	entity.Url = "http://example.com/changed"
+	entity.DeliveryAttempts = 3
+	entity.PublishAttempts = 1
+	entity.ScheduledAt = nil
+	entity.PublishedAt = &now
+	require.NoError(t, s.sut.UpdatePublication(s.ctx, tx, entity))
+	require.NoError(t, tx.Commit(s.ctx))
+
+	stored, err := s.sut.SelectByID(s.ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com", stored.Url)
+	assert.Equal(t, 0, stored.DeliveryAttempts)
+	assert.Equal(t, 1, stored.PublishAttempts)
+	assert.Nil(t, stored.ScheduledAt)
+	assert.NotNil(t, stored.PublishedAt)
+}
+
+func (s *CallbackRepositoryTestSuite) TestCreate_RecordsInsertAudit() {
+	t := s.T()
+
+	ctx := db.WithActor(s.ctx, "event.processor")
+	now := time.Now()
+	newEntity := func() *db.CallbackMessageEntity {
+		return &db.CallbackMessageEntity{
+			ID:          uuid.New(),
+			PaymentID:   uuid.New(),
+			Url:         "http://example.com",
+			Payload:     `{"key": "value"}`,
+			ScheduledAt: &now,
+		}
+	}
+
+	created := newEntity()
+	_, err := s.sut.Create(ctx, created)
+	require.NoError(t, err)
+
+	batched := newEntity()
+	tx, err := s.sut.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+	errs, err := s.sut.CreateBatch(ctx, tx, []*db.CallbackMessageEntity{batched})
+	require.NoError(t, err)
+	require.NoError(t, errs[0])
+	require.NoError(t, tx.Commit(ctx))
+
+	for _, entity := range []*db.CallbackMessageEntity{created, batched} {
+		changes, err := s.sut.Audit(s.ctx, entity.ID)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+
+		change := changes[0]
+		assert.Equal(t, entity.ID, change.CallbackID)
+		assert.Equal(t, db.OperationInsert, change.Operation)
+		assert.Equal(t, "event.processor", change.Actor)
+		assert.Equal(t, int64(0), change.Version)
+		assert.Nil(t, change.Before)
+		require.NotNil(t, change.After)
+		assert.Contains(t, *change.After, `"id": "`+entity.ID.String()+`"`)
+		assert.WithinDuration(t, entity.CreatedAt, change.ChangedAt, time.Millisecond)
+	}
+
+	_, err = s.sut.Create(ctx, created)
+	assert.ErrorIs(t, err, db.ErrDuplicate)
+	changes, err := s.sut.Audit(s.ctx, created.ID)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1, "duplicates are not recorded")
+}
+
+func (s *CallbackRepositoryTestSuite) TestAudit() {
+	t := s.T()
+
+	ctx := db.WithActor(s.ctx, "test")
+	now := time.Now()
+	entity := &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   uuid.New(),
+		Url:         "http://example.com",
+		Payload:     `{"key": "value"}`,
+		ScheduledAt: &now,
+	}
+	_, err := s.sut.Create(ctx, entity)
+	require.NoError(t, err)
+
+	tx, err := s.sut.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+	entity.ScheduledAt = nil
+	entity.DeliveredAt = &now
+	entity.DeliveryAttempts = 1
+	require.NoError(t, s.sut.UpdateDelivery(ctx, tx, entity))
+	require.NoError(t, tx.Commit(ctx))
+
+	changes, err := s.sut.Audit(ctx, entity.ID)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+
+	assert.Equal(t, db.OperationInsert, changes[0].Operation)
+	assert.Equal(t, "test", changes[0].Actor)
+	assert.Nil(t, changes[0].Before)
+	require.NotNil(t, changes[0].After)
+	assert.Contains(t, *changes[0].After, `"delivery_attempts": 0`)
+
+	assert.Equal(t, db.OperationUpdate, changes[1].Operation)
+	assert.Equal(t, int64(1), changes[1].Version)
+	require.NotNil(t, changes[1].Before)
+	require.NotNil(t, changes[1].After)
+	assert.Contains(t, *changes[1].Before, `"version": 0`)
+	assert.Contains(t, *changes[1].After, `"delivery_attempts": 1`)
+
+	_, err = s.pool.Exec(s.ctx, "DELETE FROM callback_message_audit WHERE callback_id = $1", entity.ID)
+	assert.ErrorContains(t, err, "append-only")
+}
+
+func (s *CallbackRepositoryTestSuite) TestPurgeAudit() {
+	t := s.T()
+
+	now := time.Now()
+	entity, err := s.sut.Create(s.ctx, &db.CallbackMessageEntity{
+		ID:          uuid.New(),
+		PaymentID:   uuid.New(),
+		Url:         "http://example.com",
+		Payload:     `{"key": "value"}`,
+		ScheduledAt: &now,
+	})
+	require.NoError(t, err)
+
+	_, err = s.sut.PurgeAudit(s.ctx, now.Add(-time.Hour), 100)
+	require.NoError(t, err)
+	changes, err := s.sut.Audit(s.ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1, "changes within the retention are kept")
+
+	// Only the purge function may delete, a role not owning the audit can not mark its own deletes as a purge.
+	for _, statement := range []string{"CREATE ROLE audit_intruder", "GRANT DELETE ON callback_message_audit TO audit_intruder"} {
+		_, err = s.pool.Exec(s.ctx, statement)
+		require.NoError(t, err)
+	}
+	tx, err := s.pool.Begin(s.ctx)
+	require.NoError(t, err)
+	for _, statement := range []string{"SET LOCAL ROLE audit_intruder", "SET LOCAL callback.audit_purge = 'on'"} {
+		_, err = tx.Exec(s.ctx, statement)
+		require.NoError(t, err)
+	}
+	_, err = tx.Exec(s.ctx, "DELETE FROM callback_message_audit WHERE callback_id = $1", entity.ID)
+	assert.ErrorContains(t, err, "append-only")
+	require.NoError(t, tx.Rollback(s.ctx))
+
+	for {
+		n, err := s.sut.PurgeAudit(s.ctx, time.Now().Add(time.Minute), 100)
+		require.NoError(t, err)
+		if n < 100 {
+			break
+		}
+	}
+	changes, err = s.sut.Audit(s.ctx, entity.ID)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
 }
 
 func (s *CallbackRepositoryTestSuite) TestSelectForUpdateByID() {
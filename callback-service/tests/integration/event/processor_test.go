@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"callback-service/internal/clock"
+	"callback-service/internal/config"
 	"callback-service/internal/db"
 	"callback-service/internal/event"
 	"callback-service/internal/message"
@@ -28,8 +30,6 @@ type ProcessorTestSuite struct {
 }
 
 func (s *ProcessorTestSuite) SetupSuite() {
-	time.Local = time.UTC
-
 	s.ctx = context.Background()
 	pgContainer, err := testhelpers.CreatePostgresContainer(s.ctx)
 	if err != nil {
@@ -37,16 +37,18 @@ func (s *ProcessorTestSuite) SetupSuite() {
 	}
 	s.pgContainer = pgContainer
 
-	db.RunMigrations(pgContainer.ConnectionString, "../../../migrations")
+	if err := db.RunMigrations(s.ctx, pgContainer.ConnectionString, config.DatabaseMigrations{}, slog.Default()); err != nil {
+		log.Fatal(err)
+	}
 
-	pool, err := db.GetPool(pgContainer.ConnectionString)
+	pool, err := db.GetPool(pgContainer.ConnectionString, config.Database{})
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	s.pool = pool
-	s.repo = db.NewCallbackRepository(pool)
-	s.sut = event.NewProcessor(s.repo, slog.Default())
+	s.repo = db.NewCallbackRepository(pool, 0, clock.System, false)
+	s.sut = event.NewProcessor(s.repo, config.CallbackSupersession{Policy: config.SupersessionNone}, config.EventValidation{}, slog.Default())
 }
 
 func (s *ProcessorTestSuite) TearDownSuite() {
@@ -62,21 +64,30 @@ func (s *ProcessorTestSuite) SetupTest() {
 	if err != nil {
 		log.Fatalf("error truncating callback_message table: %s", err)
 	}
+
+	_, err = s.pool.Exec(s.ctx, "DELETE FROM callback_message_key")
+	if err != nil {
+		log.Fatalf("error truncating callback_message_key table: %s", err)
+	}
 }
 
 func (s *ProcessorTestSuite) TestProcess_Success() {
 	t := s.T()
 
 	event := message.PaymentEvent{
-		ID: uuid.New(),
+		ID:    uuid.New(),
+		Event: "updated",
 		Payload: payload.Payment{
 			ID:          uuid.New(),
+			Amount:      100,
+			Currency:    "EUR",
 			Status:      "completed",
+			CreatedAt:   time.Now(),
 			CallbackUrl: "http://example.com/callback",
 		},
 	}
 
-	err := s.sut.Process(s.ctx, event)
+	_, err := s.sut.Process(s.ctx, event)
 	assert.NoError(t, err)
 
 	entity, err := s.repo.SelectByID(s.ctx, event.ID)
@@ -87,6 +98,120 @@ func (s *ProcessorTestSuite) TestProcess_Success() {
 	assert.WithinDuration(t, time.Now(), *entity.ScheduledAt, time.Second)
 }
 
+func (s *ProcessorTestSuite) TestProcess_Duplicate() {
+	t := s.T()
+
+	e := message.PaymentEvent{
+		ID:    uuid.New(),
+		Event: "updated",
+		Payload: payload.Payment{
+			ID:          uuid.New(),
+			Amount:      100,
+			Currency:    "EUR",
+			Status:      "successful",
+			CreatedAt:   time.Now(),
+			CallbackUrl: "http://example.com/callback",
+		},
+	}
+
+	result, err := s.sut.Process(s.ctx, e)
+	assert.NoError(t, err)
+	assert.Equal(t, event.ResultCreated, result)
+
+	result, err = s.sut.Process(s.ctx, e)
+	assert.NoError(t, err)
+	assert.Equal(t, event.ResultDuplicate, result)
+
+	var count int
+	err = s.pool.QueryRow(s.ctx, "SELECT COUNT(*) FROM callback_message WHERE payment_id = $1", e.Payload.ID).Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func (s *ProcessorTestSuite) TestProcess_RejectsInvalidEvent() {
+	t := s.T()
+
+	e := message.PaymentEvent{
+		ID:    uuid.New(),
+		Event: "updated",
+		Payload: payload.Payment{
+			ID:          uuid.New(),
+			Amount:      -100,
+			Currency:    "EUR",
+			Status:      "successful",
+			CreatedAt:   time.Now(),
+			CallbackUrl: "http://example.com/callback",
+		},
+	}
+
+	result, err := s.sut.Process(s.ctx, e)
+	assert.Equal(t, event.ResultRejected, result)
+	var validationErr *event.ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+
+	_, err = s.repo.SelectByID(s.ctx, e.ID)
+	assert.Error(t, err)
+}
+
+func (s *ProcessorTestSuite) TestProcessBatch() {
+	t := s.T()
+
+	newEvent := func(paymentID uuid.UUID, status string) message.PaymentEvent {
+		return message.PaymentEvent{
+			ID:    uuid.New(),
+			Event: "updated",
+			Payload: payload.Payment{
+				ID:          paymentID,
+				Amount:      100,
+				Currency:    "EUR",
+				Status:      status,
+				CreatedAt:   time.Now(),
+				CallbackUrl: "http://example.com/callback",
+			},
+		}
+	}
+
+	paymentID := uuid.New()
+	stored := newEvent(uuid.New(), "successful")
+	_, err := s.sut.Process(s.ctx, stored)
+	assert.NoError(t, err)
+
+	first := newEvent(paymentID, "failed")
+	second := newEvent(paymentID, "successful")
+	invalid := newEvent(uuid.New(), "successful")
+	invalid.Payload.Currency = "XXY"
+
+	results := s.sut.ProcessBatch(s.ctx, []event.BatchEvent{
+		{Ctx: s.ctx, Event: first},
+		{Ctx: s.ctx, Event: stored},
+		{Ctx: s.ctx, Event: newEvent(uuid.New(), "pending")},
+		{Ctx: s.ctx, Event: invalid},
+		{Ctx: s.ctx, Event: second},
+		{Ctx: s.ctx, Event: second},
+	})
+
+	assert.Len(t, results, 6)
+	assert.Equal(t, event.BatchResult{Result: event.ResultCreated}, results[0])
+	assert.Equal(t, event.BatchResult{Result: event.ResultDuplicate}, results[1])
+	assert.Equal(t, event.BatchResult{Result: event.ResultDropped}, results[2])
+	assert.Equal(t, event.ResultRejected, results[3].Result)
+	assert.Error(t, results[3].Err)
+	assert.Equal(t, event.BatchResult{Result: event.ResultCreated}, results[4])
+	assert.Equal(t, event.BatchResult{Result: event.ResultDuplicate}, results[5])
+
+	firstEntity, err := s.repo.SelectByID(s.ctx, first.ID)
+	assert.NoError(t, err)
+	secondEntity, err := s.repo.SelectByID(s.ctx, second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), firstEntity.Sequence)
+	assert.Equal(t, int64(2), secondEntity.Sequence)
+
+	var count int
+	err = s.pool.QueryRow(s.ctx, "SELECT COUNT(*) FROM callback_message").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
 func TestProcessorTestSuite(t *testing.T) {
 	suite.Run(t, new(ProcessorTestSuite))
 }
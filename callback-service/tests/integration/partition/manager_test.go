@@ -0,0 +1,170 @@
+package partition
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"testing"
+	"time"
+
+	"callback-service/internal/clock"
+	"callback-service/internal/config"
+	"callback-service/internal/db"
+	"callback-service/internal/partition"
+	"callback-service/tests/testhelpers"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ManagerTestSuite struct {
+	suite.Suite
+	pgContainer *testhelpers.PostgresContainer
+	pool        *pgxpool.Pool
+	repo        *db.CallbackRepository
+	sut         *partition.Manager
+	ctx         context.Context
+}
+
+func (s *ManagerTestSuite) SetupSuite() {
+	s.ctx = context.Background()
+	pgContainer, err := testhelpers.CreatePostgresContainer(s.ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.pgContainer = pgContainer
+
+	if err := db.RunMigrations(s.ctx, pgContainer.ConnectionString, config.DatabaseMigrations{}, slog.Default()); err != nil {
+		log.Fatal(err)
+	}
+
+	pool, err := db.GetPool(pgContainer.ConnectionString, config.Database{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.pool = pool
+	s.repo = db.NewCallbackRepository(pool, 0, clock.System, false)
+	s.sut = partition.NewManager(s.repo, config.DatabasePartitions{
+		IntervalMs:    60000,
+		PremakeDays:   3,
+		RetentionDays: 5,
+		LockTimeoutMs: 1000,
+		KeyBatchSize:  1,
+	}, slog.Default())
+}
+
+func (s *ManagerTestSuite) TearDownSuite() {
+	s.pool.Close()
+
+	if err := s.pgContainer.Terminate(s.ctx); err != nil {
+		log.Fatalf("error terminating postgres container: %s", err)
+	}
+}
+
+func (s *ManagerTestSuite) partitionNames() []string {
+	tx, err := s.repo.BeginTx(s.ctx)
+	s.Require().NoError(err)
+	defer tx.Rollback(s.ctx)
+
+	partitions, err := s.repo.Partitions(s.ctx, tx)
+	s.Require().NoError(err)
+
+	names := make([]string, len(partitions))
+	for i, p := range partitions {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// insertCallback inserts a callback created at the given time, which the repository always sets to the current time.
+func (s *ManagerTestSuite) insertCallback(createdAt time.Time, scheduled bool) uuid.UUID {
+	id := uuid.New()
+	var scheduledAt *time.Time
+	if scheduled {
+		scheduledAt = &createdAt
+	}
+
+	_, err := s.pool.Exec(s.ctx, `INSERT INTO callback_message (id, payment_id, payload, url, created_at, updated_at, scheduled_at, event_time)
+	                              VALUES ($1, $2, '{}', 'http://example.com', $3, $3, $4, $3)`, id, uuid.New(), createdAt, scheduledAt)
+	s.Require().NoError(err)
+	_, err = s.pool.Exec(s.ctx, `INSERT INTO callback_message_key (id, created_at) VALUES ($1, $2)`, id, createdAt)
+	s.Require().NoError(err)
+	return id
+}
+
+func (s *ManagerTestSuite) TestRun() {
+	t := s.T()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	tx, err := s.repo.BeginTx(s.ctx)
+	require.NoError(t, err)
+	for _, daysAgo := range []int{10, 9, 8} {
+		require.NoError(t, s.repo.CreatePartition(s.ctx, tx, today.AddDate(0, 0, -daysAgo)))
+	}
+	require.NoError(t, tx.Commit(s.ctx))
+
+	finished := s.insertCallback(today.AddDate(0, 0, -10).Add(time.Hour), false)
+	unfinished := s.insertCallback(today.AddDate(0, 0, -9).Add(time.Hour), true)
+	finishedToo := s.insertCallback(today.AddDate(0, 0, -8).Add(2*time.Hour), false)
+	recent := s.insertCallback(today.Add(time.Hour), true)
+
+	require.NoError(t, s.sut.Run(s.ctx))
+
+	names := s.partitionNames()
+	assert.NotContains(t, names, db.PartitionName(today.AddDate(0, 0, -10)))
+	assert.Contains(t, names, db.PartitionName(today.AddDate(0, 0, -9)), "partitions with unfinished callbacks are kept")
+	assert.NotContains(t, names, db.PartitionName(today.AddDate(0, 0, -8)))
+	for i := 0; i <= 3; i++ {
+		assert.Contains(t, names, db.PartitionName(today.AddDate(0, 0, i)))
+	}
+
+	for _, id := range []uuid.UUID{finished, finishedToo} {
+		var keys int
+		require.NoError(t, s.pool.QueryRow(s.ctx, "SELECT COUNT(*) FROM callback_message_key WHERE id = $1", id).Scan(&keys))
+		assert.Zero(t, keys, "keys of dropped partitions are deleted")
+	}
+
+	for _, id := range []uuid.UUID{unfinished, recent} {
+		entity, err := s.repo.SelectByID(s.ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, id, entity.ID)
+	}
+
+	// A second run has nothing left to do.
+	require.NoError(t, s.sut.Run(s.ctx))
+	assert.Equal(t, names, s.partitionNames())
+}
+
+func (s *ManagerTestSuite) TestRun_MovesCallbacksOutOfDefaultPartition() {
+	t := s.T()
+
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 20)
+	id := s.insertCallback(day.Add(time.Hour), true)
+
+	partitionOf := func() string {
+		var name string
+		require.NoError(t, s.pool.QueryRow(s.ctx, "SELECT tableoid::regclass::text FROM callback_message WHERE id = $1", id).Scan(&name))
+		return name
+	}
+	assert.Equal(t, "callback_message_default", partitionOf())
+
+	manager := partition.NewManager(s.repo, config.DatabasePartitions{
+		IntervalMs:    60000,
+		PremakeDays:   20,
+		LockTimeoutMs: 1000,
+		KeyBatchSize:  1,
+	}, slog.Default())
+	require.NoError(t, manager.Run(s.ctx))
+
+	assert.Equal(t, db.PartitionName(day), partitionOf())
+	entity, err := s.repo.SelectByID(s.ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, id, entity.ID)
+}
+
+func TestManagerTestSuite(t *testing.T) {
+	suite.Run(t, new(ManagerTestSuite))
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"callback-service/internal/callback"
+	"callback-service/internal/clock"
+	"callback-service/internal/codec"
+	"callback-service/internal/config"
+	"callback-service/internal/consumer"
+	"callback-service/internal/db"
+	"callback-service/internal/event"
+	"callback-service/internal/inprocess"
+	"callback-service/internal/message"
+	"callback-service/internal/payload"
+	"callback-service/internal/schemaregistry"
+	"callback-service/internal/schemaregistry/schemaregistrytest"
+	"callback-service/internal/server"
+	"callback-service/tests/testhelpers"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ServerTestSuite struct {
+	suite.Suite
+	pgContainer *testhelpers.PostgresContainer
+	pool        *pgxpool.Pool
+	repo        *db.CallbackRepository
+	sut         *server.Server
+	ctx         context.Context
+}
+
+func (s *ServerTestSuite) SetupSuite() {
+	s.ctx = context.Background()
+	pgContainer, err := testhelpers.CreatePostgresContainer(s.ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.pgContainer = pgContainer
+
+	if err := db.RunMigrations(s.ctx, pgContainer.ConnectionString, config.DatabaseMigrations{}, slog.Default()); err != nil {
+		log.Fatal(err)
+	}
+
+	pool, err := db.GetPool(pgContainer.ConnectionString, config.Database{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.pool = pool
+	s.repo = db.NewCallbackRepository(pool, 0, clock.System, false)
+	processor := event.NewProcessor(s.repo, config.CallbackSupersession{Policy: config.SupersessionNone}, config.EventValidation{EventTypes: []string{"updated"}}, slog.Default())
+	s.sut = server.NewServer(config.Server{Port: "0", MaxBodyBytes: 1 << 20, MaxBatchSize: 100, IdempotencyTTLMs: 60000}, processor, slog.Default())
+}
+
+func (s *ServerTestSuite) TearDownSuite() {
+	s.pool.Close()
+
+	if err := s.pgContainer.Terminate(s.ctx); err != nil {
+		log.Fatalf("error terminating postgres container: %s", err)
+	}
+}
+
+func (s *ServerTestSuite) SetupTest() {
+	_, err := s.pool.Exec(s.ctx, "DELETE FROM callback_message")
+	if err != nil {
+		log.Fatalf("error truncating callback_message table: %s", err)
+	}
+
+	_, err = s.pool.Exec(s.ctx, "DELETE FROM callback_message_key")
+	if err != nil {
+		log.Fatalf("error truncating callback_message_key table: %s", err)
+	}
+}
+
+func (s *ServerTestSuite) postEvent(e any) *httptest.ResponseRecorder {
+	body, err := json.Marshal(e)
+	require.NoError(s.T(), err)
+
+	rec := httptest.NewRecorder()
+	s.sut.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/events", bytes.NewReader(body)))
+	return rec
+}
+
+func newEvent(callbackURL string) message.PaymentEvent {
+	return message.PaymentEvent{
+		ID:    uuid.New(),
+		Event: "updated",
+		Payload: payload.Payment{
+			ID:          uuid.New(),
+			Amount:      100,
+			Currency:    "EUR",
+			Status:      "successful",
+			CreatedAt:   time.Now(),
+			CallbackUrl: callbackURL,
+		},
+	}
+}
+
+func (s *ServerTestSuite) TestPostEvent_StoresCallback() {
+	t := s.T()
+	e := newEvent("http://example.com/callback")
+
+	rec := s.postEvent(e)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	entity, err := s.repo.SelectByID(s.ctx, e.ID)
+	require.NoError(t, err)
+	assert.Equal(t, e.Payload.ID, entity.PaymentID)
+	assert.NotNil(t, entity.ScheduledAt)
+}
+
+func (s *ServerTestSuite) TestPostBatch_ReturnsResultPerEvent() {
+	t := s.T()
+	created := newEvent("http://example.com/callback")
+	dropped := newEvent("http://example.com/callback")
+	dropped.Payload.Status = "pending"
+	invalid := newEvent("http://example.com/callback")
+	invalid.Payload.Currency = "XYZ"
+
+	rec := s.postEvent([]message.PaymentEvent{created, created, dropped, invalid})
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response struct {
+		Results []struct {
+			Index  int    `json:"index"`
+			ID     string `json:"id"`
+			Result string `json:"result"`
+		} `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.Len(t, response.Results, 4)
+	assert.Equal(t, "created", response.Results[0].Result)
+	assert.Equal(t, "duplicate", response.Results[1].Result)
+	assert.Equal(t, created.ID.String(), response.Results[1].ID)
+	assert.Equal(t, "dropped", response.Results[2].Result)
+	assert.Equal(t, 2, response.Results[2].Index)
+	assert.Equal(t, "invalid", response.Results[3].Result)
+}
+
+func (s *ServerTestSuite) TestPostEvent_DuplicateIsNotAnError() {
+	t := s.T()
+	e := newEvent("http://example.com/callback")
+
+	assert.Equal(t, http.StatusCreated, s.postEvent(e).Code)
+	assert.Equal(t, http.StatusOK, s.postEvent(e).Code)
+}
+
+// TestInProcessDelivery runs the single binary mode: events posted over HTTP are delivered to the merchant through
+// the in-process broker.
+func (s *ServerTestSuite) TestInProcessDelivery() {
+	t := s.T()
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	var received atomic.Int32
+	merchant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer merchant.Close()
+
+	registryServer := schemaregistrytest.NewServer()
+	defer registryServer.Close()
+	registry := schemaregistry.NewClient(config.SchemaRegistry{URL: registryServer.URL, TimeoutMs: 1000})
+	callbackCodec, err := codec.NewCallbackCodec(config.CodecProtobuf, registry, "callback-messages", true)
+	require.NoError(t, err)
+
+	b := inprocess.NewBroker(10)
+	producer := callback.NewProducer(s.repo, b.NewPublisher("callback-messages"), callbackCodec, config.CallbackProducer{
+		PollingIntervalMs:  50,
+		FetchSize:          10,
+		RescheduleDelayMs:  1000,
+		MaxPublishAttempts: 3,
+	}, slog.Default())
+	go producer.Start(ctx)
+
+	sender := callback.NewSender(config.CallbackSender{TimeoutMs: 1000}, slog.Default())
+	processor := callback.NewCallbackProcessor(s.repo, sender, config.CallbackProcessor{RescheduleDelayMs: 1000, MaxDeliveryAttempts: 3},
+		db.RetryPolicy{MaxAttempts: 1}, nil, slog.Default())
+	consumer.ReadCallbackMessages(b.NewSubscriber("callback-messages"), config.BrokerConsumer{Workers: 2, QueueSize: 10}, callbackCodec, processor, slog.Default())
+
+	e := newEvent(merchant.URL)
+	assert.Equal(t, http.StatusCreated, s.postEvent(e).Code)
+
+	assert.Eventually(t, func() bool {
+		entity, err := s.repo.SelectByID(s.ctx, e.ID)
+		return err == nil && entity.DeliveredAt != nil
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, int32(1), received.Load())
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(ServerTestSuite))
+}
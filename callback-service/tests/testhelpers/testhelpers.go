@@ -2,10 +2,14 @@ package testhelpers
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/kafka"
+	"github.com/testcontainers/testcontainers-go/modules/nats"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/modules/redis"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
 
@@ -37,3 +41,66 @@ func CreatePostgresContainer(ctx context.Context) (*PostgresContainer, error) {
 		ConnectionString:  connStr,
 	}, nil
 }
+
+type KafkaContainer struct {
+	*kafka.KafkaContainer
+	Brokers string
+}
+
+func CreateKafkaContainer(ctx context.Context) (*KafkaContainer, error) {
+	kafkaContainer, err := kafka.Run(ctx, "confluentinc/confluent-local:7.5.0", kafka.WithClusterID("test-cluster"))
+	if err != nil {
+		return nil, err
+	}
+	brokers, err := kafkaContainer.Brokers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaContainer{
+		KafkaContainer: kafkaContainer,
+		Brokers:        strings.Join(brokers, ","),
+	}, nil
+}
+
+type NatsContainer struct {
+	*nats.NATSContainer
+	URL string
+}
+
+func CreateNatsContainer(ctx context.Context) (*NatsContainer, error) {
+	natsContainer, err := nats.Run(ctx, "nats:2.10")
+	if err != nil {
+		return nil, err
+	}
+	url, err := natsContainer.ConnectionString(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NatsContainer{
+		NATSContainer: natsContainer,
+		URL:           url,
+	}, nil
+}
+
+type RedisContainer struct {
+	*redis.RedisContainer
+	Address string
+}
+
+func CreateRedisContainer(ctx context.Context) (*RedisContainer, error) {
+	redisContainer, err := redis.Run(ctx, "redis:7")
+	if err != nil {
+		return nil, err
+	}
+	address, err := redisContainer.Endpoint(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisContainer{
+		RedisContainer: redisContainer,
+		Address:        address,
+	}, nil
+}